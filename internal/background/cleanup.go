@@ -1,32 +1,111 @@
 package background
 
 import (
+	"context"
+	"sync"
 	"time"
 
 	"github.com/rs/zerolog/log"
 	"github.com/soerenchrist/go_home/internal/value"
-	"gorm.io/gorm"
 )
 
-func CleanupExpiredSensorValues(db *gorm.DB) {
+type CleanupDatabase interface {
+	DeleteExpiredSensorValues(before time.Time, rolledUpBefore time.Time, limit int) (int64, error)
+	RollupWatermark(tier value.Tier) (time.Time, error)
+}
 
-	go func() {
-		for {
-			log.Debug().Msg("Cleaning up values")
-			values := make([]value.SensorValue, 0)
-			result := db.Where("expires_at < ?", time.Now()).Find(&values)
-			if result.Error != nil {
-				log.Error().Err(result.Error).Msg("failed to fetch expired values")
-			}
+type CleanupStats struct {
+	Interval     string     `json:"interval"`
+	BatchSize    int        `json:"batch_size"`
+	Runs         uint64     `json:"runs"`
+	Failed       uint64     `json:"failed"`
+	Deleted      uint64     `json:"deleted"`
+	LastDeleted  int64      `json:"last_deleted"`
+	LastRun      *time.Time `json:"last_run,omitempty"`
+	LastDuration string     `json:"last_duration"`
+}
+
+// Cleanup deletes expired sensor values every interval. Values are deleted
+// in batches, so that the database is not locked for long by a large backlog.
+type Cleanup struct {
+	database  CleanupDatabase
+	interval  time.Duration
+	batchSize int
+
+	mu    sync.Mutex
+	stats CleanupStats
+}
+
+func NewCleanup(database CleanupDatabase, interval time.Duration, batchSize int) *Cleanup {
+	if batchSize < 1 {
+		batchSize = 1000
+	}
+	return &Cleanup{
+		database:  database,
+		interval:  interval,
+		batchSize: batchSize,
+		stats:     CleanupStats{Interval: interval.String(), BatchSize: batchSize},
+	}
+}
 
-			for _, value := range values {
-				result := db.Delete(value)
-				if result.Error != nil {
-					log.Error().Err(result.Error).Uint("value_id", value.ID).Msg("Failed to delete valuev")
-				}
+// Run deletes expired values every interval until ctx is cancelled.
+func (c *Cleanup) Run(ctx context.Context) error {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := c.Cleanup(ctx, time.Now()); err != nil {
+				log.Error().Err(err).Msg("Failed to clean up expired values")
 			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// Cleanup deletes all values that expired before now and returns their number.
+// Values, that are not rolled up into the hourly tier yet, are kept until they are.
+// It stops between two batches, when ctx is cancelled.
+func (c *Cleanup) Cleanup(ctx context.Context, now time.Time) (int64, error) {
+	start := time.Now()
+	var deleted int64
 
-			time.Sleep(10 * time.Second)
+	watermark, err := c.database.RollupWatermark(value.TierHourly)
+	for err == nil && ctx.Err() == nil {
+		var count int64
+		count, err = c.database.DeleteExpiredSensorValues(now, watermark, c.batchSize)
+		deleted += count
+		if err != nil || count < int64(c.batchSize) {
+			break
 		}
-	}()
+	}
+
+	c.record(deleted, err, start)
+	if deleted > 0 {
+		log.Debug().Int64("deleted", deleted).Msg("Cleaned up expired values")
+	}
+	return deleted, err
+}
+
+func (c *Cleanup) record(deleted int64, err error, start time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.stats.Runs++
+	if err != nil {
+		c.stats.Failed++
+	}
+	c.stats.Deleted += uint64(deleted)
+	c.stats.LastDeleted = deleted
+	c.stats.LastRun = &start
+	c.stats.LastDuration = time.Since(start).String()
+}
+
+func (c *Cleanup) Stats() CleanupStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.stats
 }
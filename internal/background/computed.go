@@ -0,0 +1,232 @@
+package background
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/soerenchrist/go_home/internal/sensor"
+	"github.com/soerenchrist/go_home/internal/value"
+	"github.com/soerenchrist/go_home/pkg/expression"
+	"github.com/soerenchrist/go_home/pkg/output"
+)
+
+type ComputedDatabase interface {
+	ListComputedSensors() ([]sensor.Sensor, error)
+	GetCurrentSensorValue(deviceId, sensorId string) (*value.SensorValue, error)
+	AddSensorValue(value *value.SensorValue) error
+}
+
+// ComputedSensors re-evaluates the expressions of computed sensors whenever
+// one of their input sensors receives a value. The results are stored and
+// pushed like any other sensor value, so computed sensors can depend on each
+// other. Sensors that are part of a dependency cycle are never evaluated.
+//
+// Incoming values are coalesced per input sensor, so a burst of values never
+// drops a recomputation. The dependency graph is cached and rebuilt after
+// sensors have been changed.
+type ComputedSensors struct {
+	database ComputedDatabase
+	bindings *output.OutputBindingsManager
+
+	mu       sync.Mutex
+	triggers map[string]time.Time
+	stale    bool
+	notify   chan struct{}
+
+	// graph is only used by Run
+	graph *dependencyGraph
+}
+
+type dependencyGraph struct {
+	// dependents maps deviceId.sensorId to the active computed sensors using it
+	dependents map[string][]sensor.Sensor
+	cyclic     map[string]bool
+}
+
+func NewComputedSensors(database ComputedDatabase, bindings *output.OutputBindingsManager) *ComputedSensors {
+	return &ComputedSensors{
+		database: database,
+		bindings: bindings,
+		triggers: make(map[string]time.Time),
+		stale:    true,
+		notify:   make(chan struct{}, 1),
+	}
+}
+
+func (c *ComputedSensors) Handle(v output.BindingValue) {
+	if v.Event != "" {
+		return
+	}
+
+	key := v.DeviceID + "." + v.SensorID
+	c.mu.Lock()
+	if last, ok := c.triggers[key]; !ok || v.Timestamp.After(last) {
+		c.triggers[key] = v.Timestamp
+	}
+	c.mu.Unlock()
+	c.wake()
+}
+
+func (c *ComputedSensors) SensorCreated(s *sensor.Sensor) {
+	c.invalidate()
+}
+
+func (c *ComputedSensors) SensorUpdated(s *sensor.Sensor) {
+	c.invalidate()
+}
+
+func (c *ComputedSensors) SensorDeleted(deviceId string, sensorId string) {
+	c.invalidate()
+}
+
+func (c *ComputedSensors) DeviceDeleted(deviceId string) {
+	c.invalidate()
+}
+
+// invalidate rebuilds the dependency graph before the next values are processed.
+func (c *ComputedSensors) invalidate() {
+	c.mu.Lock()
+	c.stale = true
+	c.mu.Unlock()
+}
+
+func (c *ComputedSensors) wake() {
+	select {
+	case c.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Run processes incoming values until ctx is cancelled.
+func (c *ComputedSensors) Run(ctx context.Context) error {
+	for {
+		select {
+		case <-c.notify:
+			c.processTriggers()
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (c *ComputedSensors) processTriggers() {
+	c.mu.Lock()
+	triggers := c.triggers
+	c.triggers = make(map[string]time.Time)
+	stale := c.stale
+	c.stale = false
+	c.mu.Unlock()
+
+	if stale || c.graph == nil {
+		graph, err := c.buildGraph()
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to list computed sensors")
+			c.invalidate()
+			return
+		}
+		c.graph = graph
+	}
+
+	for key, timestamp := range triggers {
+		c.update(key, timestamp)
+	}
+}
+
+func (c *ComputedSensors) buildGraph() (*dependencyGraph, error) {
+	computed, err := c.database.ListComputedSensors()
+	if err != nil {
+		return nil, err
+	}
+
+	graph := &dependencyGraph{
+		dependents: make(map[string][]sensor.Sensor),
+		cyclic:     sensor.CyclicSensors(computed),
+	}
+	for _, s := range computed {
+		if !s.IsActive {
+			continue
+		}
+		inputs, err := s.Inputs()
+		if err != nil {
+			continue
+		}
+		for _, input := range inputs {
+			graph.dependents[input] = append(graph.dependents[input], s)
+		}
+	}
+	return graph, nil
+}
+
+func (c *ComputedSensors) update(key string, timestamp time.Time) {
+	dependents := c.graph.dependents[key]
+	for i := range dependents {
+		s := &dependents[i]
+
+		logger := log.With().Str("sensor_id", s.ID).Str("device_id", s.DeviceID).Logger()
+		if c.graph.cyclic[s.Key()] {
+			logger.Warn().Msg("Skipped computed sensor, its expression has a dependency cycle")
+			continue
+		}
+
+		result, err := c.evaluate(s)
+		if err != nil {
+			logger.Error().Err(err).Msg("Failed to evaluate computed sensor")
+			continue
+		}
+
+		sensorValue := value.NewSensorValue(s, result, timestamp)
+		if err := c.database.AddSensorValue(sensorValue); err != nil {
+			logger.Error().Err(err).Msg("Failed to save computed value")
+			continue
+		}
+
+		c.bindings.Push(sensorValue.ToBindingValue())
+		logger.Debug().Str("value", result).Msg("Computed sensor value")
+	}
+}
+
+func (c *ComputedSensors) evaluate(s *sensor.Sensor) (string, error) {
+	expr, err := expression.Parse(s.Expression)
+	if err != nil {
+		return "", err
+	}
+
+	result, err := expr.Evaluate(func(name string) (float64, error) {
+		parts := strings.Split(name, ".")
+		if len(parts) != 2 {
+			return 0, fmt.Errorf("invalid sensor %s", name)
+		}
+
+		current, err := c.database.GetCurrentSensorValue(parts[0], parts[1])
+		if err != nil {
+			return 0, fmt.Errorf("no current value for sensor %s", name)
+		}
+
+		if b, err := strconv.ParseBool(current.Value); err == nil {
+			if b {
+				return 1, nil
+			}
+			return 0, nil
+		}
+
+		f, err := strconv.ParseFloat(current.Value, 64)
+		if err != nil {
+			return 0, fmt.Errorf("value %s of sensor %s is not a number", current.Value, name)
+		}
+		return f, nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if s.DataType == sensor.DataTypeInt {
+		return strconv.FormatInt(int64(math.Round(result)), 10), nil
+	}
+	return formatFloat(result), nil
+}
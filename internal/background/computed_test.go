@@ -0,0 +1,167 @@
+package background_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/soerenchrist/go_home/internal/background"
+	"github.com/soerenchrist/go_home/internal/sensor"
+	"github.com/soerenchrist/go_home/internal/value"
+	"github.com/soerenchrist/go_home/pkg/output"
+)
+
+type fakeComputedDatabase struct {
+	mu       sync.Mutex
+	computed []sensor.Sensor
+	values   map[string]string
+}
+
+func (db *fakeComputedDatabase) ListComputedSensors() ([]sensor.Sensor, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return db.computed, nil
+}
+
+func (db *fakeComputedDatabase) GetCurrentSensorValue(deviceId, sensorId string) (*value.SensorValue, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	v, ok := db.values[deviceId+"."+sensorId]
+	if !ok {
+		return nil, fmt.Errorf("not found")
+	}
+	return &value.SensorValue{DeviceID: deviceId, SensorID: sensorId, Value: v}, nil
+}
+
+func (db *fakeComputedDatabase) AddSensorValue(v *value.SensorValue) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.values[v.DeviceID+"."+v.SensorID] = v.Value
+	return nil
+}
+
+func (db *fakeComputedDatabase) value(key string) (string, bool) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	v, ok := db.values[key]
+	return v, ok
+}
+
+func computedSensor(id string, dataType sensor.DataType, expression string) sensor.Sensor {
+	return sensor.Sensor{ID: id, DeviceID: "1", DataType: dataType, Type: sensor.SensorTypeComputed, IsActive: true, Expression: expression}
+}
+
+func startComputedSensors(t *testing.T, database *fakeComputedDatabase) *output.OutputBindingsManager {
+	bindings := output.NewManager()
+	computed := background.NewComputedSensors(database, bindings)
+	bindings.Register(computed)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go computed.Run(ctx)
+
+	return bindings
+}
+
+func TestComputedSensors_ShouldUpdateDependentSensors_WhenInputChanges(t *testing.T) {
+	database := &fakeComputedDatabase{
+		computed: []sensor.Sensor{
+			computedSensor("C1", sensor.DataTypeFloat, "${1.S1} * 1.8 + 32"),
+			computedSensor("C2", sensor.DataTypeInt, "round(${1.C1})"),
+		},
+		values: map[string]string{"1.S1": "21.5"},
+	}
+	bindings := startComputedSensors(t, database)
+
+	bindings.Push(output.BindingValue{DeviceID: "1", SensorID: "S1", Value: "21.5", Timestamp: time.Now()})
+
+	waitFor(t, func() bool {
+		v, ok := database.value("1.C2")
+		return ok && v == "71"
+	})
+	if v, _ := database.value("1.C1"); v != "70.7" {
+		t.Errorf("Expected C1 to be 70.7, got %s", v)
+	}
+}
+
+func TestComputedSensors_ShouldSkipSensors_WhenTheyAreCyclic(t *testing.T) {
+	database := &fakeComputedDatabase{
+		computed: []sensor.Sensor{
+			computedSensor("C1", sensor.DataTypeFloat, "${1.S1} + ${1.C2}"),
+			computedSensor("C2", sensor.DataTypeFloat, "${1.C1} + 1"),
+			computedSensor("C3", sensor.DataTypeFloat, "${1.S1} / 0"),
+			computedSensor("C4", sensor.DataTypeFloat, "${1.S1} + ${1.S9}"),
+			computedSensor("C5", sensor.DataTypeFloat, "${1.S1} - 1"),
+		},
+		values: map[string]string{"1.S1": "2", "1.C2": "1"},
+	}
+	bindings := startComputedSensors(t, database)
+
+	bindings.Push(output.BindingValue{DeviceID: "1", SensorID: "S1", Value: "2", Timestamp: time.Now()})
+
+	waitFor(t, func() bool {
+		_, ok := database.value("1.C5")
+		return ok
+	})
+	for _, key := range []string{"1.C1", "1.C3", "1.C4"} {
+		if v, ok := database.value(key); ok {
+			t.Errorf("Expected no value for %s, got %s", key, v)
+		}
+	}
+}
+
+func TestComputedSensors_ShouldNotDropValues_WhenManyValuesArrive(t *testing.T) {
+	database := &fakeComputedDatabase{
+		computed: []sensor.Sensor{computedSensor("C1", sensor.DataTypeInt, "${1.S1} + 1")},
+		values:   map[string]string{},
+	}
+	bindings := startComputedSensors(t, database)
+
+	for i := 0; i <= 1000; i++ {
+		database.AddSensorValue(&value.SensorValue{DeviceID: "1", SensorID: "S1", Value: fmt.Sprint(i)})
+		bindings.Push(output.BindingValue{DeviceID: "1", SensorID: "S1", Value: fmt.Sprint(i), Timestamp: time.Now()})
+	}
+
+	waitFor(t, func() bool {
+		v, ok := database.value("1.C1")
+		return ok && v == "1001"
+	})
+}
+
+func TestComputedSensors_ShouldRebuildDependencies_WhenSensorsChange(t *testing.T) {
+	database := &fakeComputedDatabase{
+		computed: []sensor.Sensor{computedSensor("C1", sensor.DataTypeInt, "${1.S1} + 1")},
+		values:   map[string]string{"1.S1": "1", "1.S2": "10"},
+	}
+	bindings := output.NewManager()
+	computed := background.NewComputedSensors(database, bindings)
+	bindings.Register(computed)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go computed.Run(ctx)
+
+	bindings.Push(output.BindingValue{DeviceID: "1", SensorID: "S1", Value: "1", Timestamp: time.Now()})
+	waitFor(t, func() bool {
+		v, ok := database.value("1.C1")
+		return ok && v == "2"
+	})
+
+	updated := computedSensor("C1", sensor.DataTypeInt, "${1.S2} + 1")
+	database.mu.Lock()
+	database.computed = []sensor.Sensor{updated}
+	database.mu.Unlock()
+	computed.SensorUpdated(&updated)
+
+	bindings.Push(output.BindingValue{DeviceID: "1", SensorID: "S2", Value: "10", Timestamp: time.Now()})
+	waitFor(t, func() bool {
+		v, ok := database.value("1.C1")
+		return ok && v == "11"
+	})
+}
@@ -0,0 +1,60 @@
+package background
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/soerenchrist/go_home/internal/sensor"
+	"github.com/soerenchrist/go_home/internal/value"
+)
+
+// ExecStrategy runs a local program and parses its standard output. Only
+// command lines contained in AllowedCommands are executed.
+type ExecStrategy struct {
+	AllowedCommands []string
+}
+
+func (strgy *ExecStrategy) PerformRequest(ctx context.Context, s *sensor.Sensor) (*value.SensorValue, error) {
+	options := s.PollingOptions
+	if !sensor.IsCommandAllowed(strgy.AllowedCommands, s.PollingEndpoint, options.Args) {
+		return nil, fmt.Errorf("command %s is not allowed", sensor.CommandLine(s.PollingEndpoint, options.Args))
+	}
+
+	timeout := pollingTimeout(s)
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, s.PollingEndpoint, options.Args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("command %s timed out after %s", s.PollingEndpoint, timeout)
+		}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return nil, fmt.Errorf("command %s exited with code %d: %s", s.PollingEndpoint, exitErr.ExitCode(), strings.TrimSpace(stderr.String()))
+		}
+		return nil, err
+	}
+
+	output := bytes.TrimSpace(stdout.Bytes())
+	raw, err := extractValue(output, options.Extractor, options.Expression)
+	if err != nil {
+		return nil, err
+	}
+
+	converted, err := convertValue(raw, s.DataType)
+	if err != nil {
+		return nil, err
+	}
+
+	return newSensorValue(s, converted), nil
+}
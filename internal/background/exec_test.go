@@ -0,0 +1,94 @@
+package background_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/soerenchrist/go_home/internal/background"
+	"github.com/soerenchrist/go_home/internal/sensor"
+)
+
+func newExecSensor(command string, dataType sensor.DataType, options sensor.PollingOptions) *sensor.Sensor {
+	return &sensor.Sensor{
+		ID:              "S1",
+		DeviceID:        "1",
+		DataType:        dataType,
+		Type:            sensor.SensorTypePolling,
+		PollingStrategy: sensor.PollingStrategyExec,
+		PollingEndpoint: command,
+		PollingOptions:  options,
+	}
+}
+
+func TestExecStrategy_ShouldParseStdout(t *testing.T) {
+	strategy := &background.ExecStrategy{AllowedCommands: []string{"echo 21.5", "echo 42", "echo hello world", `echo "temp=48.3'C"`}}
+
+	sensors := []*sensor.Sensor{
+		newExecSensor("echo", sensor.DataTypeFloat, sensor.PollingOptions{Args: []string{"21.5"}}),
+		newExecSensor("echo", sensor.DataTypeInt, sensor.PollingOptions{Args: []string{"42"}}),
+		newExecSensor("echo", sensor.DataTypeString, sensor.PollingOptions{Args: []string{"hello", "world"}}),
+		newExecSensor("echo", sensor.DataTypeFloat, sensor.PollingOptions{
+			Args:       []string{"temp=48.3'C"},
+			Extractor:  sensor.ExtractorRegex,
+			Expression: "temp=([0-9.]+)",
+		}),
+	}
+	expected := []string{"21.5", "42", "hello world", "48.3"}
+
+	for i, s := range sensors {
+		result, err := strategy.PerformRequest(context.Background(), s)
+		if err != nil {
+			t.Errorf("Unexpected error for sensor %d: %s", i, err)
+			continue
+		}
+
+		if result.Value != expected[i] {
+			t.Errorf("Expected %s, got %s", expected[i], result.Value)
+		}
+	}
+}
+
+func TestExecStrategy_ShouldReturnError_WhenCommandIsNotAllowed(t *testing.T) {
+	strategy := &background.ExecStrategy{AllowedCommands: []string{"echo"}}
+
+	sensors := []*sensor.Sensor{
+		newExecSensor("sh", sensor.DataTypeString, sensor.PollingOptions{Args: []string{"-c", "echo 1"}}),
+		newExecSensor("echo", sensor.DataTypeString, sensor.PollingOptions{Args: []string{"1"}}),
+	}
+	expected := []string{"command sh -c echo 1 is not allowed", "command echo 1 is not allowed"}
+
+	for i, s := range sensors {
+		_, err := strategy.PerformRequest(context.Background(), s)
+		if err == nil || err.Error() != expected[i] {
+			t.Errorf("Expected not allowed error, got %v", err)
+		}
+	}
+}
+
+func TestExecStrategy_ShouldReturnError_WhenExitCodeIsNotZero(t *testing.T) {
+	strategy := &background.ExecStrategy{AllowedCommands: []string{"sh -c 'echo broken >&2; exit 3'"}}
+
+	_, err := strategy.PerformRequest(context.Background(), newExecSensor("sh", sensor.DataTypeString, sensor.PollingOptions{Args: []string{"-c", "echo broken >&2; exit 3"}}))
+	if err == nil || err.Error() != "command sh exited with code 3: broken" {
+		t.Errorf("Expected exit code error, got %v", err)
+	}
+}
+
+func TestExecStrategy_ShouldReturnError_WhenCommandTimesOut(t *testing.T) {
+	strategy := &background.ExecStrategy{AllowedCommands: []string{"sleep 5"}}
+
+	_, err := strategy.PerformRequest(context.Background(), newExecSensor("sleep", sensor.DataTypeString, sensor.PollingOptions{Args: []string{"5"}, TimeoutSeconds: 1}))
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("Expected timeout error, got %v", err)
+	}
+}
+
+func TestExecStrategy_ShouldReturnError_WhenOutputCannotBeConverted(t *testing.T) {
+	strategy := &background.ExecStrategy{AllowedCommands: []string{"echo n/a"}}
+
+	_, err := strategy.PerformRequest(context.Background(), newExecSensor("echo", sensor.DataTypeInt, sensor.PollingOptions{Args: []string{"n/a"}}))
+	if err == nil || err.Error() != `cannot convert "n/a" to int` {
+		t.Errorf("Expected conversion error, got %v", err)
+	}
+}
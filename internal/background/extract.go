@@ -0,0 +1,118 @@
+package background
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/soerenchrist/go_home/internal/sensor"
+	"github.com/soerenchrist/go_home/pkg/jsonpath"
+)
+
+func extractValue(content []byte, extractor sensor.ExtractorType, expression string) (string, error) {
+	switch extractor {
+	case "", sensor.ExtractorBody:
+		return string(content), nil
+	case sensor.ExtractorJson:
+		return extractJson(content, expression)
+	case sensor.ExtractorRegex:
+		return extractRegex(content, expression)
+	}
+
+	return "", fmt.Errorf("unknown extractor %s", extractor)
+}
+
+func extractJson(content []byte, expression string) (string, error) {
+	path, err := jsonpath.Parse(expression)
+	if err != nil {
+		return "", err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+
+	var data any
+	if err := decoder.Decode(&data); err != nil {
+		return "", fmt.Errorf("response is not valid JSON: %v", err)
+	}
+
+	result, err := path.Lookup(data)
+	if err != nil {
+		return "", fmt.Errorf("failed to extract %s: %v", expression, err)
+	}
+
+	switch v := result.(type) {
+	case nil:
+		return "", fmt.Errorf("value at %s is null", expression)
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	}
+
+	encoded, err := json.Marshal(result)
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
+}
+
+func extractRegex(content []byte, expression string) (string, error) {
+	re, err := regexp.Compile(expression)
+	if err != nil {
+		return "", err
+	}
+
+	match := re.FindSubmatch(content)
+	if match == nil {
+		return "", fmt.Errorf("regular expression %s did not match", expression)
+	}
+
+	if len(match) > 1 {
+		return string(match[1]), nil
+	}
+	return string(match[0]), nil
+}
+
+// convertValue normalizes a raw polling result to the representation that is
+// stored for the data type of the sensor.
+func convertValue(raw string, dataType sensor.DataType) (string, error) {
+	trimmed := strings.TrimSpace(raw)
+
+	switch dataType {
+	case sensor.DataTypeInt:
+		if i, err := strconv.Atoi(trimmed); err == nil {
+			return strconv.Itoa(i), nil
+		}
+		f, err := strconv.ParseFloat(trimmed, 64)
+		if err != nil || f != float64(int64(f)) {
+			return "", fmt.Errorf("cannot convert %q to int", raw)
+		}
+		return strconv.FormatInt(int64(f), 10), nil
+	case sensor.DataTypeFloat:
+		f, err := strconv.ParseFloat(trimmed, 64)
+		if err != nil {
+			return "", fmt.Errorf("cannot convert %q to float", raw)
+		}
+		return strconv.FormatFloat(f, 'f', -1, 64), nil
+	case sensor.DataTypeBool:
+		switch strings.ToLower(trimmed) {
+		case "on", "yes":
+			return "true", nil
+		case "off", "no":
+			return "false", nil
+		}
+		b, err := strconv.ParseBool(trimmed)
+		if err != nil {
+			return "", fmt.Errorf("cannot convert %q to bool", raw)
+		}
+		return strconv.FormatBool(b), nil
+	}
+
+	return raw, nil
+}
@@ -0,0 +1,83 @@
+package background
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/soerenchrist/go_home/internal/sensor"
+	"github.com/soerenchrist/go_home/internal/value"
+)
+
+// FileStrategy reads the value from a local file, e.g. 1-Wire sensors or GPIO
+// pins exposed under /sys. Numeric values can be multiplied by a scale factor,
+// so that a DS18B20 reading of t=21375 with scale 0.001 yields 21.375.
+// Only files below AllowedPaths are read, also after resolving symlinks.
+type FileStrategy struct {
+	AllowedPaths []string
+}
+
+func (strgy *FileStrategy) PerformRequest(ctx context.Context, s *sensor.Sensor) (*value.SensorValue, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if !sensor.IsPathAllowed(strgy.AllowedPaths, s.PollingEndpoint) {
+		return nil, fmt.Errorf("path %s is not allowed", s.PollingEndpoint)
+	}
+
+	path, err := filepath.EvalSymlinks(s.PollingEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	if !sensor.IsPathAllowed(strgy.AllowedPaths, path) {
+		return nil, fmt.Errorf("path %s is not allowed", path)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	content, err := io.ReadAll(io.LimitReader(file, maxResponseSize))
+	if err != nil {
+		return nil, err
+	}
+
+	options := s.PollingOptions
+	raw, err := extractValue(content, options.Extractor, options.Expression)
+	if err != nil {
+		return nil, err
+	}
+
+	if options.Scale != 0 {
+		raw, err = scaleValue(raw, options.Scale)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	converted, err := convertValue(raw, s.DataType)
+	if err != nil {
+		return nil, err
+	}
+
+	return newSensorValue(s, converted), nil
+}
+
+func scaleValue(raw string, scale float64) (string, error) {
+	f, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+	if err != nil {
+		return "", fmt.Errorf("cannot scale %q: not a number", raw)
+	}
+
+	// Round away floating point noise like 0.30000000000000004.
+	scaled := math.Round(f*scale*1e9) / 1e9
+	return formatFloat(scaled), nil
+}
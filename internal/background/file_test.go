@@ -0,0 +1,119 @@
+package background_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/soerenchrist/go_home/internal/background"
+	"github.com/soerenchrist/go_home/internal/sensor"
+)
+
+const ds18b20Reading = `72 01 4b 46 7f ff 0e 10 57 : crc=57 YES
+72 01 4b 46 7f ff 0e 10 57 t=21375
+`
+
+func writeTempFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "w1_slave")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func newFileSensor(path string, dataType sensor.DataType, options sensor.PollingOptions) *sensor.Sensor {
+	return &sensor.Sensor{
+		ID:              "S1",
+		DeviceID:        "1",
+		DataType:        dataType,
+		Type:            sensor.SensorTypePolling,
+		PollingStrategy: sensor.PollingStrategyFile,
+		PollingEndpoint: path,
+		PollingOptions:  options,
+	}
+}
+
+func TestFileStrategy_ShouldReadValues(t *testing.T) {
+	sensors := []*sensor.Sensor{
+		newFileSensor(writeTempFile(t, ds18b20Reading), sensor.DataTypeFloat, sensor.PollingOptions{
+			Extractor:  sensor.ExtractorRegex,
+			Expression: "t=(-?\\d+)",
+			Scale:      0.001,
+		}),
+		newFileSensor(writeTempFile(t, "1\n"), sensor.DataTypeBool, sensor.PollingOptions{}),
+		newFileSensor(writeTempFile(t, "48312\n"), sensor.DataTypeInt, sensor.PollingOptions{}),
+		newFileSensor(writeTempFile(t, "3\n"), sensor.DataTypeFloat, sensor.PollingOptions{Scale: 0.1}),
+		newFileSensor(writeTempFile(t, "t=-1200"), sensor.DataTypeInt, sensor.PollingOptions{
+			Extractor:  sensor.ExtractorRegex,
+			Expression: "t=(-?\\d+)",
+			Scale:      0.01,
+		}),
+	}
+	expected := []string{"21.375", "true", "48312", "0.3", "-12"}
+
+	strategy := &background.FileStrategy{AllowedPaths: []string{os.TempDir()}}
+	for i, s := range sensors {
+		result, err := strategy.PerformRequest(context.Background(), s)
+		if err != nil {
+			t.Errorf("Unexpected error for sensor %d: %s", i, err)
+			continue
+		}
+
+		if result.Value != expected[i] {
+			t.Errorf("Expected %s, got %s", expected[i], result.Value)
+		}
+	}
+}
+
+func TestFileStrategy_ShouldReturnError_WhenValueCannotBeRead(t *testing.T) {
+	sensors := []*sensor.Sensor{
+		newFileSensor(filepath.Join(t.TempDir(), "missing"), sensor.DataTypeFloat, sensor.PollingOptions{}),
+		newFileSensor(writeTempFile(t, "crc=00 NO"), sensor.DataTypeFloat, sensor.PollingOptions{Scale: 0.001}),
+		newFileSensor(writeTempFile(t, "t=21375"), sensor.DataTypeInt, sensor.PollingOptions{
+			Extractor:  sensor.ExtractorRegex,
+			Expression: "t=(\\d+)",
+			Scale:      0.001,
+		}),
+	}
+	expected := []string{
+		"no such file or directory",
+		`cannot scale "crc=00 NO": not a number`,
+		`cannot convert "21.375" to int`,
+	}
+
+	strategy := &background.FileStrategy{AllowedPaths: []string{os.TempDir()}}
+	for i, s := range sensors {
+		_, err := strategy.PerformRequest(context.Background(), s)
+		if err == nil || !strings.Contains(err.Error(), expected[i]) {
+			t.Errorf("Expected error containing '%s', got %v", expected[i], err)
+		}
+	}
+}
+
+func TestFileStrategy_ShouldReturnError_WhenPathIsNotAllowed(t *testing.T) {
+	dir := t.TempDir()
+	allowed := filepath.Join(dir, "allowed")
+	if err := os.Mkdir(allowed, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	secret := filepath.Join(dir, "secret")
+	if err := os.WriteFile(secret, []byte("42"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(allowed, "link")
+	if err := os.Symlink(secret, link); err != nil {
+		t.Fatal(err)
+	}
+
+	strategy := &background.FileStrategy{AllowedPaths: []string{allowed}}
+	paths := []string{secret, allowed + "/../secret", link}
+
+	for _, path := range paths {
+		_, err := strategy.PerformRequest(context.Background(), newFileSensor(path, sensor.DataTypeInt, sensor.PollingOptions{}))
+		if err == nil || !strings.Contains(err.Error(), "is not allowed") {
+			t.Errorf("Expected %s to be not allowed, got %v", path, err)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package background
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/soerenchrist/go_home/internal/sensor"
+	"github.com/soerenchrist/go_home/pkg/output"
+)
+
+type HealthDatabase interface {
+	GetSensorHealth(deviceId, sensorId string) (*sensor.SensorHealth, error)
+	SaveSensorHealth(health *sensor.SensorHealth) error
+}
+
+// HealthTracker records polling results and received values per sensor. A
+// sensor is marked unavailable after threshold consecutive polling failures
+// and available again after the next success; both transitions are pushed to
+// the output bindings as availability events.
+//
+// HealthTracker is an output binding itself, so that values of external
+// sensors update the time of the last value as well. These times are saved
+// by Run, so that pushing values does not wait for the database.
+type HealthTracker struct {
+	database  HealthDatabase
+	bindings  *output.OutputBindingsManager
+	threshold int
+
+	mu sync.Mutex
+
+	valuesMu   sync.Mutex
+	lastValues map[sensorKey]time.Time
+	notify     chan struct{}
+}
+
+type sensorKey struct {
+	deviceId string
+	sensorId string
+}
+
+func NewHealthTracker(database HealthDatabase, bindings *output.OutputBindingsManager, threshold int) *HealthTracker {
+	if threshold < 1 {
+		threshold = 1
+	}
+	return &HealthTracker{
+		database:   database,
+		bindings:   bindings,
+		threshold:  threshold,
+		lastValues: make(map[sensorKey]time.Time),
+		notify:     make(chan struct{}, 1),
+	}
+}
+
+// Run saves the times of received values until ctx is cancelled.
+func (t *HealthTracker) Run(ctx context.Context) error {
+	for {
+		select {
+		case <-t.notify:
+			t.saveLastValues()
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (t *HealthTracker) RecordSuccess(s *sensor.Sensor, at time.Time) {
+	t.update(s.DeviceID, s.ID, func(health *sensor.SensorHealth) {
+		health.LastSuccess = &at
+		health.ConsecutiveFailures = 0
+		health.Status = sensor.HealthStatusOk
+	}, at)
+}
+
+func (t *HealthTracker) RecordFailure(s *sensor.Sensor, err error, at time.Time) {
+	t.update(s.DeviceID, s.ID, func(health *sensor.SensorHealth) {
+		health.LastError = err.Error()
+		health.LastErrorAt = &at
+		health.ConsecutiveFailures++
+		if health.ConsecutiveFailures >= t.threshold {
+			health.Status = sensor.HealthStatusUnavailable
+		}
+	}, at)
+}
+
+// Handle remembers the time of the latest value per sensor until Run saves it.
+func (t *HealthTracker) Handle(value output.BindingValue) {
+	if value.Event != "" {
+		return
+	}
+
+	key := sensorKey{deviceId: value.DeviceID, sensorId: value.SensorID}
+	t.valuesMu.Lock()
+	if last, ok := t.lastValues[key]; !ok || value.Timestamp.After(last) {
+		t.lastValues[key] = value.Timestamp
+	}
+	t.valuesMu.Unlock()
+
+	select {
+	case t.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (t *HealthTracker) saveLastValues() {
+	t.valuesMu.Lock()
+	lastValues := t.lastValues
+	t.lastValues = make(map[sensorKey]time.Time)
+	t.valuesMu.Unlock()
+
+	for key, timestamp := range lastValues {
+		timestamp := timestamp
+		t.update(key.deviceId, key.sensorId, func(health *sensor.SensorHealth) {
+			health.LastValueAt = &timestamp
+		}, timestamp)
+	}
+}
+
+func (t *HealthTracker) update(deviceId, sensorId string, change func(health *sensor.SensorHealth), at time.Time) {
+	t.mu.Lock()
+
+	health, err := t.database.GetSensorHealth(deviceId, sensorId)
+	if err != nil {
+		health = &sensor.SensorHealth{DeviceID: deviceId, SensorID: sensorId, Status: sensor.HealthStatusOk}
+	}
+	wasAvailable := health.Available()
+
+	change(health)
+
+	err = t.database.SaveSensorHealth(health)
+	t.mu.Unlock()
+
+	if err != nil {
+		log.Error().Err(err).Str("sensor_id", sensorId).Str("device_id", deviceId).Msg("Failed to save sensor health")
+		return
+	}
+
+	if available := health.Available(); available != wasAvailable {
+		log.Info().Str("sensor_id", sensorId).Str("device_id", deviceId).Bool("available", available).Msg("Sensor availability changed")
+		t.bindings.Push(output.BindingValue{
+			Timestamp: at,
+			Value:     strconv.FormatBool(available),
+			SensorID:  sensorId,
+			DeviceID:  deviceId,
+			Event:     output.EventAvailability,
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package background_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/soerenchrist/go_home/internal/background"
+	"github.com/soerenchrist/go_home/internal/sensor"
+	"github.com/soerenchrist/go_home/pkg/output"
+)
+
+type fakeHealthDatabase struct {
+	mu      sync.Mutex
+	healths map[string]sensor.SensorHealth
+}
+
+func (db *fakeHealthDatabase) GetSensorHealth(deviceId, sensorId string) (*sensor.SensorHealth, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	health, ok := db.healths[deviceId+"."+sensorId]
+	if !ok {
+		return nil, fmt.Errorf("not found")
+	}
+	return &health, nil
+}
+
+func (db *fakeHealthDatabase) SaveSensorHealth(health *sensor.SensorHealth) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.healths[health.DeviceID+"."+health.SensorID] = *health
+	return nil
+}
+
+type recordingBinding struct {
+	values []output.BindingValue
+}
+
+func (b *recordingBinding) Handle(value output.BindingValue) {
+	b.values = append(b.values, value)
+}
+
+func TestHealthTracker_ShouldMarkSensorUnavailable_AfterConsecutiveFailures(t *testing.T) {
+	database := &fakeHealthDatabase{healths: make(map[string]sensor.SensorHealth)}
+	bindings := output.NewManager()
+	events := &recordingBinding{}
+	bindings.Register(events)
+
+	tracker := background.NewHealthTracker(database, bindings, 3)
+	s := &sensor.Sensor{ID: "S2", DeviceID: "1"}
+	now := time.Now()
+
+	tracker.RecordSuccess(s, now)
+	for i := 0; i < 2; i++ {
+		tracker.RecordFailure(s, fmt.Errorf("timeout"), now)
+	}
+
+	health, _ := database.GetSensorHealth("1", "S2")
+	if health.Status != sensor.HealthStatusOk || health.ConsecutiveFailures != 2 || health.LastError != "timeout" {
+		t.Errorf("Expected sensor to be ok with 2 failures, got %+v", health)
+	}
+	if len(events.values) != 0 {
+		t.Errorf("Expected no availability event yet, got %v", events.values)
+	}
+
+	tracker.RecordFailure(s, fmt.Errorf("timeout"), now)
+	tracker.RecordFailure(s, fmt.Errorf("timeout"), now)
+
+	health, _ = database.GetSensorHealth("1", "S2")
+	if health.Status != sensor.HealthStatusUnavailable || health.ConsecutiveFailures != 4 {
+		t.Errorf("Expected sensor to be unavailable, got %+v", health)
+	}
+
+	tracker.RecordSuccess(s, now)
+
+	health, _ = database.GetSensorHealth("1", "S2")
+	if health.Status != sensor.HealthStatusOk || health.ConsecutiveFailures != 0 || !health.LastSuccess.Equal(now) {
+		t.Errorf("Expected sensor to be available again, got %+v", health)
+	}
+
+	if len(events.values) != 2 {
+		t.Fatalf("Expected 2 availability events, got %v", events.values)
+	}
+	for i, expected := range []string{"false", "true"} {
+		event := events.values[i]
+		if event.Event != output.EventAvailability || event.Value != expected || event.DeviceID != "1" || event.SensorID != "S2" {
+			t.Errorf("Expected availability event with value %s, got %+v", expected, event)
+		}
+	}
+}
+
+func TestHealthTracker_ShouldRecordLastValueTime(t *testing.T) {
+	database := &fakeHealthDatabase{healths: make(map[string]sensor.SensorHealth)}
+	tracker := background.NewHealthTracker(database, output.NewManager(), 3)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go tracker.Run(ctx)
+
+	timestamp := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+	tracker.Handle(output.BindingValue{DeviceID: "1", SensorID: "S1", Value: "21.5", Timestamp: timestamp.Add(-time.Minute)})
+	tracker.Handle(output.BindingValue{DeviceID: "1", SensorID: "S1", Value: "21.6", Timestamp: timestamp})
+	tracker.Handle(output.BindingValue{DeviceID: "1", SensorID: "S1", Value: "false", Timestamp: time.Now(), Event: output.EventAvailability})
+
+	waitFor(t, func() bool {
+		health, err := database.GetSensorHealth("1", "S1")
+		return err == nil && health.LastValueAt != nil && health.LastValueAt.Equal(timestamp)
+	})
+
+	health, _ := database.GetSensorHealth("1", "S1")
+	if health.Status != sensor.HealthStatusOk {
+		t.Errorf("Expected sensor to be ok, got %+v", health)
+	}
+}
@@ -0,0 +1,66 @@
+package background
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/soerenchrist/go_home/internal/sensor"
+	"github.com/soerenchrist/go_home/internal/value"
+)
+
+const maxResponseSize = 1 << 20
+
+type HttpStrategy struct{}
+
+func (strgy *HttpStrategy) PerformRequest(ctx context.Context, s *sensor.Sensor) (*value.SensorValue, error) {
+	options := s.PollingOptions
+
+	method := options.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	var body io.Reader
+	if options.Body != "" {
+		body = strings.NewReader(options.Body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, s.PollingEndpoint, body)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range options.Headers {
+		req.Header.Set(key, value)
+	}
+
+	client := &http.Client{Timeout: pollingTimeout(s)}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return nil, fmt.Errorf("unexpected status code %d from %s", res.StatusCode, s.PollingEndpoint)
+	}
+
+	content, err := io.ReadAll(io.LimitReader(res.Body, maxResponseSize))
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := extractValue(content, options.Extractor, options.Expression)
+	if err != nil {
+		return nil, err
+	}
+
+	converted, err := convertValue(raw, s.DataType)
+	if err != nil {
+		return nil, err
+	}
+
+	return newSensorValue(s, converted), nil
+}
@@ -0,0 +1,121 @@
+package background_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/soerenchrist/go_home/internal/background"
+	"github.com/soerenchrist/go_home/internal/sensor"
+)
+
+func newStatusServer(t *testing.T, status int, body string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(401)
+			return
+		}
+		w.WriteHeader(status)
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newHttpSensor(endpoint string, dataType sensor.DataType, extractor sensor.ExtractorType, expression string) *sensor.Sensor {
+	return &sensor.Sensor{
+		ID:              "S1",
+		DeviceID:        "1",
+		DataType:        dataType,
+		Type:            sensor.SensorTypePolling,
+		PollingStrategy: sensor.PollingStrategyHttp,
+		PollingEndpoint: endpoint,
+		PollingOptions: sensor.PollingOptions{
+			Headers:    map[string]string{"Authorization": "Bearer token"},
+			Extractor:  extractor,
+			Expression: expression,
+		},
+	}
+}
+
+func TestHttpStrategy_ShouldExtractValues(t *testing.T) {
+	server := newStatusServer(t, 200, `{"status": {"temp": 21.50, "online": true, "mode": "heating", "count": 3}}`)
+
+	sensors := []*sensor.Sensor{
+		newHttpSensor(server.URL, sensor.DataTypeFloat, sensor.ExtractorJson, "$.status.temp"),
+		newHttpSensor(server.URL, sensor.DataTypeBool, sensor.ExtractorJson, "$.status.online"),
+		newHttpSensor(server.URL, sensor.DataTypeString, sensor.ExtractorJson, "$.status.mode"),
+		newHttpSensor(server.URL, sensor.DataTypeInt, sensor.ExtractorJson, "$.status.count"),
+		newHttpSensor(server.URL, sensor.DataTypeFloat, sensor.ExtractorRegex, `"temp": ([0-9.]+)`),
+		newHttpSensor(server.URL, sensor.DataTypeString, sensor.ExtractorBody, ""),
+	}
+	expected := []string{
+		"21.5",
+		"true",
+		"heating",
+		"3",
+		"21.5",
+		`{"status": {"temp": 21.50, "online": true, "mode": "heating", "count": 3}}`,
+	}
+
+	strategy := &background.HttpStrategy{}
+	for i, s := range sensors {
+		result, err := strategy.PerformRequest(context.Background(), s)
+		if err != nil {
+			t.Errorf("Unexpected error for sensor %d: %s", i, err)
+			continue
+		}
+
+		if result.Value != expected[i] {
+			t.Errorf("Expected %s, got %s", expected[i], result.Value)
+		}
+		if result.SensorID != "S1" || result.DeviceID != "1" {
+			t.Errorf("Expected value to belong to 1.S1, got %s.%s", result.DeviceID, result.SensorID)
+		}
+	}
+}
+
+func TestHttpStrategy_ShouldReturnError_WhenConversionFails(t *testing.T) {
+	server := newStatusServer(t, 200, `{"temp": 21.5, "mode": "heating"}`)
+
+	sensors := []*sensor.Sensor{
+		newHttpSensor(server.URL, sensor.DataTypeInt, sensor.ExtractorJson, "$.temp"),
+		newHttpSensor(server.URL, sensor.DataTypeFloat, sensor.ExtractorJson, "$.mode"),
+		newHttpSensor(server.URL, sensor.DataTypeBool, sensor.ExtractorJson, "$.mode"),
+		newHttpSensor(server.URL, sensor.DataTypeFloat, sensor.ExtractorJson, "$.missing"),
+		newHttpSensor(server.URL, sensor.DataTypeFloat, sensor.ExtractorRegex, "humidity=(\\d+)"),
+	}
+	expected := []string{
+		`cannot convert "21.5" to int`,
+		`cannot convert "heating" to float`,
+		`cannot convert "heating" to bool`,
+		"key missing not found",
+		"did not match",
+	}
+
+	strategy := &background.HttpStrategy{}
+	for i, s := range sensors {
+		_, err := strategy.PerformRequest(context.Background(), s)
+		if err == nil {
+			t.Errorf("Expected error for sensor %d, got none", i)
+			continue
+		}
+
+		if !strings.Contains(err.Error(), expected[i]) {
+			t.Errorf("Expected error containing '%s', got '%s'", expected[i], err.Error())
+		}
+	}
+}
+
+func TestHttpStrategy_ShouldReturnError_WhenStatusCodeIsNotSuccessful(t *testing.T) {
+	server := newStatusServer(t, 503, "unavailable")
+
+	strategy := &background.HttpStrategy{}
+	_, err := strategy.PerformRequest(context.Background(), newHttpSensor(server.URL, sensor.DataTypeString, sensor.ExtractorBody, ""))
+	if err == nil || !strings.Contains(err.Error(), "unexpected status code 503") {
+		t.Errorf("Expected status code error, got %v", err)
+	}
+}
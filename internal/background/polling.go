@@ -1,84 +1,430 @@
 package background
 
 import (
+	"context"
 	"fmt"
+	"math/rand"
+	"sync"
 	"time"
 
 	"github.com/rs/zerolog/log"
-	"github.com/soerenchrist/go_home/internal/db"
+	"github.com/soerenchrist/go_home/internal/errors"
 	"github.com/soerenchrist/go_home/internal/sensor"
+	"github.com/soerenchrist/go_home/internal/value"
 	"github.com/soerenchrist/go_home/pkg/output"
 )
 
-func PollSensorValues(database db.Database, bindingManager *output.OutputBindingsManager) {
-	sensors, err := database.ListPollingSensors()
+// maxJitter bounds the random delay before the first poll of a sensor, which
+// spreads sensors with equal intervals instead of polling them all at once.
+const maxJitter = 10 * time.Second
+
+type PollingDatabase interface {
+	ListPollingSensors() ([]sensor.Sensor, error)
+	GetCurrentSensorValue(deviceId, sensorId string) (*value.SensorValue, error)
+	AddSensorValue(value *value.SensorValue) error
+	AddRejectedValue(deviceId, sensorId string) error
+	SetOutliers(deviceId, sensorId string, outliers int) error
+}
+
+type PollerStats struct {
+	Workers        int               `json:"workers"`
+	Scheduled      int               `json:"scheduled"`
+	Running        int               `json:"running"`
+	Completed      uint64            `json:"completed"`
+	Failed         uint64            `json:"failed"`
+	Missed         uint64            `json:"missed"`
+	MissedBySensor map[string]uint64 `json:"missed_by_sensor"`
+}
+
+// schedule polls a sensor until its ctx is cancelled. Queued and running polls
+// belong to the schedule, so they are discarded with it.
+type schedule struct {
+	sensor sensor.Sensor
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// Poller supervises one schedule per active polling sensor. Schedules are
+// added, replaced and cancelled when sensors are changed through the API.
+// Due sensors are polled by a bounded pool of workers; a sensor whose
+// previous poll is still running skips the interval, which is counted as missed.
+type Poller struct {
+	database       PollingDatabase
+	bindingManager *output.OutputBindingsManager
+	health         *HealthTracker
+	access         sensor.LocalAccess
+	workers        int
+	queue          chan *schedule
+
+	mu  sync.Mutex
+	ctx context.Context
+	// pending collects sensor changes until Run has started, nil marks a deleted sensor
+	pending   map[string]*sensor.Sensor
+	schedules map[string]*schedule
+	running   map[string]bool
+	missed    map[string]uint64
+	completed uint64
+	failed    uint64
+	wg        sync.WaitGroup
+}
+
+// NewPoller creates a poller. health may be nil, if polling results should not be tracked.
+func NewPoller(database PollingDatabase, bindingManager *output.OutputBindingsManager, health *HealthTracker, workers int, access sensor.LocalAccess) *Poller {
+	if workers < 1 {
+		workers = 1
+	}
+
+	return &Poller{
+		database:       database,
+		bindingManager: bindingManager,
+		health:         health,
+		access:         access,
+		workers:        workers,
+		queue:          make(chan *schedule, 100),
+		pending:        make(map[string]*sensor.Sensor),
+		schedules:      make(map[string]*schedule),
+		running:        make(map[string]bool),
+		missed:         make(map[string]uint64),
+	}
+}
+
+// Run starts polling all active polling sensors and blocks until ctx is
+// cancelled and all schedules and workers have stopped.
+func (p *Poller) Run(ctx context.Context) error {
+	// Sensors are listed while holding the lock, so that no change made through
+	// the API in the meantime is lost.
+	p.mu.Lock()
+	sensors, err := p.database.ListPollingSensors()
 	if err != nil {
-		panic(err)
+		p.mu.Unlock()
+		return err
 	}
 
-	lastPolls := createPollingMap(sensors)
-	channel := make(chan sensor.Sensor, 10)
+	p.ctx = ctx
+	pending := p.pending
+	p.pending = nil
+	for i := range sensors {
+		p.schedule(&sensors[i])
+	}
+	for key, s := range pending {
+		if s == nil {
+			p.unschedule(key)
+		} else {
+			p.schedule(s)
+		}
+	}
+	log.Debug().Int("count", len(p.schedules)).Msgf("Found %d sensors to poll", len(p.schedules))
+	p.mu.Unlock()
 
-	go readChannel(database, channel, bindingManager)
+	for i := 0; i < p.workers; i++ {
+		p.wg.Add(1)
+		go p.work(ctx)
+	}
 
-	log.Debug().Int("count", len(sensors)).Msgf("Found %d sensors to poll\n", len(sensors))
+	<-ctx.Done()
 
-	for {
-		currentTimestamp := time.Now().Unix()
-		for _, sensor := range sensors {
-			lastPoll := lastPolls[sensor.ID]
-			if currentTimestamp-lastPoll >= int64(sensor.PollingInterval) {
-				channel <- sensor
-				lastPolls[sensor.ID] = currentTimestamp
-			}
+	p.mu.Lock()
+	for key, schedule := range p.schedules {
+		schedule.cancel()
+		delete(p.schedules, key)
+	}
+	p.mu.Unlock()
+
+	p.wg.Wait()
+	log.Info().Msg("Stopped poller")
+	return nil
+}
+
+func (p *Poller) SensorCreated(s *sensor.Sensor) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.schedule(s)
+}
+
+// SensorUpdated replaces the schedule of a sensor, so that changes made
+// through the API take effect without restarting the poller.
+func (p *Poller) SensorUpdated(s *sensor.Sensor) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.schedule(s)
+	log.Debug().Str("sensor_id", s.ID).Str("device_id", s.DeviceID).Msg("Updated polling settings")
+}
+
+func (p *Poller) SensorDeleted(deviceId string, sensorId string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := deviceId + "." + sensorId
+	if p.pending != nil {
+		p.pending[key] = nil
+	}
+	p.unschedule(key)
+}
+
+// DeviceDeleted stops polling all sensors of the device.
+func (p *Poller) DeviceDeleted(deviceId string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for key, s := range p.pending {
+		if s != nil && s.DeviceID == deviceId {
+			p.pending[key] = nil
 		}
+	}
+	for key, schedule := range p.schedules {
+		if schedule.sensor.DeviceID == deviceId {
+			p.unschedule(key)
+		}
+	}
+}
+
+// Scheduled returns the keys (deviceId.sensorId) of all sensors that are currently polled.
+func (p *Poller) Scheduled() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	keys := make([]string, 0, len(p.schedules))
+	for key := range p.schedules {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (p *Poller) Stats() PollerStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var missed uint64
+	missedBySensor := make(map[string]uint64)
+	for key, count := range p.missed {
+		missed += count
+		missedBySensor[key] = count
+	}
+
+	return PollerStats{
+		Workers:        p.workers,
+		Scheduled:      len(p.schedules),
+		Running:        len(p.running),
+		Completed:      p.completed,
+		Failed:         p.failed,
+		Missed:         missed,
+		MissedBySensor: missedBySensor,
+	}
+}
 
-		time.Sleep(1 * time.Second)
+// schedule must be called with p.mu held.
+func (p *Poller) schedule(s *sensor.Sensor) {
+	key := pollingKey(s)
+	if p.pending != nil {
+		pending := *s
+		p.pending[key] = &pending
+		return
 	}
+
+	p.unschedule(key)
+	if p.ctx.Err() != nil {
+		return
+	}
+	if s.Type != sensor.SensorTypePolling || !s.IsActive || s.PollingInterval < 1 {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(p.ctx)
+	sched := &schedule{sensor: *s, ctx: ctx, cancel: cancel}
+	p.schedules[key] = sched
+
+	p.wg.Add(1)
+	go p.runSchedule(sched)
 }
 
-func readChannel(database db.Database, channel chan sensor.Sensor, outputBinding *output.OutputBindingsManager) {
+// unschedule must be called with p.mu held.
+func (p *Poller) unschedule(key string) {
+	if existing, ok := p.schedules[key]; ok {
+		existing.cancel()
+		delete(p.schedules, key)
+	}
+}
+
+func (p *Poller) runSchedule(sched *schedule) {
+	defer p.wg.Done()
+
+	interval := time.Duration(sched.sensor.PollingInterval) * time.Second
+
+	select {
+	case <-time.After(jitter(interval)):
+	case <-sched.ctx.Done():
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
 	for {
-		sensor := <-channel
+		p.enqueue(sched)
 
-		strategy, err := getStrategy(&sensor)
-		if err != nil {
-			log.Error().Err(err).Str("sensor_id", sensor.ID).Msg("Error polling sensor")
-			continue
+		select {
+		case <-ticker.C:
+		case <-sched.ctx.Done():
+			return
 		}
-		result, err := strategy.PerformRequest(&sensor)
-		if err != nil {
-			log.Error().Err(err).Str("sensor_id", sensor.ID).Msg("Error polling sensor")
-			continue
+	}
+}
+
+func jitter(interval time.Duration) time.Duration {
+	bound := interval
+	if bound > maxJitter {
+		bound = maxJitter
+	}
+	return time.Duration(rand.Int63n(int64(bound)))
+}
+
+// enqueue hands a due sensor to the workers without blocking the schedule.
+// The interval is missed if the previous poll of the sensor has not finished
+// yet or all workers are busy and the queue is full.
+func (p *Poller) enqueue(sched *schedule) {
+	s := &sched.sensor
+	key := pollingKey(s)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.running[key] {
+		p.missed[key]++
+		log.Warn().Str("sensor_id", s.ID).Str("device_id", s.DeviceID).Msg("Skipped polling interval, previous poll is still running")
+		return
+	}
+
+	select {
+	case p.queue <- sched:
+		p.running[key] = true
+	default:
+		p.missed[key]++
+		log.Warn().Str("sensor_id", s.ID).Str("device_id", s.DeviceID).Msg("Skipped polling interval, polling queue is full")
+	}
+}
+
+func (p *Poller) work(ctx context.Context) {
+	defer p.wg.Done()
+
+	for {
+		select {
+		case sched := <-p.queue:
+			s := &sched.sensor
+			err := p.poll(sched.ctx, s)
+			if sched.ctx.Err() != nil {
+				// The sensor was deleted or updated in the meantime
+				p.release(s)
+				continue
+			}
+			p.finish(s, err)
+			p.recordHealth(s, err)
+		case <-ctx.Done():
+			return
 		}
-		err = database.AddSensorValue(result)
-		if err != nil {
-			log.Error().Err(err).Str("sensor_id", sensor.ID).Msg("Failed to save polling result")
-			continue
+	}
+}
+
+// release allows the sensor to be polled again, without counting the poll.
+func (p *Poller) release(s *sensor.Sensor) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.running, pollingKey(s))
+}
+
+func (p *Poller) finish(s *sensor.Sensor, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.running, pollingKey(s))
+	if err != nil {
+		p.failed++
+	} else {
+		p.completed++
+	}
+}
+
+func (p *Poller) recordHealth(s *sensor.Sensor, err error) {
+	if p.health == nil {
+		return
+	}
+
+	if err != nil {
+		p.health.RecordFailure(s, err, time.Now())
+	} else {
+		p.health.RecordSuccess(s, time.Now())
+	}
+}
+
+// poll requests a value of the sensor and stores it, unless ctx, the context of
+// the schedule, is cancelled before.
+func (p *Poller) poll(ctx context.Context, s *sensor.Sensor) error {
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	strategy, err := getStrategy(s, p.access)
+	if err != nil {
+		log.Error().Err(err).Str("sensor_id", s.ID).Msg("Error polling sensor")
+		return err
+	}
+
+	requestCtx, cancel := context.WithTimeout(ctx, pollingTimeout(s))
+	defer cancel()
+
+	result, err := strategy.PerformRequest(requestCtx, s)
+	if ctx.Err() != nil {
+		return nil
+	}
+	if err != nil {
+		log.Error().Err(err).Str("sensor_id", s.ID).Msg("Error polling sensor")
+		return err
+	}
+
+	result.Value, err = value.Ingest(p.database, s, result.Value)
+	if err != nil {
+		if _, isRejected := err.(*errors.RejectedError); isRejected {
+			log.Debug().Err(err).Str("sensor_id", s.ID).Msg("Rejected polling result")
+			return nil
 		}
-		outputBinding.Push(result.ToBindingValue())
-		log.Debug().
-			Str("sensor_id", sensor.ID).
-			Str("polling_result", result.Value).
-			Msg("Polled sensor successfully")
+		log.Error().Err(err).Str("sensor_id", s.ID).Msg("Failed to process polling result")
+		return err
+	}
+
+	err = p.database.AddSensorValue(result)
+	if err != nil {
+		log.Error().Err(err).Str("sensor_id", s.ID).Msg("Failed to save polling result")
+		return err
 	}
+	p.bindingManager.Push(result.ToBindingValue())
+	log.Debug().
+		Str("sensor_id", s.ID).
+		Str("polling_result", result.Value).
+		Msg("Polled sensor successfully")
+	return nil
 }
 
-func getStrategy(s *sensor.Sensor) (RequestStrategy, error) {
+func getStrategy(s *sensor.Sensor, access sensor.LocalAccess) (RequestStrategy, error) {
 	switch s.PollingStrategy {
 	case sensor.PollingStrategyPing:
 		return &PingStrategy{}, nil
+	case sensor.PollingStrategyHttp:
+		return &HttpStrategy{}, nil
+	case sensor.PollingStrategyTcp:
+		return &TcpStrategy{}, nil
+	case sensor.PollingStrategyTls:
+		return &TlsCertStrategy{}, nil
+	case sensor.PollingStrategyExec:
+		return &ExecStrategy{AllowedCommands: access.Commands}, nil
+	case sensor.PollingStrategyFile:
+		return &FileStrategy{AllowedPaths: access.Paths}, nil
 	}
 
 	return nil, fmt.Errorf("unknown polling strategy %s", s.PollingStrategy)
 }
 
-func createPollingMap(sensors []sensor.Sensor) map[string]int64 {
-	pollingMap := make(map[string]int64)
-
-	for _, sensor := range sensors {
-		pollingMap[sensor.ID] = 0
-	}
-
-	return pollingMap
+func pollingKey(s *sensor.Sensor) string {
+	return s.DeviceID + "." + s.ID
 }
@@ -0,0 +1,330 @@
+package background_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/soerenchrist/go_home/internal/background"
+	"github.com/soerenchrist/go_home/internal/sensor"
+	"github.com/soerenchrist/go_home/internal/value"
+	"github.com/soerenchrist/go_home/pkg/output"
+)
+
+type fakePollingDatabase struct {
+	mu       sync.Mutex
+	sensors  []sensor.Sensor
+	values   []*value.SensorValue
+	rejected int
+}
+
+func (db *fakePollingDatabase) ListPollingSensors() ([]sensor.Sensor, error) {
+	return db.sensors, nil
+}
+
+func (db *fakePollingDatabase) GetCurrentSensorValue(deviceId, sensorId string) (*value.SensorValue, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for i := len(db.values) - 1; i >= 0; i-- {
+		if db.values[i].DeviceID == deviceId && db.values[i].SensorID == sensorId {
+			return db.values[i], nil
+		}
+	}
+	return nil, fmt.Errorf("not found")
+}
+
+func (db *fakePollingDatabase) AddRejectedValue(deviceId, sensorId string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.rejected++
+	return nil
+}
+
+func (db *fakePollingDatabase) SetOutliers(deviceId, sensorId string, outliers int) error {
+	return nil
+}
+
+func (db *fakePollingDatabase) AddSensorValue(v *value.SensorValue) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.values = append(db.values, v)
+	return nil
+}
+
+func (db *fakePollingDatabase) count(sensorId string) int {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	count := 0
+	for _, v := range db.values {
+		if v.SensorID == sensorId {
+			count++
+		}
+	}
+	return count
+}
+
+func newPolledFileSensor(t *testing.T, id string, active bool) sensor.Sensor {
+	return sensor.Sensor{
+		ID:              id,
+		DeviceID:        "1",
+		DataType:        sensor.DataTypeInt,
+		Type:            sensor.SensorTypePolling,
+		IsActive:        active,
+		PollingInterval: 1,
+		PollingStrategy: sensor.PollingStrategyFile,
+		PollingEndpoint: writeTempFile(t, "42"),
+	}
+}
+
+func startPoller(t *testing.T, database *fakePollingDatabase) (*background.Poller, context.CancelFunc, chan error) {
+	poller := background.NewPoller(database, output.NewManager(), nil, 2, sensor.LocalAccess{Commands: []string{"sleep 10"}, Paths: []string{os.TempDir()}})
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan error, 1)
+	go func() {
+		done <- poller.Run(ctx)
+	}()
+
+	waitFor(t, func() bool { return len(poller.Scheduled()) > 0 })
+	return poller, cancel, done
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("Condition was not met in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func scheduled(poller *background.Poller) []string {
+	keys := poller.Scheduled()
+	sort.Strings(keys)
+	return keys
+}
+
+func TestPoller_ShouldOnlyScheduleActivePollingSensors(t *testing.T) {
+	database := &fakePollingDatabase{sensors: []sensor.Sensor{
+		newPolledFileSensor(t, "S1", true),
+		newPolledFileSensor(t, "S2", false),
+	}}
+
+	poller, cancel, _ := startPoller(t, database)
+	defer cancel()
+
+	keys := scheduled(poller)
+	if len(keys) != 1 || keys[0] != "1.S1" {
+		t.Errorf("Expected only 1.S1 to be scheduled, got %v", keys)
+	}
+
+	waitFor(t, func() bool { return database.count("S1") > 0 })
+	if database.count("S2") != 0 {
+		t.Errorf("Expected inactive sensor not to be polled")
+	}
+}
+
+func TestPoller_ShouldReactToSensorChanges(t *testing.T) {
+	database := &fakePollingDatabase{sensors: []sensor.Sensor{
+		newPolledFileSensor(t, "S1", true),
+	}}
+
+	poller, cancel, _ := startPoller(t, database)
+	defer cancel()
+
+	created := newPolledFileSensor(t, "S3", true)
+	poller.SensorCreated(&created)
+	waitFor(t, func() bool { return database.count("S3") > 0 })
+
+	created.IsActive = false
+	poller.SensorUpdated(&created)
+	if keys := scheduled(poller); len(keys) != 1 || keys[0] != "1.S1" {
+		t.Errorf("Expected deactivated sensor to be unscheduled, got %v", keys)
+	}
+
+	created.IsActive = true
+	poller.SensorUpdated(&created)
+	if keys := scheduled(poller); len(keys) != 2 {
+		t.Errorf("Expected reactivated sensor to be scheduled, got %v", keys)
+	}
+
+	poller.SensorDeleted("1", "S1")
+	poller.SensorDeleted("1", "S3")
+	if keys := scheduled(poller); len(keys) != 0 {
+		t.Errorf("Expected no sensors to be scheduled, got %v", keys)
+	}
+
+	polled := database.count("S1")
+	time.Sleep(1500 * time.Millisecond)
+	if database.count("S1") != polled {
+		t.Errorf("Expected deleted sensor not to be polled anymore")
+	}
+}
+
+func TestPoller_ShouldApplyChanges_WhenMadeBeforeRun(t *testing.T) {
+	database := &fakePollingDatabase{sensors: []sensor.Sensor{
+		newPolledFileSensor(t, "S1", true),
+		newPolledFileSensor(t, "S2", true),
+	}}
+	poller := background.NewPoller(database, output.NewManager(), nil, 2, sensor.LocalAccess{Paths: []string{os.TempDir()}})
+
+	created := newPolledFileSensor(t, "S3", true)
+	poller.SensorCreated(&created)
+	poller.SensorDeleted("1", "S1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go poller.Run(ctx)
+
+	waitFor(t, func() bool { return len(poller.Scheduled()) > 0 })
+	if keys := scheduled(poller); len(keys) != 2 || keys[0] != "1.S2" || keys[1] != "1.S3" {
+		t.Errorf("Expected 1.S2 and 1.S3 to be scheduled, got %v", keys)
+	}
+
+	poller.DeviceDeleted("1")
+	if keys := scheduled(poller); len(keys) != 0 {
+		t.Errorf("Expected no sensors to be scheduled after deleting the device, got %v", keys)
+	}
+}
+
+func TestPoller_ShouldStop_WhenContextIsCancelled(t *testing.T) {
+	database := &fakePollingDatabase{sensors: []sensor.Sensor{
+		newPolledFileSensor(t, "S1", true),
+	}}
+
+	poller, cancel, done := startPoller(t, database)
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected no error, got %s", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Poller did not stop")
+	}
+
+	created := newPolledFileSensor(t, "S3", true)
+	poller.SensorCreated(&created)
+	if keys := poller.Scheduled(); len(keys) != 0 {
+		t.Errorf("Expected no sensors to be scheduled after shutdown, got %v", keys)
+	}
+}
+
+func TestPoller_ShouldSkipIntervals_WhenPreviousPollIsStillRunning(t *testing.T) {
+	slow := sensor.Sensor{
+		ID:              "S1",
+		DeviceID:        "1",
+		DataType:        sensor.DataTypeString,
+		Type:            sensor.SensorTypePolling,
+		IsActive:        true,
+		PollingInterval: 1,
+		PollingStrategy: sensor.PollingStrategyExec,
+		PollingEndpoint: "sleep",
+		PollingOptions:  sensor.PollingOptions{Args: []string{"10"}, TimeoutSeconds: 3},
+	}
+	fast := newPolledFileSensor(t, "S2", true)
+	database := &fakePollingDatabase{sensors: []sensor.Sensor{slow, fast}}
+
+	poller, cancel, _ := startPoller(t, database)
+	defer cancel()
+
+	waitFor(t, func() bool { return poller.Stats().MissedBySensor["1.S1"] > 0 })
+	if database.count("S2") == 0 {
+		t.Errorf("Expected fast sensor to be polled while slow sensor is running")
+	}
+
+	waitFor(t, func() bool { return poller.Stats().Failed > 0 })
+	stats := poller.Stats()
+	if stats.MissedBySensor["1.S2"] != 0 {
+		t.Errorf("Expected fast sensor to miss no intervals, got %d", stats.MissedBySensor["1.S2"])
+	}
+	if stats.Workers != 2 || stats.Scheduled != 2 {
+		t.Errorf("Expected 2 workers and 2 scheduled sensors, got %+v", stats)
+	}
+}
+
+func TestPoller_ShouldApplyPipeline_BeforeSavingValue(t *testing.T) {
+	s := newPolledFileSensor(t, "S1", true)
+	s.DataType = sensor.DataTypeFloat
+	s.Unit = "Fahrenheit"
+	s.Pipeline = sensor.PipelineOptions{ConvertFrom: "Celsius", Scale: 0.5, Offset: -1}
+	database := &fakePollingDatabase{sensors: []sensor.Sensor{s}}
+
+	_, cancel, _ := startPoller(t, database)
+	defer cancel()
+
+	waitFor(t, func() bool { return database.count("S1") > 0 })
+	current, _ := database.GetCurrentSensorValue("1", "S1")
+	if current.Value != "52.8" {
+		t.Errorf("Expected transformed value 52.8, got %s", current.Value)
+	}
+}
+
+func TestPoller_ShouldDiscardRunningPoll_WhenSensorIsDeleted(t *testing.T) {
+	requested := make(chan struct{}, 1)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested <- struct{}{}
+		<-release
+		fmt.Fprint(w, "on")
+	}))
+	defer server.Close()
+	defer close(release)
+
+	database := &fakePollingDatabase{sensors: []sensor.Sensor{{
+		ID:              "S1",
+		DeviceID:        "1",
+		DataType:        sensor.DataTypeString,
+		Type:            sensor.SensorTypePolling,
+		IsActive:        true,
+		PollingInterval: 1,
+		PollingStrategy: sensor.PollingStrategyHttp,
+		PollingEndpoint: server.URL,
+	}}}
+
+	poller, cancel, _ := startPoller(t, database)
+	defer cancel()
+
+	<-requested
+	poller.SensorDeleted("1", "S1")
+	release <- struct{}{}
+
+	time.Sleep(200 * time.Millisecond)
+	if database.count("S1") != 0 {
+		t.Errorf("Expected value of deleted sensor not to be stored")
+	}
+	if stats := poller.Stats(); stats.Completed != 0 {
+		t.Errorf("Expected discarded poll not to be counted, got %+v", stats)
+	}
+}
+
+func TestPoller_ShouldAcceptStepChange_AfterConsecutiveOutliers(t *testing.T) {
+	s := newPolledFileSensor(t, "S1", true)
+	s.Pipeline = sensor.PipelineOptions{MaxChange: 5}
+	database := &fakePollingDatabase{
+		sensors: []sensor.Sensor{s},
+		values:  []*value.SensorValue{{DeviceID: "1", SensorID: "S1", Value: "0"}},
+	}
+
+	_, cancel, _ := startPoller(t, database)
+	defer cancel()
+
+	waitFor(t, func() bool { return database.count("S1") > 1 })
+	current, _ := database.GetCurrentSensorValue("1", "S1")
+	if current.Value != "42" {
+		t.Errorf("Expected step change to 42 to be accepted, got %s", current.Value)
+	}
+	if database.rejected != 3 {
+		t.Errorf("Expected 3 rejected values, got %d", database.rejected)
+	}
+}
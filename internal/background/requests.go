@@ -1,6 +1,7 @@
 package background
 
 import (
+	"context"
 	"fmt"
 	"strconv"
 	"time"
@@ -11,38 +12,159 @@ import (
 	"github.com/soerenchrist/go_home/internal/value"
 )
 
+const defaultPingCount = 3
+
+// pingHeadroom is left between the timeout of the pinger and the deadline of
+// the poll, so that unanswered requests are reported before the poll expires.
+const pingHeadroom = 500 * time.Millisecond
+
+// RequestStrategy polls the current value of a sensor. Implementations must
+// return once ctx is done.
 type RequestStrategy interface {
-	PerformRequest(sensor *sensor.Sensor) (*value.SensorValue, error)
+	PerformRequest(ctx context.Context, sensor *sensor.Sensor) (*value.SensorValue, error)
 }
 
-type PingStrategy struct{}
+// pollingTimeout returns the timeout configured for the sensor or the default
+// timeout of its polling strategy.
+func pollingTimeout(s *sensor.Sensor) time.Duration {
+	if s.PollingOptions.TimeoutSeconds > 0 {
+		return time.Duration(s.PollingOptions.TimeoutSeconds) * time.Second
+	}
 
-func (strgy *PingStrategy) PerformRequest(s *sensor.Sensor) (*value.SensorValue, error) {
-	if s.DataType != sensor.DataTypeBool {
-		return nil, fmt.Errorf("PingStrategy can only be used with boolean data types")
+	switch s.PollingStrategy {
+	case sensor.PollingStrategyHttp, sensor.PollingStrategyExec:
+		return 10 * time.Second
+	case sensor.PollingStrategyFile:
+		return 2 * time.Second
 	}
+	return 5 * time.Second
+}
+
+type PingOptions struct {
+	Count      int
+	Timeout    time.Duration
+	Privileged bool
+}
+
+// Pinger sends ICMP echo requests to an address and returns the collected statistics.
+type Pinger interface {
+	Ping(ctx context.Context, address string, options PingOptions) (*ping.Statistics, error)
+}
 
-	pinger, err := ping.NewPinger(s.PollingEndpoint)
+type icmpPinger struct{}
+
+func (p icmpPinger) Ping(ctx context.Context, address string, options PingOptions) (*ping.Statistics, error) {
+	pinger, err := ping.NewPinger(address)
 	if err != nil {
 		return nil, err
 	}
 
-	pinger.Count = 3
-	pinger.Timeout = 5 * time.Second
+	pinger.Count = options.Count
+	pinger.Timeout = options.Timeout
+	pinger.SetPrivileged(options.Privileged)
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			pinger.Stop()
+		case <-done:
+		}
+	}()
+
 	err = pinger.Run()
 	if err != nil {
 		return nil, err
 	}
+	// The statistics are still valid, if the deadline stopped the pinger
+	if ctx.Err() == context.Canceled {
+		return nil, ctx.Err()
+	}
+
+	return pinger.Statistics(), nil
+}
+
+// PingStrategy reports whether a host is reachable for bool sensors and the
+// average round trip time in milliseconds or the packet loss in percent for
+// float sensors, depending on the configured metric.
+type PingStrategy struct {
+	Pinger Pinger
+}
+
+func (strgy *PingStrategy) PerformRequest(ctx context.Context, s *sensor.Sensor) (*value.SensorValue, error) {
+	metric, err := pingMetric(s)
+	if err != nil {
+		return nil, err
+	}
+
+	options := PingOptions{
+		Count:      defaultPingCount,
+		Timeout:    pollingTimeout(s),
+		Privileged: s.PollingOptions.Privileged,
+	}
+	if s.PollingOptions.Count > 0 {
+		options.Count = s.PollingOptions.Count
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if remaining := time.Until(deadline) - pingHeadroom; remaining < options.Timeout {
+			options.Timeout = remaining
+		}
+		if options.Timeout <= 0 {
+			return nil, fmt.Errorf("no time left to ping %s", s.PollingEndpoint)
+		}
+	}
 
-	stats := pinger.Statistics()
+	pinger := strgy.Pinger
+	if pinger == nil {
+		pinger = icmpPinger{}
+	}
+
+	stats, err := pinger.Ping(ctx, s.PollingEndpoint, options)
+	if err != nil {
+		return nil, err
+	}
 	log.Debug().Str("polling_endpoint", s.PollingEndpoint).Msgf("Ping Result: %v", stats)
 
-	reachable := stats.PacketsRecv > 0
+	var result string
+	switch metric {
+	case sensor.PingMetricReachable:
+		result = strconv.FormatBool(stats.PacketsRecv > 0)
+	case sensor.PingMetricRtt:
+		if stats.PacketsRecv == 0 {
+			return nil, fmt.Errorf("no reply from %s", s.PollingEndpoint)
+		}
+		result = formatFloat(float64(stats.AvgRtt.Microseconds()) / 1000)
+	case sensor.PingMetricPacketLoss:
+		result = formatFloat(stats.PacketLoss)
+	}
+
+	return newSensorValue(s, result), nil
+}
+
+func pingMetric(s *sensor.Sensor) (sensor.PingMetric, error) {
+	metric := s.PollingOptions.Metric
+	if metric == "" {
+		switch s.DataType {
+		case sensor.DataTypeBool:
+			metric = sensor.PingMetricReachable
+		case sensor.DataTypeFloat:
+			metric = sensor.PingMetricRtt
+		default:
+			return "", fmt.Errorf("PingStrategy can only be used with bool or float data types")
+		}
+	}
+
+	switch {
+	case metric == sensor.PingMetricReachable && s.DataType == sensor.DataTypeBool:
+		return metric, nil
+	case (metric == sensor.PingMetricRtt || metric == sensor.PingMetricPacketLoss) && s.DataType == sensor.DataTypeFloat:
+		return metric, nil
+	}
+
+	return "", fmt.Errorf("PingStrategy cannot report %s as %s", metric, s.DataType)
+}
 
-	return &value.SensorValue{
-		Value:     strconv.FormatBool(reachable),
-		SensorID:  s.ID,
-		DeviceID:  s.DeviceID,
-		Timestamp: time.Now(),
-	}, nil
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
 }
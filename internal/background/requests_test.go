@@ -0,0 +1,154 @@
+package background_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-ping/ping"
+	"github.com/soerenchrist/go_home/internal/background"
+	"github.com/soerenchrist/go_home/internal/sensor"
+)
+
+type fakePinger struct {
+	stats   *ping.Statistics
+	err     error
+	options background.PingOptions
+}
+
+func (p *fakePinger) Ping(ctx context.Context, address string, options background.PingOptions) (*ping.Statistics, error) {
+	p.options = options
+	return p.stats, p.err
+}
+
+func newPingSensor(dataType sensor.DataType, options sensor.PollingOptions) *sensor.Sensor {
+	return &sensor.Sensor{
+		ID:              "S2",
+		DeviceID:        "1",
+		DataType:        dataType,
+		Type:            sensor.SensorTypePolling,
+		PollingStrategy: sensor.PollingStrategyPing,
+		PollingEndpoint: "localhost",
+		PollingOptions:  options,
+	}
+}
+
+func TestPingStrategy_ShouldReportMetrics(t *testing.T) {
+	stats := &ping.Statistics{
+		PacketsSent: 4,
+		PacketsRecv: 3,
+		PacketLoss:  25,
+		AvgRtt:      12500 * time.Microsecond,
+	}
+
+	sensors := []*sensor.Sensor{
+		newPingSensor(sensor.DataTypeBool, sensor.PollingOptions{}),
+		newPingSensor(sensor.DataTypeBool, sensor.PollingOptions{Metric: sensor.PingMetricReachable}),
+		newPingSensor(sensor.DataTypeFloat, sensor.PollingOptions{}),
+		newPingSensor(sensor.DataTypeFloat, sensor.PollingOptions{Metric: sensor.PingMetricRtt}),
+		newPingSensor(sensor.DataTypeFloat, sensor.PollingOptions{Metric: sensor.PingMetricPacketLoss}),
+	}
+	expected := []string{"true", "true", "12.5", "12.5", "25"}
+
+	strategy := &background.PingStrategy{Pinger: &fakePinger{stats: stats}}
+	for i, s := range sensors {
+		result, err := strategy.PerformRequest(context.Background(), s)
+		if err != nil {
+			t.Errorf("Unexpected error for sensor %d: %s", i, err)
+			continue
+		}
+
+		if result.Value != expected[i] {
+			t.Errorf("Expected %s, got %s", expected[i], result.Value)
+		}
+	}
+}
+
+func TestPingStrategy_ShouldReportUnreachableHost(t *testing.T) {
+	stats := &ping.Statistics{PacketsSent: 3, PacketsRecv: 0, PacketLoss: 100}
+	strategy := &background.PingStrategy{Pinger: &fakePinger{stats: stats}}
+
+	result, err := strategy.PerformRequest(context.Background(), newPingSensor(sensor.DataTypeBool, sensor.PollingOptions{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Value != "false" {
+		t.Errorf("Expected false, got %s", result.Value)
+	}
+
+	_, err = strategy.PerformRequest(context.Background(), newPingSensor(sensor.DataTypeFloat, sensor.PollingOptions{Metric: sensor.PingMetricRtt}))
+	if err == nil || !strings.Contains(err.Error(), "no reply from localhost") {
+		t.Errorf("Expected no reply error, got %v", err)
+	}
+}
+
+func TestPingStrategy_ShouldPassOptionsToPinger(t *testing.T) {
+	pinger := &fakePinger{stats: &ping.Statistics{PacketsRecv: 1}}
+	strategy := &background.PingStrategy{Pinger: pinger}
+
+	_, err := strategy.PerformRequest(context.Background(), newPingSensor(sensor.DataTypeBool, sensor.PollingOptions{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pinger.options.Count != 3 || pinger.options.Timeout != 5*time.Second || pinger.options.Privileged {
+		t.Errorf("Expected default options, got %+v", pinger.options)
+	}
+
+	_, err = strategy.PerformRequest(context.Background(), newPingSensor(sensor.DataTypeBool, sensor.PollingOptions{Count: 10, TimeoutSeconds: 2, Privileged: true}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pinger.options.Count != 10 || pinger.options.Timeout != 2*time.Second || !pinger.options.Privileged {
+		t.Errorf("Expected configured options, got %+v", pinger.options)
+	}
+}
+
+// silentPinger never receives a reply and gives up after the timeout, like a
+// pinger for an unreachable host. It fails, if the context ends first.
+type silentPinger struct{}
+
+func (p silentPinger) Ping(ctx context.Context, address string, options background.PingOptions) (*ping.Statistics, error) {
+	if deadline, ok := ctx.Deadline(); ok && !deadline.After(time.Now().Add(options.Timeout)) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	time.Sleep(options.Timeout)
+	return &ping.Statistics{PacketsSent: options.Count, PacketLoss: 100}, nil
+}
+
+func TestPingStrategy_ShouldReportUnreachable_WhenHostNeverReplies(t *testing.T) {
+	strategy := &background.PingStrategy{Pinger: silentPinger{}}
+	s := newPingSensor(sensor.DataTypeBool, sensor.PollingOptions{TimeoutSeconds: 1})
+
+	// the poller uses the polling timeout as deadline of the context
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	result, err := strategy.PerformRequest(ctx, s)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if result.Value != "false" {
+		t.Errorf("Expected false, got %s", result.Value)
+	}
+}
+
+func TestPingStrategy_ShouldReturnError_WhenMetricDoesNotMatchDataType(t *testing.T) {
+	strategy := &background.PingStrategy{Pinger: &fakePinger{err: fmt.Errorf("should not be called")}}
+
+	sensors := []*sensor.Sensor{
+		newPingSensor(sensor.DataTypeInt, sensor.PollingOptions{}),
+		newPingSensor(sensor.DataTypeBool, sensor.PollingOptions{Metric: sensor.PingMetricRtt}),
+		newPingSensor(sensor.DataTypeFloat, sensor.PollingOptions{Metric: sensor.PingMetricReachable}),
+	}
+
+	for i, s := range sensors {
+		_, err := strategy.PerformRequest(context.Background(), s)
+		if err == nil || !strings.HasPrefix(err.Error(), "PingStrategy") {
+			t.Errorf("Expected data type error for sensor %d, got %v", i, err)
+		}
+	}
+}
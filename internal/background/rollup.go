@@ -0,0 +1,103 @@
+package background
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/soerenchrist/go_home/internal/value"
+)
+
+type RollupDatabase interface {
+	RollupWatermark(tier value.Tier) (time.Time, error)
+	RollupSensorValues(tier value.Tier, from, to time.Time) error
+	DeleteRollupsBefore(tier value.Tier, before time.Time) (int64, error)
+}
+
+// maxRollupBuckets limits the number of buckets rolled up in a single query,
+// so that a long backlog does not block the database.
+const maxRollupBuckets = 1000
+
+// Rollups periodically summarizes completed buckets into the hourly and daily
+// tiers and deletes buckets that are older than the retention of their tier.
+type Rollups struct {
+	database  RollupDatabase
+	interval  time.Duration
+	retention map[string]time.Duration
+}
+
+// NewRollups creates the rollup job. Retention is keyed by tier name, tiers
+// without a positive retention are kept forever.
+func NewRollups(database RollupDatabase, interval time.Duration, retention map[string]time.Duration) *Rollups {
+	return &Rollups{database: database, interval: interval, retention: retention}
+}
+
+// Run rolls up values every interval until ctx is cancelled.
+func (r *Rollups) Run(ctx context.Context) error {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := r.Rollup(ctx, time.Now()); err != nil {
+			log.Error().Err(err).Msg("Failed to roll up sensor values")
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// Rollup summarizes all buckets, that are completed at now. Finer tiers are
+// rolled up first, as coarser tiers are built from them, and expire only afterwards.
+func (r *Rollups) Rollup(ctx context.Context, now time.Time) error {
+	for i := len(value.Tiers) - 1; i >= 0; i-- {
+		if err := r.rollupTier(ctx, value.Tiers[i], now); err != nil {
+			return err
+		}
+	}
+
+	for _, tier := range value.Tiers {
+		retention := r.retention[tier.Name]
+		if retention <= 0 {
+			continue
+		}
+		deleted, err := r.database.DeleteRollupsBefore(tier, now.Add(-retention))
+		if err != nil {
+			return err
+		}
+		if deleted > 0 {
+			log.Debug().Str("tier", tier.Name).Int64("deleted", deleted).Msg("Deleted expired rollups")
+		}
+	}
+	return nil
+}
+
+func (r *Rollups) rollupTier(ctx context.Context, tier value.Tier, now time.Time) error {
+	from, err := r.database.RollupWatermark(tier)
+	if err != nil || from.IsZero() {
+		return err
+	}
+
+	step := int64(tier.Step / time.Second)
+	end := time.Unix(now.Unix()/step*step, 0).UTC()
+
+	for from.Before(end) {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		to := from.Add(maxRollupBuckets * tier.Step)
+		if to.After(end) {
+			to = end
+		}
+		if err := r.database.RollupSensorValues(tier, from, to); err != nil {
+			return err
+		}
+		log.Debug().Str("tier", tier.Name).Time("from", from).Time("to", to).Msg("Rolled up sensor values")
+		from = to
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package background
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/soerenchrist/go_home/internal/sensor"
+	"github.com/soerenchrist/go_home/internal/value"
+)
+
+// TcpStrategy connects to host:port. Bool sensors report whether the port is
+// open, int sensors report the connect latency in milliseconds.
+type TcpStrategy struct{}
+
+func (strgy *TcpStrategy) PerformRequest(ctx context.Context, s *sensor.Sensor) (*value.SensorValue, error) {
+	if s.DataType != sensor.DataTypeBool && s.DataType != sensor.DataTypeInt {
+		return nil, fmt.Errorf("TcpStrategy can only be used with bool or int data types")
+	}
+
+	start := time.Now()
+	dialer := &net.Dialer{Timeout: pollingTimeout(s)}
+	conn, err := dialer.DialContext(ctx, "tcp", s.PollingEndpoint)
+	if err != nil {
+		if s.DataType == sensor.DataTypeBool {
+			return newSensorValue(s, strconv.FormatBool(false)), nil
+		}
+		return nil, err
+	}
+	latency := time.Since(start)
+	conn.Close()
+
+	if s.DataType == sensor.DataTypeBool {
+		return newSensorValue(s, strconv.FormatBool(true)), nil
+	}
+	return newSensorValue(s, strconv.FormatInt(latency.Milliseconds(), 10)), nil
+}
+
+// TlsCertStrategy reports the number of days until the certificate presented
+// by host:port expires. Negative values mean the certificate has expired.
+type TlsCertStrategy struct{}
+
+func (strgy *TlsCertStrategy) PerformRequest(ctx context.Context, s *sensor.Sensor) (*value.SensorValue, error) {
+	if s.DataType != sensor.DataTypeInt {
+		return nil, fmt.Errorf("TlsCertStrategy can only be used with int data types")
+	}
+
+	host, _, err := net.SplitHostPort(s.PollingEndpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: pollingTimeout(s)},
+		// The certificate is inspected, not trusted, so expired or self-signed
+		// certificates must not abort the handshake.
+		Config: &tls.Config{
+			ServerName:         host,
+			InsecureSkipVerify: true,
+		},
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", s.PollingEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	certificates := conn.(*tls.Conn).ConnectionState().PeerCertificates
+	if len(certificates) == 0 {
+		return nil, fmt.Errorf("no certificate presented by %s", s.PollingEndpoint)
+	}
+
+	days := int(time.Until(certificates[0].NotAfter).Hours() / 24)
+	return newSensorValue(s, strconv.Itoa(days)), nil
+}
+
+func newSensorValue(s *sensor.Sensor, v string) *value.SensorValue {
+	return &value.SensorValue{
+		Value:     v,
+		SensorID:  s.ID,
+		DeviceID:  s.DeviceID,
+		Timestamp: time.Now().UTC(),
+	}
+}
@@ -0,0 +1,105 @@
+package background_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/soerenchrist/go_home/internal/background"
+	"github.com/soerenchrist/go_home/internal/sensor"
+)
+
+func newTcpSensor(strategy sensor.PollingStrategy, endpoint string, dataType sensor.DataType) *sensor.Sensor {
+	return &sensor.Sensor{
+		ID:              "S1",
+		DeviceID:        "1",
+		DataType:        dataType,
+		Type:            sensor.SensorTypePolling,
+		PollingStrategy: strategy,
+		PollingEndpoint: endpoint,
+		PollingOptions:  sensor.PollingOptions{TimeoutSeconds: 1},
+	}
+}
+
+func TestTcpStrategy_ShouldReportOpenPort(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	strategy := &background.TcpStrategy{}
+
+	result, err := strategy.PerformRequest(context.Background(), newTcpSensor(sensor.PollingStrategyTcp, listener.Addr().String(), sensor.DataTypeBool))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Value != "true" {
+		t.Errorf("Expected true, got %s", result.Value)
+	}
+
+	result, err = strategy.PerformRequest(context.Background(), newTcpSensor(sensor.PollingStrategyTcp, listener.Addr().String(), sensor.DataTypeInt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latency, err := strconv.Atoi(result.Value); err != nil || latency < 0 {
+		t.Errorf("Expected latency in ms, got %s", result.Value)
+	}
+}
+
+func TestTcpStrategy_ShouldReportClosedPort(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	strategy := &background.TcpStrategy{}
+
+	result, err := strategy.PerformRequest(context.Background(), newTcpSensor(sensor.PollingStrategyTcp, address, sensor.DataTypeBool))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Value != "false" {
+		t.Errorf("Expected false, got %s", result.Value)
+	}
+
+	_, err = strategy.PerformRequest(context.Background(), newTcpSensor(sensor.PollingStrategyTcp, address, sensor.DataTypeInt))
+	if err == nil {
+		t.Errorf("Expected error for latency of closed port")
+	}
+}
+
+func TestTlsCertStrategy_ShouldReportDaysUntilExpiry(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	expected := int(server.Certificate().NotAfter.Sub(server.Certificate().NotBefore).Hours() / 24)
+
+	strategy := &background.TlsCertStrategy{}
+	result, err := strategy.PerformRequest(context.Background(), newTcpSensor(sensor.PollingStrategyTls, server.Listener.Addr().String(), sensor.DataTypeInt))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	days, err := strconv.Atoi(result.Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if days <= 0 || days > expected {
+		t.Errorf("Expected between 1 and %d days, got %d", expected, days)
+	}
+}
+
+func TestTlsCertStrategy_ShouldReturnError_WhenDataTypeIsNotInt(t *testing.T) {
+	strategy := &background.TlsCertStrategy{}
+	_, err := strategy.PerformRequest(context.Background(), newTcpSensor(sensor.PollingStrategyTls, "localhost:443", sensor.DataTypeBool))
+	if err == nil || !strings.Contains(err.Error(), "int data types") {
+		t.Errorf("Expected data type error, got %v", err)
+	}
+}
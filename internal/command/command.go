@@ -1,12 +1,10 @@
 package command
 
 import (
-	"bytes"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
-	"text/template"
 	"time"
 
 	"github.com/soerenchrist/go_home/internal/device"
@@ -15,13 +13,27 @@ import (
 
 type CommandParameters map[string]string
 
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+type CommandType string
+
+const (
+	CommandTypeHttp CommandType = "http"
+	CommandTypeWol  CommandType = "wol"
+)
+
 type Command struct {
-	ID              string `json:"id"`
-	DeviceID        string `json:"device_id"`
-	Name            string `json:"name"`
-	PayloadTemplate string `json:"payload"`
-	Endpoint        string `json:"endpoint"`
-	Method          string `json:"method"`
+	ID              string      `json:"id"`
+	DeviceID        string      `json:"device_id"`
+	Name            string      `json:"name"`
+	Type            CommandType `json:"type"`
+	PayloadTemplate string      `json:"payload"`
+	Endpoint        string      `json:"endpoint"`
+	Method          string      `json:"method"`
+
+	MacAddress       string `json:"mac_address,omitempty"`
+	BroadcastAddress string `json:"broadcast_address,omitempty"`
+	Port             int    `json:"port,omitempty"`
 
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
@@ -31,24 +43,95 @@ func (c *Command) String() string {
 	return fmt.Sprintf("Command<%s %s>", c.ID, c.Name)
 }
 
-func (c *Command) Invoke(device *device.Device, params *CommandParameters) (*http.Response, error) {
-	body, err := c.prepareBody(device, params)
+func (c *Command) toRequest() CreateCommandRequest {
+	var port *int
+	if c.Port != 0 {
+		value := c.Port
+		port = &value
+	}
+	return CreateCommandRequest{
+		ID:               c.ID,
+		Name:             c.Name,
+		PayloadTemplate:  c.PayloadTemplate,
+		Endpoint:         c.Endpoint,
+		Method:           c.Method,
+		Type:             c.Type,
+		MacAddress:       c.MacAddress,
+		BroadcastAddress: c.BroadcastAddress,
+		Port:             port,
+	}
+}
+
+func (c *Command) apply(request *CreateCommandRequest) {
+	if request.Type == "" {
+		request.Type = CommandTypeHttp
+	}
+
+	if request.Type == CommandTypeWol {
+		if request.BroadcastAddress == "" {
+			request.BroadcastAddress = defaultBroadcastAddress
+		}
+		if request.Port == nil {
+			port := defaultWolPort
+			request.Port = &port
+		}
+	}
+
+	c.Name = request.Name
+	c.Type = request.Type
+	c.PayloadTemplate = request.PayloadTemplate
+	c.Endpoint = request.Endpoint
+	c.Method = request.Method
+	c.MacAddress = request.MacAddress
+	c.BroadcastAddress = request.BroadcastAddress
+	c.Port = 0
+	if request.Port != nil {
+		c.Port = *request.Port
+	}
+}
+
+func (c *Command) Invoke(device *device.Device, params *CommandParameters, values SensorValueReader) (*InvocationResult, error) {
+	if c.Type == CommandTypeWol {
+		return c.wakeOnLan()
+	}
+	return c.sendRequest(device, params, values)
+}
+
+func (c *Command) sendRequest(device *device.Device, params *CommandParameters, values SensorValueReader) (*InvocationResult, error) {
+	data := c.templateParameters(device, params)
+
+	endpoint, err := executeTemplate("endpoint", c.Endpoint, &data, values)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := c.prepareBody(&data, values)
 	if err != nil {
 		return nil, err
 	}
-	req, err := http.NewRequest(c.Method, c.Endpoint, body)
+	req, err := http.NewRequest(c.Method, endpoint, body)
 	if err != nil {
 		return nil, err
 	}
 
-	return http.DefaultClient.Do(req)
-}
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
 
-func (command *Command) prepareBody(device *device.Device, params *CommandParameters) (io.Reader, error) {
-	if len(command.PayloadTemplate) == 0 {
-		return nil, nil
+	response, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
 	}
 
+	return &InvocationResult{
+		Response:   string(response),
+		StatusCode: res.StatusCode,
+	}, nil
+}
+
+func (command *Command) templateParameters(device *device.Device, params *CommandParameters) TemplateParameters {
 	var data TemplateParameters = make(map[string]string)
 	data["command_id"] = command.ID
 	data["command_name"] = command.Name
@@ -56,37 +139,43 @@ func (command *Command) prepareBody(device *device.Device, params *CommandParame
 	data["device_name"] = device.Name
 	data["now"] = util.GetTimestamp()
 
-	for key, value := range *params {
-		data[fmt.Sprintf("p_%s", key)] = value
+	if params != nil {
+		for key, value := range *params {
+			data[fmt.Sprintf("p_%s", key)] = value
+		}
 	}
 
-	return PrepareCommandTemplate(command.PayloadTemplate, &data)
-}
-
-type InvocationResult struct {
-	Response   string `json:"response"`
-	StatusCode int    `json:"statusCode"`
+	return data
 }
 
-type TemplateParameters map[string]string
-
-func PrepareCommandTemplate(payloadTemplate string, params *TemplateParameters) (io.Reader, error) {
-
-	t := template.Must(template.New("payload").Parse(payloadTemplate))
-	var b bytes.Buffer
+func (command *Command) prepareBody(data *TemplateParameters, values SensorValueReader) (io.Reader, error) {
+	if len(command.PayloadTemplate) == 0 {
+		return nil, nil
+	}
 
-	err := t.Execute(&b, params)
+	body, err := executeTemplate("payload", command.PayloadTemplate, data, values)
 	if err != nil {
 		return nil, err
 	}
 
-	return strings.NewReader(b.String()), nil
+	return strings.NewReader(body), nil
+}
+
+type InvocationResult struct {
+	Response   string `json:"response"`
+	StatusCode int    `json:"statusCode"`
 }
 
 type CreateCommandRequest struct {
-	ID              string `json:"id"`
-	Name            string `json:"name"`
-	PayloadTemplate string `json:"payload_template"`
-	Endpoint        string `json:"endpoint"`
-	Method          string `json:"method"`
+	ID              string      `json:"id"`
+	Name            string      `json:"name"`
+	Type            CommandType `json:"type"`
+	PayloadTemplate string      `json:"payload_template"`
+	Endpoint        string      `json:"endpoint"`
+	Method          string      `json:"method"`
+
+	MacAddress       string `json:"mac_address"`
+	BroadcastAddress string `json:"broadcast_address"`
+	// Port is a pointer to distinguish a missing port from port 0
+	Port *int `json:"port"`
 }
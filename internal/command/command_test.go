@@ -1,10 +1,17 @@
 package command_test
 
 import (
+	"fmt"
 	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/soerenchrist/go_home/internal/command"
+	"github.com/soerenchrist/go_home/internal/device"
+	"github.com/soerenchrist/go_home/internal/value"
 )
 
 var template = `{
@@ -85,3 +92,206 @@ func TestPrepareTemplateWithIf(t *testing.T) {
 		t.Errorf("Expected %s, got %s", expected, string(bytes))
 	}
 }
+
+func TestPrepareTemplate_ShouldReturnError_WhenKeyIsMissing(t *testing.T) {
+	var params command.TemplateParameters = make(map[string]string)
+
+	_, err := command.PrepareCommandTemplate(`{{.p_missing}}`, &params)
+	if err == nil {
+		t.Fatalf("Expected error for missing key, got none")
+	}
+
+	if !strings.Contains(err.Error(), "p_missing") {
+		t.Errorf("Expected error to mention missing key, got %s", err)
+	}
+}
+
+func TestPrepareTemplate_ShouldReturnError_WhenTemplateIsMalformed(t *testing.T) {
+	var params command.TemplateParameters = make(map[string]string)
+
+	_, err := command.PrepareCommandTemplate(`{{.p_payload`, &params)
+	if err == nil {
+		t.Fatalf("Expected error for malformed template, got none")
+	}
+}
+
+func TestParseCommandTemplate_ShouldRejectUnknownFunctions(t *testing.T) {
+	_, err := command.ParseCommandTemplate("payload", `{{lower .p_payload}}`)
+	if err == nil {
+		t.Fatalf("Expected error for unknown function, got none")
+	}
+}
+
+func TestPrepareTemplate_ShouldApplyFunctions(t *testing.T) {
+	var params command.TemplateParameters = make(map[string]string)
+	params["p_payload"] = "on"
+	params["p_level"] = "20"
+	params["p_name"] = `Living "room"`
+
+	templates := []string{
+		`{{upper .p_payload}}`,
+		`{{index . "p_missing" | default "off"}}`,
+		`{{.p_payload | default "off"}}`,
+		`{{math .p_level "*" 2.5}}`,
+		`{{math .p_level "/" 8}}`,
+		`{{json .p_name}}`,
+		`{{urlquery .p_name}}`,
+		`{{now "2006"}}`,
+	}
+
+	expected := []string{
+		"ON",
+		"off",
+		"on",
+		"50",
+		"2.5",
+		`"Living \"room\""`,
+		"Living+%22room%22",
+		fmt.Sprint(time.Now().Year()),
+	}
+
+	for i, tmpl := range templates {
+		reader, err := command.PrepareCommandTemplate(tmpl, &params)
+		if err != nil {
+			t.Errorf("Error preparing template %s: %s", tmpl, err)
+			continue
+		}
+
+		bytes, err := io.ReadAll(reader)
+		if err != nil {
+			t.Errorf("Error reading from reader: %s", err)
+		}
+
+		if string(bytes) != expected[i] {
+			t.Errorf("Expected %s, got %s", expected[i], string(bytes))
+		}
+	}
+}
+
+func TestPrepareTemplate_ShouldReturnError_WhenDividingByZero(t *testing.T) {
+	var params command.TemplateParameters = make(map[string]string)
+
+	_, err := command.PrepareCommandTemplate(`{{math 1 "/" 0}}`, &params)
+	if err == nil {
+		t.Fatalf("Expected error for division by zero, got none")
+	}
+}
+
+type fakeSensorValues struct{}
+
+func (f fakeSensorValues) GetCurrentSensorValue(deviceId, sensorId string) (*value.SensorValue, error) {
+	if deviceId == "1" && sensorId == "S1" {
+		return &value.SensorValue{DeviceID: "1", SensorID: "S1", Value: "21.5"}, nil
+	}
+	return nil, fmt.Errorf("not found")
+}
+
+func TestInvoke_ShouldRenderSensorValuesIntoEndpoint(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		fmt.Fprintf(w, "%s %s", r.URL.RawQuery, string(body))
+	}))
+	defer server.Close()
+
+	cmd := &command.Command{
+		ID:              "C1",
+		Name:            "Set",
+		Method:          "POST",
+		Endpoint:        server.URL + `/set?temp={{sensor "1.S1"}}&name={{urlquery .device_name}}`,
+		PayloadTemplate: `{"level": {{math .p_level "+" 1}}}`,
+	}
+	d := &device.Device{ID: "1", Name: "My Device"}
+	params := command.CommandParameters{"level": "4"}
+
+	res, err := cmd.Invoke(d, &params, fakeSensorValues{})
+	if err != nil {
+		t.Fatalf("Error invoking command: %s", err)
+	}
+
+	expected := `temp=21.5&name=My+Device {"level": 5}`
+	if res.Response != expected {
+		t.Errorf("Expected %s, got %s", expected, res.Response)
+	}
+	if res.StatusCode != 200 {
+		t.Errorf("Expected status code 200, got %d", res.StatusCode)
+	}
+}
+
+func TestInvoke_ShouldReturnError_WhenSensorValueIsMissing(t *testing.T) {
+	cmd := &command.Command{
+		Method:          "POST",
+		Endpoint:        "http://localhost",
+		PayloadTemplate: `{{sensor "1.S2"}}`,
+	}
+	params := command.CommandParameters{}
+
+	_, err := cmd.Invoke(&device.Device{ID: "1"}, &params, fakeSensorValues{})
+	if err == nil {
+		t.Fatalf("Expected error for missing sensor value, got none")
+	}
+}
+
+func TestPrepareTemplate_ShouldTreatMissingConditionKeysAsEmpty(t *testing.T) {
+	var params command.TemplateParameters = make(map[string]string)
+	params["p_payload"] = "on"
+
+	templates := []string{
+		`{{if and .p_payload .p_level}}{{.p_level}}{{else}}none{{end}}`,
+		`{{with .p_level}}{{.}}{{else}}none{{end}}`,
+	}
+
+	for _, tmpl := range templates {
+		reader, err := command.PrepareCommandTemplate(tmpl, &params)
+		if err != nil {
+			t.Errorf("Error preparing template %s: %s", tmpl, err)
+			continue
+		}
+
+		bytes, _ := io.ReadAll(reader)
+		if string(bytes) != "none" {
+			t.Errorf("Expected none, got %s", string(bytes))
+		}
+	}
+
+	if _, ok := params["p_level"]; ok {
+		t.Errorf("Expected parameters to stay unchanged")
+	}
+}
+
+func TestPrepareTemplate_ShouldAcceptMissingKeys_InDefaultAndMath(t *testing.T) {
+	var params command.TemplateParameters = make(map[string]string)
+	params["p_level"] = "4"
+
+	templates := []string{
+		`{{default "off" .p_missing}}`,
+		`{{.p_missing | default "off"}}`,
+		`{{math .p_missing "+" 1}}`,
+		`{{math .p_level "+" .p_missing}}`,
+		`{{math (default 2 .p_missing) "*" .p_level}}`,
+	}
+
+	expected := []string{
+		"off",
+		"off",
+		"1",
+		"4",
+		"8",
+	}
+
+	for i, tmpl := range templates {
+		reader, err := command.PrepareCommandTemplate(tmpl, &params)
+		if err != nil {
+			t.Errorf("Error preparing template %s: %s", tmpl, err)
+			continue
+		}
+
+		bytes, _ := io.ReadAll(reader)
+		if string(bytes) != expected[i] {
+			t.Errorf("Expected %s, got %s", expected[i], string(bytes))
+		}
+	}
+
+	if _, err := command.PrepareCommandTemplate(`{{default "off" .p_missing}} {{.p_other}}`, &params); err == nil {
+		t.Errorf("Expected error for missing key outside of default, got none")
+	}
+}
@@ -1,7 +1,9 @@
 package command
 
 import (
-	"io"
+	"fmt"
+	"net"
+	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/rs/zerolog/log"
@@ -14,15 +16,18 @@ type CommandsDatabase interface {
 	GetCommand(deviceId string, commandId string) (*Command, error)
 	GetDevice(deviceId string) (*device.Device, error)
 	AddCommand(command *Command) error
+	UpdateCommand(command *Command) error
 	DeleteCommand(deviceId string, commandId string) error
+	SensorValueReader
 }
 
 type CommandsController struct {
-	database CommandsDatabase
+	database   CommandsDatabase
+	dispatcher *Dispatcher
 }
 
-func NewController(database CommandsDatabase) *CommandsController {
-	return &CommandsController{database: database}
+func NewController(database CommandsDatabase, dispatcher *Dispatcher) *CommandsController {
+	return &CommandsController{database: database, dispatcher: dispatcher}
 }
 
 func (c *CommandsController) GetCommands(context *gin.Context) {
@@ -79,15 +84,20 @@ func (c *CommandsController) PostCommand(context *gin.Context) {
 	}
 
 	command := Command{
-		ID:              request.ID,
-		Name:            request.Name,
-		DeviceID:        deviceId,
-		PayloadTemplate: request.PayloadTemplate,
-		Endpoint:        request.Endpoint,
-		Method:          request.Method,
+		ID:       request.ID,
+		DeviceID: deviceId,
 	}
+	command.apply(&request)
 
-	if err := c.database.AddCommand(&command); err != nil {
+	// Command ids are unique across all devices, so duplicates are only
+	// detected reliably by the primary key of the database.
+	err := c.database.AddCommand(&command)
+	if conflict, isConflict := err.(*errors.ConflictError); isConflict {
+		context.JSON(409, gin.H{"error": conflict.Error()})
+		return
+	}
+
+	if err != nil {
 		context.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
@@ -95,6 +105,54 @@ func (c *CommandsController) PostCommand(context *gin.Context) {
 	context.JSON(201, command)
 }
 
+func (c *CommandsController) PutCommand(context *gin.Context) {
+	c.updateCommand(context, false)
+}
+
+func (c *CommandsController) PatchCommand(context *gin.Context) {
+	c.updateCommand(context, true)
+}
+
+func (c *CommandsController) updateCommand(context *gin.Context, partial bool) {
+	deviceId := context.Param("deviceId")
+	commandId := context.Param("commandId")
+
+	if _, err := c.database.GetDevice(deviceId); err != nil {
+		context.JSON(404, gin.H{"error": "Device not found"})
+		return
+	}
+
+	command, err := c.database.GetCommand(deviceId, commandId)
+	if err != nil {
+		context.JSON(404, gin.H{"error": "Command not found"})
+		return
+	}
+
+	var request CreateCommandRequest
+	if partial {
+		request = command.toRequest()
+	}
+
+	if err := context.BindJSON(&request); err != nil {
+		context.JSON(400, gin.H{"error": "Invalid JSON"})
+		return
+	}
+
+	if err := c.validateCommand(&request); err != nil {
+		context.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	command.apply(&request)
+
+	if err := c.database.UpdateCommand(command); err != nil {
+		context.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	context.JSON(200, command)
+}
+
 func (c *CommandsController) DeleteCommand(context *gin.Context) {
 	deviceId := context.Param("deviceId")
 	commandId := context.Param("commandId")
@@ -142,29 +200,45 @@ func (c *CommandsController) InvokeCommand(context *gin.Context) {
 		return
 	}
 
-	var result InvocationResult
-	res, err := command.Invoke(device, &params)
+	invocation, err := c.dispatcher.Submit(command, device, params)
+	if _, isFull := err.(*QueueFullError); isFull {
+		context.JSON(503, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		context.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
-	body, err := io.ReadAll(res.Body)
+
+	result, err := waitWithDeadline(context.Request.Context(), http.NewResponseController(context.Writer), invocation)
 	if err != nil {
 		context.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
 
-	result.Response = string(body)
-	result.StatusCode = res.StatusCode
-
 	context.JSON(200, result)
 }
 
+func (c *CommandsController) GetQueue(context *gin.Context) {
+	context.JSON(200, c.dispatcher.Stats())
+}
+
 func (c *CommandsController) validateCommand(command *CreateCommandRequest) error {
 	if command.Name == "" {
 		return &errors.ValidationError{Message: "Name is required"}
 	}
 
+	switch command.Type {
+	case "", CommandTypeHttp:
+		return c.validateHttpCommand(command)
+	case CommandTypeWol:
+		return c.validateWolCommand(command)
+	}
+
+	return &errors.ValidationError{Message: "Type must be one of http or wol"}
+}
+
+func (c *CommandsController) validateHttpCommand(command *CreateCommandRequest) error {
 	if command.Endpoint == "" {
 		return &errors.ValidationError{Message: "Endpoint is required"}
 	}
@@ -172,6 +246,13 @@ func (c *CommandsController) validateCommand(command *CreateCommandRequest) erro
 		return &errors.ValidationError{Message: "Payload template is required"}
 	}
 
+	if _, err := ParseCommandTemplate("endpoint", command.Endpoint); err != nil {
+		return &errors.ValidationError{Message: fmt.Sprintf("Invalid endpoint template: %s", err.Error())}
+	}
+	if _, err := ParseCommandTemplate("payload", command.PayloadTemplate); err != nil {
+		return &errors.ValidationError{Message: fmt.Sprintf("Invalid payload template: %s", err.Error())}
+	}
+
 	methods := []string{"GET", "POST", "PUT", "DELETE"}
 	if !contains(methods, command.Method) {
 		return &errors.ValidationError{Message: "Method must be one of GET, POST, PUT or DELETE"}
@@ -180,6 +261,26 @@ func (c *CommandsController) validateCommand(command *CreateCommandRequest) erro
 	return nil
 }
 
+func (c *CommandsController) validateWolCommand(command *CreateCommandRequest) error {
+	if command.MacAddress == "" {
+		return &errors.ValidationError{Message: "MAC address is required"}
+	}
+
+	if _, err := parseMacAddress(command.MacAddress); err != nil {
+		return &errors.ValidationError{Message: fmt.Sprintf("Invalid MAC address: %s", command.MacAddress)}
+	}
+
+	if command.BroadcastAddress != "" && net.ParseIP(command.BroadcastAddress) == nil {
+		return &errors.ValidationError{Message: fmt.Sprintf("Invalid broadcast address: %s", command.BroadcastAddress)}
+	}
+
+	if command.Port != nil && (*command.Port < 1 || *command.Port > 65535) {
+		return &errors.ValidationError{Message: "Port must be between 1 and 65535"}
+	}
+
+	return nil
+}
+
 func contains(s []string, e string) bool {
 	for _, a := range s {
 		if a == e {
@@ -0,0 +1,35 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// invokeWriteTimeout is the time, in which the result of an invocation must be
+// written. It replaces the write timeout of the server, which would cut off
+// invocations that are queued or wait for a slow endpoint.
+const invokeWriteTimeout = 10 * time.Second
+
+// waitWithDeadline waits for the invocation and extends the write deadline of
+// the response until it is done or the request is cancelled.
+func waitWithDeadline(ctx context.Context, controller *http.ResponseController, invocation *Invocation) (*InvocationResult, error) {
+	ticker := time.NewTicker(invokeWriteTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		err := controller.SetWriteDeadline(time.Now().Add(invokeWriteTimeout))
+		if err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return nil, err
+		}
+
+		select {
+		case <-invocation.done:
+			return invocation.result, invocation.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package command
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/soerenchrist/go_home/internal/device"
+)
+
+type QueueFullError struct {
+	Capacity int
+}
+
+func (e *QueueFullError) Error() string {
+	return fmt.Sprintf("command queue is full (capacity %d)", e.Capacity)
+}
+
+type Invocation struct {
+	Command     *Command
+	Device      *device.Device
+	Params      CommandParameters
+	SubmittedAt time.Time
+
+	done   chan struct{}
+	result *InvocationResult
+	err    error
+}
+
+func (i *Invocation) Wait() (*InvocationResult, error) {
+	<-i.done
+	return i.result, i.err
+}
+
+type QueueStats struct {
+	Workers   int            `json:"workers"`
+	Capacity  int            `json:"capacity"`
+	Queued    int            `json:"queued"`
+	Running   int            `json:"running"`
+	Completed uint64         `json:"completed"`
+	Failed    uint64         `json:"failed"`
+	Rejected  uint64         `json:"rejected"`
+	Devices   map[string]int `json:"devices"`
+}
+
+// Dispatcher executes command invocations on a bounded pool of workers.
+// Invocations for the same device are run one after another in the order
+// they were submitted, while different devices are served in parallel.
+type Dispatcher struct {
+	values   SensorValueReader
+	workers  int
+	capacity int
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	pending map[string][]*Invocation
+	busy    map[string]bool
+	ready   []string
+	queued  int
+	running int
+	stopped bool
+	wg      sync.WaitGroup
+
+	completed uint64
+	failed    uint64
+	rejected  uint64
+}
+
+func NewDispatcher(values SensorValueReader, workers int, capacity int) *Dispatcher {
+	if workers < 1 {
+		workers = 1
+	}
+	if capacity < 1 {
+		capacity = 1
+	}
+
+	d := &Dispatcher{
+		values:   values,
+		workers:  workers,
+		capacity: capacity,
+		pending:  make(map[string][]*Invocation),
+		busy:     make(map[string]bool),
+		ready:    make([]string, 0),
+	}
+	d.cond = sync.NewCond(&d.mu)
+	return d
+}
+
+func (d *Dispatcher) Start() {
+	for i := 0; i < d.workers; i++ {
+		d.wg.Add(1)
+		go d.work()
+	}
+	log.Info().Int("workers", d.workers).Int("capacity", d.capacity).Msg("Started command dispatcher")
+}
+
+func (d *Dispatcher) Stop() {
+	d.mu.Lock()
+	d.stopped = true
+	for deviceId, invocations := range d.pending {
+		for _, invocation := range invocations {
+			invocation.err = fmt.Errorf("command dispatcher stopped")
+			close(invocation.done)
+		}
+		delete(d.pending, deviceId)
+	}
+	d.queued = 0
+	d.cond.Broadcast()
+	d.mu.Unlock()
+
+	d.wg.Wait()
+}
+
+func (d *Dispatcher) Submit(command *Command, device *device.Device, params CommandParameters) (*Invocation, error) {
+	invocation := &Invocation{
+		Command:     command,
+		Device:      device,
+		Params:      params,
+		SubmittedAt: time.Now(),
+		done:        make(chan struct{}),
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.stopped {
+		return nil, fmt.Errorf("command dispatcher stopped")
+	}
+
+	if d.queued >= d.capacity {
+		d.rejected++
+		return nil, &QueueFullError{Capacity: d.capacity}
+	}
+
+	d.pending[device.ID] = append(d.pending[device.ID], invocation)
+	d.queued++
+
+	if !d.busy[device.ID] {
+		d.busy[device.ID] = true
+		d.ready = append(d.ready, device.ID)
+		d.cond.Signal()
+	}
+
+	return invocation, nil
+}
+
+func (d *Dispatcher) Stats() QueueStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	devices := make(map[string]int)
+	for deviceId, invocations := range d.pending {
+		if len(invocations) > 0 {
+			devices[deviceId] = len(invocations)
+		}
+	}
+
+	return QueueStats{
+		Workers:   d.workers,
+		Capacity:  d.capacity,
+		Queued:    d.queued,
+		Running:   d.running,
+		Completed: d.completed,
+		Failed:    d.failed,
+		Rejected:  d.rejected,
+		Devices:   devices,
+	}
+}
+
+func (d *Dispatcher) work() {
+	defer d.wg.Done()
+
+	for {
+		invocation, ok := d.next()
+		if !ok {
+			return
+		}
+
+		result, err := d.execute(invocation)
+		invocation.result = result
+		invocation.err = err
+		d.finish(invocation)
+		close(invocation.done)
+	}
+}
+
+func (d *Dispatcher) next() (*Invocation, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for len(d.ready) == 0 && !d.stopped {
+		d.cond.Wait()
+	}
+	if d.stopped {
+		return nil, false
+	}
+
+	deviceId := d.ready[0]
+	d.ready = d.ready[1:]
+
+	invocation := d.pending[deviceId][0]
+	d.pending[deviceId] = d.pending[deviceId][1:]
+	d.queued--
+	d.running++
+
+	return invocation, true
+}
+
+func (d *Dispatcher) finish(invocation *Invocation) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.running--
+	if invocation.err != nil {
+		d.failed++
+	} else {
+		d.completed++
+	}
+
+	deviceId := invocation.Device.ID
+	if len(d.pending[deviceId]) > 0 && !d.stopped {
+		d.ready = append(d.ready, deviceId)
+		d.cond.Signal()
+		return
+	}
+
+	delete(d.pending, deviceId)
+	delete(d.busy, deviceId)
+}
+
+func (d *Dispatcher) execute(invocation *Invocation) (*InvocationResult, error) {
+	logger := log.With().
+		Str("device_id", invocation.Device.ID).
+		Str("command_id", invocation.Command.ID).
+		Logger()
+
+	logger.Debug().Dur("waited", time.Since(invocation.SubmittedAt)).Msg("Executing command")
+
+	result, err := invocation.Command.Invoke(invocation.Device, &invocation.Params, d.values)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to invoke command")
+		return nil, err
+	}
+
+	logger.Debug().Int("response_status", result.StatusCode).Msg("Command executed")
+	return result, nil
+}
@@ -0,0 +1,153 @@
+package command_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/soerenchrist/go_home/internal/command"
+	"github.com/soerenchrist/go_home/internal/device"
+)
+
+type concurrencyRecorder struct {
+	mu      sync.Mutex
+	current map[string]int
+	max     map[string]int
+	total   int32
+	maxAll  int32
+}
+
+func newConcurrencyServer(t *testing.T, delay time.Duration) (*httptest.Server, *concurrencyRecorder) {
+	recorder := &concurrencyRecorder{current: make(map[string]int), max: make(map[string]int)}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deviceId := r.URL.Query().Get("device")
+
+		recorder.mu.Lock()
+		recorder.current[deviceId]++
+		if recorder.current[deviceId] > recorder.max[deviceId] {
+			recorder.max[deviceId] = recorder.current[deviceId]
+		}
+		recorder.mu.Unlock()
+		total := atomic.AddInt32(&recorder.total, 1)
+		for {
+			maxAll := atomic.LoadInt32(&recorder.maxAll)
+			if total <= maxAll || atomic.CompareAndSwapInt32(&recorder.maxAll, maxAll, total) {
+				break
+			}
+		}
+
+		time.Sleep(delay)
+
+		atomic.AddInt32(&recorder.total, -1)
+		recorder.mu.Lock()
+		recorder.current[deviceId]--
+		recorder.mu.Unlock()
+		w.WriteHeader(200)
+	}))
+	t.Cleanup(server.Close)
+	return server, recorder
+}
+
+func newTestCommand(url string) *command.Command {
+	return &command.Command{
+		ID:              "C1",
+		Method:          "POST",
+		Endpoint:        url + "?device={{.device_id}}",
+		PayloadTemplate: "on",
+	}
+}
+
+func TestDispatcher_ShouldSerializeCommandsPerDevice(t *testing.T) {
+	server, recorder := newConcurrencyServer(t, 20*time.Millisecond)
+	dispatcher := command.NewDispatcher(nil, 4, 20)
+	dispatcher.Start()
+	defer dispatcher.Stop()
+
+	cmd := newTestCommand(server.URL)
+	devices := []*device.Device{{ID: "1"}, {ID: "2"}}
+
+	invocations := make([]*command.Invocation, 0)
+	for i := 0; i < 4; i++ {
+		for _, d := range devices {
+			invocation, err := dispatcher.Submit(cmd, d, command.CommandParameters{})
+			if err != nil {
+				t.Fatalf("Failed to submit command: %s", err)
+			}
+			invocations = append(invocations, invocation)
+		}
+	}
+
+	for _, invocation := range invocations {
+		result, err := invocation.Wait()
+		if err != nil {
+			t.Fatalf("Invocation failed: %s", err)
+		}
+		if result.StatusCode != 200 {
+			t.Errorf("Expected status 200, got %d", result.StatusCode)
+		}
+	}
+
+	for _, d := range devices {
+		if recorder.max[d.ID] != 1 {
+			t.Errorf("Expected at most 1 concurrent command for device %s, got %d", d.ID, recorder.max[d.ID])
+		}
+	}
+
+	if recorder.maxAll < 2 {
+		t.Errorf("Expected commands for different devices to run in parallel, got %d", recorder.maxAll)
+	}
+
+	stats := dispatcher.Stats()
+	if stats.Completed != 8 || stats.Queued != 0 || stats.Running != 0 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestDispatcher_ShouldRejectCommands_WhenQueueIsFull(t *testing.T) {
+	server, _ := newConcurrencyServer(t, 50*time.Millisecond)
+	dispatcher := command.NewDispatcher(nil, 1, 2)
+	dispatcher.Start()
+	defer dispatcher.Stop()
+
+	cmd := newTestCommand(server.URL)
+	d := &device.Device{ID: "1"}
+
+	var rejected error
+	for i := 0; i < 5; i++ {
+		if _, err := dispatcher.Submit(cmd, d, command.CommandParameters{}); err != nil {
+			rejected = err
+		}
+	}
+
+	if _, isFull := rejected.(*command.QueueFullError); !isFull {
+		t.Fatalf("Expected queue full error, got %v", rejected)
+	}
+
+	if dispatcher.Stats().Rejected == 0 {
+		t.Errorf("Expected rejected commands to be counted")
+	}
+}
+
+func TestDispatcher_ShouldReportErrors(t *testing.T) {
+	dispatcher := command.NewDispatcher(nil, 1, 2)
+	dispatcher.Start()
+	defer dispatcher.Stop()
+
+	cmd := &command.Command{Method: "POST", Endpoint: "{{.p_missing}}", PayloadTemplate: "on"}
+
+	invocation, err := dispatcher.Submit(cmd, &device.Device{ID: "1"}, command.CommandParameters{})
+	if err != nil {
+		t.Fatalf("Failed to submit command: %s", err)
+	}
+
+	if _, err := invocation.Wait(); err == nil {
+		t.Errorf("Expected invocation to fail")
+	}
+
+	if dispatcher.Stats().Failed != 1 {
+		t.Errorf("Expected failed invocation to be counted")
+	}
+}
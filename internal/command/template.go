@@ -0,0 +1,245 @@
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+
+	"github.com/soerenchrist/go_home/internal/value"
+)
+
+type TemplateParameters map[string]string
+
+type SensorValueReader interface {
+	GetCurrentSensorValue(deviceId, sensorId string) (*value.SensorValue, error)
+}
+
+// ParseCommandTemplate parses a payload or endpoint template in strict mode.
+// Missing keys are reported as errors, except in the conditions of if and with
+// and in the arguments of default and math, so optional parameters can still be
+// checked with `{{if .p_name}}` or replaced with `{{default "on" .p_name}}`.
+func ParseCommandTemplate(name string, text string) (*template.Template, error) {
+	return template.New(name).
+		Option("missingkey=error").
+		Funcs(templateFuncs(nil)).
+		Parse(text)
+}
+
+func PrepareCommandTemplate(payloadTemplate string, params *TemplateParameters) (io.Reader, error) {
+	result, err := executeTemplate("payload", payloadTemplate, params, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return strings.NewReader(result), nil
+}
+
+func executeTemplate(name string, text string, params *TemplateParameters, values SensorValueReader) (string, error) {
+	t, err := ParseCommandTemplate(name, text)
+	if err != nil {
+		return "", err
+	}
+	t.Funcs(templateFuncs(values))
+
+	data := make(TemplateParameters)
+	for _, key := range optionalKeys(t) {
+		data[key] = ""
+	}
+	if params != nil {
+		for key, value := range *params {
+			data[key] = value
+		}
+	}
+
+	var b bytes.Buffer
+	if err := t.Execute(&b, data); err != nil {
+		return "", err
+	}
+
+	return b.String(), nil
+}
+
+// optionalKeys returns the keys, that are used in the conditions of if and with
+// actions or as arguments of default and math. They are treated as empty when
+// missing instead of failing the execution.
+func optionalKeys(t *template.Template) []string {
+	var keys []string
+	for _, tmpl := range t.Templates() {
+		if tmpl.Tree != nil {
+			keys = appendOptionalKeys(keys, tmpl.Tree.Root, false)
+		}
+	}
+	return keys
+}
+
+func appendOptionalKeys(keys []string, node parse.Node, optional bool) []string {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return keys
+		}
+		for _, child := range n.Nodes {
+			keys = appendOptionalKeys(keys, child, false)
+		}
+	case *parse.ActionNode:
+		keys = appendOptionalKeys(keys, n.Pipe, false)
+	case *parse.IfNode:
+		keys = appendBranchKeys(keys, &n.BranchNode)
+	case *parse.WithNode:
+		keys = appendBranchKeys(keys, &n.BranchNode)
+	case *parse.RangeNode:
+		keys = appendOptionalKeys(keys, n.Pipe, false)
+		keys = appendOptionalKeys(keys, n.List, false)
+		keys = appendOptionalKeys(keys, n.ElseList, false)
+	case *parse.PipeNode:
+		if n == nil {
+			return keys
+		}
+		for i, cmd := range n.Cmds {
+			// The result of a command is the last argument of the next one
+			piped := i+1 < len(n.Cmds) && isLenientFunc(n.Cmds[i+1])
+			for _, arg := range cmd.Args {
+				keys = appendOptionalKeys(keys, arg, optional || piped || isLenientFunc(cmd))
+			}
+		}
+	case *parse.FieldNode:
+		if optional && len(n.Ident) == 1 {
+			keys = append(keys, n.Ident[0])
+		}
+	}
+	return keys
+}
+
+func appendBranchKeys(keys []string, branch *parse.BranchNode) []string {
+	keys = appendOptionalKeys(keys, branch.Pipe, true)
+	keys = appendOptionalKeys(keys, branch.List, false)
+	return appendOptionalKeys(keys, branch.ElseList, false)
+}
+
+// isLenientFunc reports, whether the command calls a function, that accepts missing keys.
+func isLenientFunc(cmd *parse.CommandNode) bool {
+	if len(cmd.Args) == 0 {
+		return false
+	}
+	ident, ok := cmd.Args[0].(*parse.IdentifierNode)
+	return ok && (ident.Ident == "default" || ident.Ident == "math")
+}
+
+// urlquery is not listed here, because text/template already provides it as a builtin.
+func templateFuncs(values SensorValueReader) template.FuncMap {
+	return template.FuncMap{
+		"json":    toJson,
+		"upper":   strings.ToUpper,
+		"default": defaultValue,
+		"math":    calculate,
+		"now":     formatNow,
+		"sensor":  currentSensorValue(values),
+	}
+}
+
+func toJson(v any) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func defaultValue(fallback any, v any) any {
+	if v == nil {
+		return fallback
+	}
+	if s, ok := v.(string); ok && s == "" {
+		return fallback
+	}
+	return v
+}
+
+// calculate applies the operator to both operands. Missing operands are treated as 0.
+func calculate(left any, operator string, right any) (string, error) {
+	a, err := toFloat(left)
+	if err != nil {
+		return "", err
+	}
+	b, err := toFloat(right)
+	if err != nil {
+		return "", err
+	}
+
+	var result float64
+	switch operator {
+	case "+":
+		result = a + b
+	case "-":
+		result = a - b
+	case "*":
+		result = a * b
+	case "/":
+		if b == 0 {
+			return "", fmt.Errorf("division by zero")
+		}
+		result = a / b
+	case "%":
+		if b == 0 {
+			return "", fmt.Errorf("division by zero")
+		}
+		result = math.Mod(a, b)
+	default:
+		return "", fmt.Errorf("unknown operator %s", operator)
+	}
+
+	return strconv.FormatFloat(result, 'f', -1, 64), nil
+}
+
+func toFloat(v any) (float64, error) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), nil
+	case int64:
+		return float64(n), nil
+	case float64:
+		return n, nil
+	case string:
+		if n == "" {
+			return 0, nil
+		}
+		f, err := strconv.ParseFloat(n, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%s is not a number", n)
+		}
+		return f, nil
+	case nil:
+		return 0, nil
+	}
+	return 0, fmt.Errorf("%v is not a number", v)
+}
+
+func formatNow(layout string) string {
+	return time.Now().Format(layout)
+}
+
+func currentSensorValue(values SensorValueReader) func(string) (string, error) {
+	return func(key string) (string, error) {
+		parts := strings.Split(key, ".")
+		if len(parts) != 2 {
+			return "", fmt.Errorf("invalid sensor %s - Should consist of deviceId.sensorId", key)
+		}
+
+		if values == nil {
+			return "", fmt.Errorf("sensor values are not available")
+		}
+
+		sensorValue, err := values.GetCurrentSensorValue(parts[0], parts[1])
+		if err != nil {
+			return "", fmt.Errorf("no current value for sensor %s", key)
+		}
+		return sensorValue.Value, nil
+	}
+}
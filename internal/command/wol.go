@@ -0,0 +1,59 @@
+package command
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strconv"
+)
+
+const (
+	defaultBroadcastAddress = "255.255.255.255"
+	defaultWolPort          = 9
+)
+
+// MagicPacket builds a Wake-on-LAN packet: six 0xFF bytes followed by
+// sixteen repetitions of the target MAC address.
+func MagicPacket(macAddress string) ([]byte, error) {
+	mac, err := parseMacAddress(macAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	packet := bytes.Repeat([]byte{0xFF}, 6)
+	packet = append(packet, bytes.Repeat(mac, 16)...)
+	return packet, nil
+}
+
+func parseMacAddress(macAddress string) (net.HardwareAddr, error) {
+	mac, err := net.ParseMAC(macAddress)
+	if err != nil {
+		return nil, err
+	}
+	if len(mac) != 6 {
+		return nil, fmt.Errorf("%s is not a 48-bit MAC address", macAddress)
+	}
+	return mac, nil
+}
+
+func (c *Command) wakeOnLan() (*InvocationResult, error) {
+	packet, err := MagicPacket(c.MacAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	address := net.JoinHostPort(c.BroadcastAddress, strconv.Itoa(c.Port))
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write(packet); err != nil {
+		return nil, err
+	}
+
+	return &InvocationResult{
+		Response: fmt.Sprintf("Magic packet for %s sent to %s", c.MacAddress, address),
+	}, nil
+}
@@ -0,0 +1,79 @@
+package command_test
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/soerenchrist/go_home/internal/command"
+	"github.com/soerenchrist/go_home/internal/device"
+)
+
+func TestMagicPacket_ShouldContainMacAddressSixteenTimes(t *testing.T) {
+	packet, err := command.MagicPacket("00:11:22:33:44:55")
+	if err != nil {
+		t.Fatalf("Error building magic packet: %s", err)
+	}
+
+	if len(packet) != 102 {
+		t.Fatalf("Expected packet length 102, got %d", len(packet))
+	}
+
+	if !bytes.Equal(packet[:6], []byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}) {
+		t.Errorf("Expected packet to start with synchronization stream, got %x", packet[:6])
+	}
+
+	mac := []byte{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}
+	for i := 0; i < 16; i++ {
+		offset := 6 + i*6
+		if !bytes.Equal(packet[offset:offset+6], mac) {
+			t.Errorf("Expected repetition %d to be %x, got %x", i, mac, packet[offset:offset+6])
+		}
+	}
+}
+
+func TestMagicPacket_ShouldReturnError_WhenMacAddressIsInvalid(t *testing.T) {
+	addresses := []string{"", "00:11:22", "not-a-mac", "00:00:00:00:fe:80:00:00:00:00:00:00:02:00:5e:10:00:00:00:01"}
+
+	for _, address := range addresses {
+		if _, err := command.MagicPacket(address); err == nil {
+			t.Errorf("Expected error for MAC address %s, got none", address)
+		}
+	}
+}
+
+func TestInvoke_ShouldSendMagicPacket_WhenCommandIsWol(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	defer conn.Close()
+
+	cmd := &command.Command{
+		Type:             command.CommandTypeWol,
+		MacAddress:       "aa-bb-cc-dd-ee-ff",
+		BroadcastAddress: "127.0.0.1",
+		Port:             conn.LocalAddr().(*net.UDPAddr).Port,
+	}
+
+	result, err := cmd.Invoke(&device.Device{ID: "nas"}, &command.CommandParameters{}, nil)
+	if err != nil {
+		t.Fatalf("Error invoking command: %s", err)
+	}
+	if result.Response == "" {
+		t.Errorf("Expected a response message")
+	}
+
+	buffer := make([]byte, 200)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := conn.ReadFrom(buffer)
+	if err != nil {
+		t.Fatalf("Failed to receive magic packet: %s", err)
+	}
+
+	expected, _ := command.MagicPacket("aa:bb:cc:dd:ee:ff")
+	if !bytes.Equal(buffer[:n], expected) {
+		t.Errorf("Received unexpected packet %x", buffer[:n])
+	}
+}
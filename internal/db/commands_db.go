@@ -1,6 +1,9 @@
 package db
 
 import (
+	"fmt"
+	"strings"
+
 	"github.com/soerenchrist/go_home/internal/command"
 	"github.com/soerenchrist/go_home/internal/errors"
 )
@@ -19,6 +22,14 @@ func (db *SqliteDevicesDatabase) ListCommands(deviceId string) ([]command.Comman
 
 func (db *SqliteDevicesDatabase) AddCommand(command *command.Command) error {
 	result := db.db.Create(command)
+	if result.Error != nil && strings.Contains(result.Error.Error(), "UNIQUE constraint failed") {
+		return &errors.ConflictError{Message: fmt.Sprintf("Command with id %s does already exist", command.ID)}
+	}
+	return result.Error
+}
+
+func (db *SqliteDevicesDatabase) UpdateCommand(command *command.Command) error {
+	result := db.db.Save(command)
 	return result.Error
 }
 
@@ -6,6 +6,7 @@ import (
 	"github.com/soerenchrist/go_home/internal/command"
 	"github.com/soerenchrist/go_home/internal/device"
 	"github.com/soerenchrist/go_home/internal/rules"
+	"github.com/soerenchrist/go_home/internal/scene"
 	"github.com/soerenchrist/go_home/internal/sensor"
 	"github.com/soerenchrist/go_home/internal/value"
 	"gorm.io/gorm"
@@ -13,22 +14,41 @@ import (
 
 type Database interface {
 	AddDevice(entity *device.Device) error
+	UpdateDevice(entity *device.Device) error
 	GetDevice(id string) (*device.Device, error)
 	DeleteDevice(id string) error
 	ListDevices() ([]device.Device, error)
 	ListSensors(deviceId string) ([]sensor.Sensor, error)
 	AddSensor(sensor *sensor.Sensor) error
+	UpdateSensor(sensor *sensor.Sensor) error
+	AddRejectedValue(deviceId, sensorId string) error
+	SetOutliers(deviceId, sensorId string, outliers int) error
 	GetSensor(deviceId, sensorId string) (*sensor.Sensor, error)
 	DeleteSensor(deviceId, sensorId string) error
 
 	ListPollingSensors() ([]sensor.Sensor, error)
+	ListComputedSensors() ([]sensor.Sensor, error)
+
+	GetSensorHealth(deviceId, sensorId string) (*sensor.SensorHealth, error)
+	SaveSensorHealth(health *sensor.SensorHealth) error
+	ListSensorHealth() ([]sensor.SensorHealth, error)
 
 	AddSensorValue(sensorValue *value.SensorValue) error
-	GetSensorValuesSince(deviceId, sensorId string, timestamp time.Time) ([]value.SensorValue, error)
+	AddSensorValues(values []*value.SensorValue) error
+	AddSensorValueBatch(values []*value.SensorValue, rejected []value.RejectedValues) error
+	QuerySensorValues(query *value.Query) ([]value.SensorValue, error)
+	AggregateSensorValues(query *value.Query) ([]value.Bucket, error)
+	SensorValueStats(query *value.Query, percentiles []float64) (*value.Stats, error)
+	RollupWatermark(tier value.Tier) (time.Time, error)
+	RollupSensorValues(tier value.Tier, from, to time.Time) error
+	RollupSensor(tier value.Tier, deviceId, sensorId string, from, to time.Time) error
+	DeleteRollupsBefore(tier value.Tier, before time.Time) (int64, error)
 	GetCurrentSensorValue(deviceId, sensorId string) (*value.SensorValue, error)
 	GetPreviousSensorValue(deviceId, sensorId string) (*value.SensorValue, error)
+	DeleteExpiredSensorValues(before time.Time, rolledUpBefore time.Time, limit int) (int64, error)
 
 	AddCommand(command *command.Command) error
+	UpdateCommand(command *command.Command) error
 	GetCommand(deviceId, commandId string) (*command.Command, error)
 	ListCommands(deviceId string) ([]command.Command, error)
 	DeleteCommand(deviceId, commandId string) error
@@ -36,6 +56,12 @@ type Database interface {
 	ListRules() ([]rules.Rule, error)
 	AddRule(rule *rules.Rule) error
 
+	ListScenes() ([]scene.Scene, error)
+	GetScene(sceneId string) (*scene.Scene, error)
+	AddScene(scene *scene.Scene) error
+	UpdateScene(scene *scene.Scene) error
+	DeleteScene(sceneId string) error
+
 	SeedDatabase()
 }
 
@@ -53,13 +79,13 @@ func NewDevicesDatabase(db *gorm.DB) (*SqliteDevicesDatabase, error) {
 }
 
 func (db *SqliteDevicesDatabase) createTables() error {
-	db.db.AutoMigrate(&command.Command{}, &device.Device{}, &sensor.Sensor{}, &value.SensorValue{}, &rules.Rule{})
-	return nil
+	db.db.AutoMigrate(&command.Command{}, &device.Device{}, &sensor.Sensor{}, &sensor.SensorHealth{}, &value.SensorValue{}, &rules.Rule{}, &scene.Scene{}, &scene.SceneStep{})
+	return db.createRollupTables()
 }
 
 func (database *SqliteDevicesDatabase) SeedDatabase() {
 	device1 := &device.Device{ID: "1", Name: "My Device 1"}
-	sensor1 := &sensor.Sensor{ID: "S1", Name: "Temperature", DeviceID: "1", DataType: sensor.DataTypeFloat, Type: sensor.SensorTypeExternal, IsActive: true, Unit: "Celsius", PollingInterval: 0, RetainmentPeriodSeconds: 3600}
+	sensor1 := &sensor.Sensor{ID: "S1", Name: "Temperature", DeviceID: "1", DataType: sensor.DataTypeFloat, Type: sensor.SensorTypeExternal, IsActive: true, Unit: "°C", PollingInterval: 0, RetainmentPeriodSeconds: 3600}
 	sensor2 := &sensor.Sensor{ID: "S2", Name: "Availability", DeviceID: "1", DataType: sensor.DataTypeBool, Type: sensor.SensorTypePolling, IsActive: true, Unit: "", PollingInterval: 30, PollingEndpoint: "localhost", PollingStrategy: "ping"}
 	template := `{"device": "{{.device_id}}", "command": "{{.command_id}}", "payload": "{{.p_payload}}"}`
 	command1 := &command.Command{ID: "C1", Name: "Turn on", DeviceID: "1", PayloadTemplate: template, Endpoint: "http://localhost:8080/echo", Method: "POST"}
@@ -98,10 +124,22 @@ func (database *SqliteDevicesDatabase) SeedDatabase() {
 	rule := &rules.Rule{
 		Name: "Turn on light when temperature is below 20",
 		When: rules.WhenExpression("when ${1.S1.current} < 20 AND ${1.S1.previous} >= 20"),
-		Then: rules.ThenExpression("then ${1.C1} {\"p_payload\": \"on\"}"),
+		Then: rules.ThenExpression("then ${1.C1} {\"payload\": \"on\"}"),
 	}
 
 	if err := database.AddRule(rule); err != nil {
 		panic(err)
 	}
+
+	scene1 := &scene.Scene{
+		ID:   "movie_night",
+		Name: "Movie night",
+		Steps: []scene.SceneStep{
+			{Position: 1, DeviceID: "1", CommandID: "C1", Params: command.CommandParameters{"payload": "on"}},
+		},
+	}
+
+	if err := database.AddScene(scene1); err != nil {
+		panic(err)
+	}
 }
@@ -3,6 +3,8 @@ package db
 import (
 	"github.com/soerenchrist/go_home/internal/device"
 	"github.com/soerenchrist/go_home/internal/errors"
+	"github.com/soerenchrist/go_home/internal/sensor"
+	"gorm.io/gorm"
 )
 
 func (db *SqliteDevicesDatabase) AddDevice(device *device.Device) error {
@@ -10,22 +12,34 @@ func (db *SqliteDevicesDatabase) AddDevice(device *device.Device) error {
 	return result.Error
 }
 
+func (db *SqliteDevicesDatabase) UpdateDevice(device *device.Device) error {
+	result := db.db.Save(device)
+	return result.Error
+}
+
 func (db *SqliteDevicesDatabase) GetDevice(id string) (*device.Device, error) {
 	device := device.Device{}
 	result := db.db.First(&device, "id = ?", id)
 	return &device, result.Error
 }
 
+// DeleteDevice deletes the device together with its sensors.
 func (db *SqliteDevicesDatabase) DeleteDevice(id string) error {
-	result := db.db.Delete(&device.Device{ID: id})
-	if result.Error != nil {
-		return result.Error
-	}
-
-	if result.RowsAffected == 0 {
-		return &errors.NotFoundError{Message: "Device not found"}
-	}
-	return nil
+	return db.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Delete(&device.Device{ID: id})
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			return &errors.NotFoundError{Message: "Device not found"}
+		}
+
+		if err := tx.Where("device_id = ?", id).Delete(&sensor.Sensor{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("device_id = ?", id).Delete(&sensor.SensorHealth{}).Error
+	})
 }
 
 func (db *SqliteDevicesDatabase) ListDevices() ([]device.Device, error) {
@@ -0,0 +1,133 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/soerenchrist/go_home/internal/value"
+	"gorm.io/gorm/clause"
+)
+
+// numericValue maps raw values of int, float and bool sensors to numbers.
+const numericValue = "CASE WHEN s.data_type = 'bool' THEN (CASE WHEN lower(v.value) IN ('true', 't', '1') THEN 1.0 ELSE 0.0 END) ELSE CAST(v.value AS REAL) END"
+
+func (db *SqliteDevicesDatabase) createRollupTables() error {
+	for _, tier := range value.Tiers {
+		if err := db.db.Table(tier.Table).AutoMigrate(&value.Rollup{}); err != nil {
+			return err
+		}
+		index := fmt.Sprintf("CREATE UNIQUE INDEX IF NOT EXISTS idx_%s_bucket ON %s (device_id, sensor_id, timestamp)", tier.Table, tier.Table)
+		if err := db.db.Exec(index).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RollupWatermark returns the end of the last rolled up bucket of the tier.
+// Without any rollups, it is the start of the bucket of the oldest value that
+// can be rolled up, or the zero time, if there are none.
+func (db *SqliteDevicesDatabase) RollupWatermark(tier value.Tier) (time.Time, error) {
+	step := int64(tier.Step / time.Second)
+
+	var last sql.NullInt64
+	if err := db.db.Table(tier.Table).Select("CAST(strftime('%s', MAX(timestamp)) AS INTEGER)").Scan(&last).Error; err != nil {
+		return time.Time{}, err
+	}
+	if last.Valid {
+		return time.Unix(last.Int64+step, 0).UTC(), nil
+	}
+
+	source := "sensor_values"
+	if finer := finerTier(tier); finer != nil {
+		source = finer.Table
+	}
+
+	var first sql.NullInt64
+	if err := db.db.Table(source).Select("CAST(strftime('%s', MIN(timestamp)) AS INTEGER)").Scan(&first).Error; err != nil {
+		return time.Time{}, err
+	}
+	if !first.Valid {
+		return time.Time{}, nil
+	}
+	return time.Unix(first.Int64/step*step, 0).UTC(), nil
+}
+
+// RollupSensorValues summarizes all values in [from, to) into the buckets of
+// the tier and replaces existing buckets. The hourly tier is rolled up from the
+// raw values of numeric and bool sensors, coarser tiers from the next finer tier.
+func (db *SqliteDevicesDatabase) RollupSensorValues(tier value.Tier, from, to time.Time) error {
+	return db.rollup(tier, from, to, "1 = 1")
+}
+
+// RollupSensor summarizes the values of a single sensor like RollupSensorValues.
+func (db *SqliteDevicesDatabase) RollupSensor(tier value.Tier, deviceId, sensorId string, from, to time.Time) error {
+	return db.rollup(tier, from, to, "v.device_id = ? AND v.sensor_id = ?", deviceId, sensorId)
+}
+
+func (db *SqliteDevicesDatabase) rollup(tier value.Tier, from, to time.Time, filter string, args ...any) error {
+	step := int64(tier.Step / time.Second)
+	bucket := fmt.Sprintf("(CAST(strftime('%%s', v.timestamp) AS INTEGER) / %d) * %d", step, step)
+
+	var query string
+	if finer := finerTier(tier); finer != nil {
+		query = fmt.Sprintf(`SELECT v.device_id, v.sensor_id, %s AS bucket, MIN(v.min) AS min, MAX(v.max) AS max, SUM(v.sum) AS sum, SUM(v.count) AS count
+			FROM %s v WHERE v.timestamp >= ? AND v.timestamp < ? AND %s
+			GROUP BY v.device_id, v.sensor_id, bucket`, bucket, finer.Table, filter)
+	} else {
+		query = fmt.Sprintf(`SELECT v.device_id, v.sensor_id, %s AS bucket, MIN(%s) AS min, MAX(%s) AS max, SUM(%s) AS sum, COUNT(*) AS count
+			FROM sensor_values v JOIN sensors s ON s.device_id = v.device_id AND s.id = v.sensor_id
+			WHERE s.data_type IN ('int', 'float', 'bool') AND v.timestamp >= ? AND v.timestamp < ? AND %s
+			GROUP BY v.device_id, v.sensor_id, bucket`, bucket, numericValue, numericValue, numericValue, filter)
+	}
+
+	rows := make([]struct {
+		DeviceID string
+		SensorID string
+		Bucket   int64
+		Min      float64
+		Max      float64
+		Sum      float64
+		Count    int
+	}, 0)
+	if err := db.db.Raw(query, append([]any{from.UTC(), to.UTC()}, args...)...).Scan(&rows).Error; err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+
+	rollups := make([]value.Rollup, len(rows))
+	for i, row := range rows {
+		rollups[i] = value.Rollup{
+			DeviceID:  row.DeviceID,
+			SensorID:  row.SensorID,
+			Timestamp: time.Unix(row.Bucket, 0).UTC(),
+			Min:       row.Min,
+			Max:       row.Max,
+			Sum:       row.Sum,
+			Count:     row.Count,
+		}
+	}
+
+	return db.db.Table(tier.Table).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "device_id"}, {Name: "sensor_id"}, {Name: "timestamp"}},
+		DoUpdates: clause.AssignmentColumns([]string{"min", "max", "sum", "count"}),
+	}).CreateInBatches(rollups, 500).Error
+}
+
+// DeleteRollupsBefore deletes all buckets of the tier, that start before the given time.
+func (db *SqliteDevicesDatabase) DeleteRollupsBefore(tier value.Tier, before time.Time) (int64, error) {
+	result := db.db.Table(tier.Table).Where("timestamp < ?", before.UTC()).Delete(&value.Rollup{})
+	return result.RowsAffected, result.Error
+}
+
+func finerTier(tier value.Tier) *value.Tier {
+	for i := range value.Tiers {
+		if value.Tiers[i].Name == tier.Name && i+1 < len(value.Tiers) {
+			return &value.Tiers[i+1]
+		}
+	}
+	return nil
+}
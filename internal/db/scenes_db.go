@@ -0,0 +1,55 @@
+package db
+
+import (
+	"github.com/soerenchrist/go_home/internal/errors"
+	"github.com/soerenchrist/go_home/internal/scene"
+	"gorm.io/gorm"
+)
+
+func (db *SqliteDevicesDatabase) ListScenes() ([]scene.Scene, error) {
+	scenes := make([]scene.Scene, 0)
+	result := db.db.Preload("Steps", orderSteps).Find(&scenes)
+	return scenes, result.Error
+}
+
+func (db *SqliteDevicesDatabase) GetScene(sceneId string) (*scene.Scene, error) {
+	s := scene.Scene{}
+	result := db.db.Preload("Steps", orderSteps).First(&s, "id = ?", sceneId)
+	return &s, result.Error
+}
+
+func (db *SqliteDevicesDatabase) AddScene(s *scene.Scene) error {
+	result := db.db.Create(s)
+	return result.Error
+}
+
+func (db *SqliteDevicesDatabase) UpdateScene(s *scene.Scene) error {
+	return db.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("scene_id = ?", s.ID).Delete(&scene.SceneStep{}).Error; err != nil {
+			return err
+		}
+		return tx.Session(&gorm.Session{FullSaveAssociations: true}).Save(s).Error
+	})
+}
+
+func (db *SqliteDevicesDatabase) DeleteScene(sceneId string) error {
+	return db.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("scene_id = ?", sceneId).Delete(&scene.SceneStep{}).Error; err != nil {
+			return err
+		}
+
+		result := tx.Delete(&scene.Scene{ID: sceneId})
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			return &errors.NotFoundError{Message: "Scene not found"}
+		}
+		return nil
+	})
+}
+
+func orderSteps(db *gorm.DB) *gorm.DB {
+	return db.Order("position")
+}
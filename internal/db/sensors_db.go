@@ -3,19 +3,43 @@ package db
 import (
 	"github.com/soerenchrist/go_home/internal/errors"
 	"github.com/soerenchrist/go_home/internal/sensor"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 func (db *SqliteDevicesDatabase) GetSensor(deviceId, sensorId string) (*sensor.Sensor, error) {
-	sensor := sensor.Sensor{}
-	result := db.db.Where("id = ? and device_id = ?", sensorId, deviceId).First(&sensor)
+	s := sensor.Sensor{}
+	result := db.db.Where("id = ? and device_id = ?", sensorId, deviceId).First(&s)
+	if result.Error != nil {
+		return &s, result.Error
+	}
 
-	return &sensor, result.Error
+	health, err := db.GetSensorHealth(deviceId, sensorId)
+	if err == nil {
+		s.Health = health
+	}
+	return &s, nil
 }
 
 func (db *SqliteDevicesDatabase) ListSensors(deviceId string) ([]sensor.Sensor, error) {
 	sensors := make([]sensor.Sensor, 0)
 	result := db.db.Where("device_id = ?", deviceId).Find(&sensors)
-	return sensors, result.Error
+	if result.Error != nil {
+		return sensors, result.Error
+	}
+
+	healths := make([]sensor.SensorHealth, 0)
+	if err := db.db.Where("device_id = ?", deviceId).Find(&healths).Error; err != nil {
+		return sensors, err
+	}
+	for i := range healths {
+		for j := range sensors {
+			if sensors[j].ID == healths[i].SensorID {
+				sensors[j].Health = &healths[i]
+			}
+		}
+	}
+	return sensors, nil
 }
 
 func (db *SqliteDevicesDatabase) ListPollingSensors() ([]sensor.Sensor, error) {
@@ -24,11 +48,32 @@ func (db *SqliteDevicesDatabase) ListPollingSensors() ([]sensor.Sensor, error) {
 	return sensors, result.Error
 }
 
+func (db *SqliteDevicesDatabase) ListComputedSensors() ([]sensor.Sensor, error) {
+	sensors := make([]sensor.Sensor, 0)
+	result := db.db.Where("type = 'computed'").Find(&sensors)
+	return sensors, result.Error
+}
+
 func (db *SqliteDevicesDatabase) AddSensor(sensor *sensor.Sensor) error {
 	result := db.db.Create(sensor)
 	return result.Error
 }
 
+func (db *SqliteDevicesDatabase) UpdateSensor(sensor *sensor.Sensor) error {
+	result := db.db.Save(sensor)
+	return result.Error
+}
+
+func (db *SqliteDevicesDatabase) AddRejectedValue(deviceId, sensorId string) error {
+	result := db.db.Model(&sensor.Sensor{}).Where("id = ? and device_id = ?", sensorId, deviceId).UpdateColumn("rejected_values", gorm.Expr("rejected_values + 1"))
+	return result.Error
+}
+
+func (db *SqliteDevicesDatabase) SetOutliers(deviceId, sensorId string, outliers int) error {
+	result := db.db.Model(&sensor.Sensor{}).Where("id = ? and device_id = ?", sensorId, deviceId).UpdateColumn("outliers", outliers)
+	return result.Error
+}
+
 func (db *SqliteDevicesDatabase) DeleteSensor(deviceId, sensorId string) error {
 	result := db.db.Where("id = ? and device_id = ?", sensorId, deviceId).Delete(&sensor.Sensor{})
 	if result.Error != nil {
@@ -38,5 +83,30 @@ func (db *SqliteDevicesDatabase) DeleteSensor(deviceId, sensorId string) error {
 	if result.RowsAffected == 0 {
 		return &errors.NotFoundError{Message: "Sensor not found"}
 	}
-	return nil
+
+	return db.db.Where("sensor_id = ? and device_id = ?", sensorId, deviceId).Delete(&sensor.SensorHealth{}).Error
+}
+
+func (db *SqliteDevicesDatabase) GetSensorHealth(deviceId, sensorId string) (*sensor.SensorHealth, error) {
+	health := sensor.SensorHealth{}
+	result := db.db.Where("sensor_id = ? and device_id = ?", sensorId, deviceId).Limit(1).Find(&health)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return nil, &errors.NotFoundError{Message: "Sensor health not found"}
+	}
+	return &health, nil
+}
+
+func (db *SqliteDevicesDatabase) SaveSensorHealth(health *sensor.SensorHealth) error {
+	result := db.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(health)
+	return result.Error
+}
+
+func (db *SqliteDevicesDatabase) ListSensorHealth() ([]sensor.SensorHealth, error) {
+	healths := make([]sensor.SensorHealth, 0)
+	result := db.db.Order("device_id, sensor_id").Find(&healths)
+	return healths, result.Error
 }
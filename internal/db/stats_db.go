@@ -0,0 +1,137 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/soerenchrist/go_home/internal/value"
+)
+
+// SensorValueStats summarizes the values of a sensor in the range of the query.
+// Percentiles use the nearest rank of the sorted values.
+func (db *SqliteDevicesDatabase) SensorValueStats(query *value.Query, percentiles []float64) (*value.Stats, error) {
+	where := "device_id = ? AND sensor_id = ? AND timestamp >= ? AND timestamp < ?"
+	args := []any{query.DeviceID, query.SensorID, query.From.UTC(), query.To.UTC()}
+
+	numeric := "CAST(value AS REAL)"
+	if query.Bool {
+		numeric = "CASE WHEN lower(value) IN ('true', 't', '1') THEN 1.0 ELSE 0.0 END"
+	}
+
+	var summary struct {
+		Count int
+		Min   sql.NullFloat64
+		Max   sql.NullFloat64
+		Mean  sql.NullFloat64
+	}
+	selection := "COUNT(*) AS count"
+	if query.Numeric || query.Bool {
+		selection += fmt.Sprintf(", MIN(%s) AS min, MAX(%s) AS max, AVG(%s) AS mean", numeric, numeric, numeric)
+	}
+	if err := db.db.Model(&value.SensorValue{}).Select(selection).Where(where, args...).Scan(&summary).Error; err != nil {
+		return nil, err
+	}
+
+	stats := &value.Stats{Count: summary.Count}
+	// The previous value gives the state of a bool sensor, even without values in the range
+	if query.Bool {
+		if err := db.boolStats(stats, numeric, where, args, query); err != nil {
+			return nil, err
+		}
+	}
+	if stats.Count == 0 {
+		return stats, nil
+	}
+
+	var first, last value.SensorValue
+	if err := db.db.Where(where, args...).Order("timestamp asc, id asc").First(&first).Error; err != nil {
+		return nil, err
+	}
+	if err := db.db.Where(where, args...).Order("timestamp desc, id desc").First(&last).Error; err != nil {
+		return nil, err
+	}
+	stats.First = &first
+	stats.Last = &last
+
+	if !summary.Mean.Valid {
+		return stats, nil
+	}
+	stats.Min = &summary.Min.Float64
+	stats.Max = &summary.Max.Float64
+	stats.Mean = &summary.Mean.Float64
+
+	// The variance is computed around the mean, which is more stable than E[x²] - E[x]²
+	var variance sql.NullFloat64
+	squares := fmt.Sprintf("AVG((%s - ?) * (%s - ?))", numeric, numeric)
+	if err := db.db.Model(&value.SensorValue{}).Select(squares, summary.Mean.Float64, summary.Mean.Float64).Where(where, args...).Scan(&variance).Error; err != nil {
+		return nil, err
+	}
+	stddev := math.Sqrt(variance.Float64)
+	stats.Stddev = &stddev
+
+	stats.Percentiles = make(map[string]float64)
+	for _, p := range percentiles {
+		rank := int(math.Ceil(p / 100 * float64(stats.Count)))
+		if rank < 1 {
+			rank = 1
+		}
+
+		var percentile float64
+		result := db.db.Model(&value.SensorValue{}).Select(numeric+" AS n").Where(where, args...).Order("n asc").Offset(rank - 1).Limit(1).Scan(&percentile)
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		stats.Percentiles[value.PercentileKey(p)] = percentile
+	}
+
+	return stats, nil
+}
+
+// boolStats counts the changes of a bool sensor and weights each value with
+// the time until the next value or the end of the range. The range starts with
+// the last value before it, as the sensor keeps its state until it changes, and
+// ends at now at the latest.
+func (db *SqliteDevicesDatabase) boolStats(stats *value.Stats, numeric string, where string, args []any, query *value.Query) error {
+	var result struct {
+		Transitions  int
+		TrueFraction sql.NullFloat64
+	}
+
+	from := query.From.UTC()
+	end := query.To.UTC()
+	if now := time.Now().UTC(); now.Before(end) {
+		end = now
+	}
+
+	statement := fmt.Sprintf(`SELECT COALESCE(SUM(CASE WHEN previous IS NOT NULL AND b != previous THEN 1 ELSE 0 END), 0) AS transitions,
+			SUM(b * duration) / SUM(duration) AS true_fraction
+		FROM (
+			SELECT b, LAG(b) OVER w AS previous,
+				MAX(0, MIN(julianday(COALESCE(LEAD(timestamp) OVER w, ?)), julianday(?)) - julianday(timestamp)) AS duration
+			FROM (
+				SELECT b, ? AS timestamp, 0 AS id FROM (
+					SELECT %s AS b FROM sensor_values
+					WHERE device_id = ? AND sensor_id = ? AND timestamp < ?
+					ORDER BY timestamp DESC, id DESC LIMIT 1
+				)
+				UNION ALL
+				SELECT %s AS b, timestamp, id FROM sensor_values WHERE %s
+			)
+			WINDOW w AS (ORDER BY timestamp, id)
+		)`, numeric, numeric, where)
+
+	statementArgs := append([]any{end, end, from, query.DeviceID, query.SensorID, from}, args...)
+	if err := db.db.Raw(statement, statementArgs...).Scan(&result).Error; err != nil {
+		return err
+	}
+
+	if result.TrueFraction.Valid {
+		stats.TrueFraction = &result.TrueFraction.Float64
+	}
+	if stats.Count > 0 || stats.TrueFraction != nil {
+		stats.Transitions = &result.Transitions
+	}
+	return nil
+}
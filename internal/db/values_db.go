@@ -1,27 +1,80 @@
 package db
 
 import (
+	"database/sql"
 	"fmt"
+	"strconv"
 	"time"
 
+	"github.com/soerenchrist/go_home/internal/sensor"
 	"github.com/soerenchrist/go_home/internal/value"
+	"gorm.io/gorm"
 )
 
 func (db *SqliteDevicesDatabase) AddSensorValue(data *value.SensorValue) error {
+	toUTC(data)
 	result := db.db.Create(data)
 	return result.Error
 }
 
+// AddSensorValues inserts all values in a single transaction.
+func (db *SqliteDevicesDatabase) AddSensorValues(values []*value.SensorValue) error {
+	if len(values) == 0 {
+		return nil
+	}
+
+	for _, v := range values {
+		toUTC(v)
+	}
+	return db.db.Transaction(func(tx *gorm.DB) error {
+		return tx.CreateInBatches(values, 500).Error
+	})
+}
+
+// AddSensorValueBatch inserts the values and counts the rejected values of the
+// sensors in a single transaction. The outliers of the sensors are updated as well.
+func (db *SqliteDevicesDatabase) AddSensorValueBatch(values []*value.SensorValue, rejected []value.RejectedValues) error {
+	for _, v := range values {
+		toUTC(v)
+	}
+	return db.db.Transaction(func(tx *gorm.DB) error {
+		if len(values) > 0 {
+			if err := tx.CreateInBatches(values, 500).Error; err != nil {
+				return err
+			}
+		}
+
+		for _, r := range rejected {
+			result := tx.Model(&sensor.Sensor{}).Where("id = ? and device_id = ?", r.SensorID, r.DeviceID).UpdateColumns(map[string]any{
+				"rejected_values": gorm.Expr("rejected_values + ?", r.Count),
+				"outliers":        r.Outliers,
+			})
+			if result.Error != nil {
+				return result.Error
+			}
+		}
+		return nil
+	})
+}
+
+// toUTC normalizes the times of a value, as sqlite compares them as text.
+func toUTC(v *value.SensorValue) {
+	v.Timestamp = v.Timestamp.UTC()
+	if v.ExpiresAt.Valid {
+		v.ExpiresAt.Time = v.ExpiresAt.Time.UTC()
+	}
+}
+
 func (db *SqliteDevicesDatabase) GetCurrentSensorValue(deviceId, sensorId string) (*value.SensorValue, error) {
 	sensorVal := value.SensorValue{}
-	result := db.db.Where("sensor_id = ? and device_id = ?", sensorId, deviceId).Order("timestamp desc").First(&sensorVal)
+	result := db.db.Where("sensor_id = ? and device_id = ?", sensorId, deviceId).Order("timestamp desc, id desc").First(&sensorVal)
 
 	return &sensorVal, result.Error
 }
 
 func (db *SqliteDevicesDatabase) GetPreviousSensorValue(deviceId, sensorId string) (*value.SensorValue, error) {
 	sensorVals := make([]value.SensorValue, 0)
-	result := db.db.Where("sensor_id = ? and device_id = ?", sensorId, deviceId).Order("timestamp desc").Limit(2).Find(&sensorVals)
+	result := db.db.Where("sensor_id = ? and device_id = ?", sensorId, deviceId).Order("timestamp desc, id desc").Limit(2).Find(&sensorVals)
 
 	if len(sensorVals) != 2 {
 		return nil, fmt.Errorf("no previous value found for sensor")
@@ -32,9 +85,165 @@ func (db *SqliteDevicesDatabase) GetPreviousSensorValue(deviceId, sensorId strin
 	return &sensorVal, result.Error
 }
 
-func (db *SqliteDevicesDatabase) GetSensorValuesSince(deviceId, sensorId string, timestamp time.Time) ([]value.SensorValue, error) {
-
+// QuerySensorValues returns the raw values of a sensor in the range of the query,
+// ordered by timestamp and continuing after the cursor of the query. Times are
+// compared in UTC, as the timestamps are stored as text in UTC.
+func (db *SqliteDevicesDatabase) QuerySensorValues(query *value.Query) ([]value.SensorValue, error) {
 	values := make([]value.SensorValue, 0)
-	result := db.db.Where("device_id = ? AND sensor_id = ? AND timestamp > ?", deviceId, sensorId, timestamp).Find(&values)
+	tx := db.db.Where("device_id = ? AND sensor_id = ? AND timestamp >= ? AND timestamp < ?", query.DeviceID, query.SensorID, query.From.UTC(), query.To.UTC())
+	if query.After != nil {
+		after := query.After.Timestamp.UTC()
+		tx = tx.Where("(timestamp > ? OR (timestamp = ? AND id > ?))", after, after, query.After.ID)
+	}
+	if query.Limit > 0 {
+		tx = tx.Limit(query.Limit)
+	}
+
+	result := tx.Order("timestamp asc, id asc").Find(&values)
 	return values, result.Error
 }
+
+type bucketRow struct {
+	Bucket int64
+	Count  int
+	Number sql.NullFloat64
+	Value  string
+}
+
+// AggregateSensorValues groups the values of a sensor into buckets of the step
+// of the query and aggregates each bucket in the database. Empty buckets are omitted.
+// If the query has a tier, all buckets that are already rolled up are read from
+// the tier and only the remaining ones from the raw values. Buckets, that are
+// cut by the range of the query, are always read from the raw values, as the
+// tier would include values outside of the range.
+func (db *SqliteDevicesDatabase) AggregateSensorValues(query *value.Query) ([]value.Bucket, error) {
+	step := int64(query.Step / time.Second)
+	from := query.From
+	rows := make([]bucketRow, 0)
+
+	if query.Tier != nil {
+		watermark, err := db.RollupWatermark(*query.Tier)
+		if err != nil {
+			return nil, err
+		}
+		// The step is a multiple of the tier step, so the tier is read in whole buckets
+		tierFrom := time.Unix(from.Unix()/step*step, 0)
+		if tierFrom.Before(from) {
+			tierFrom = tierFrom.Add(query.Step)
+		}
+		split := time.Unix(watermark.Unix()/step*step, 0)
+		if to := time.Unix(query.To.Unix()/step*step, 0); split.After(to) {
+			split = to
+		}
+
+		if split.After(tierFrom) {
+			if from.Before(tierFrom) {
+				rawRows, err := db.aggregateRawValues(query, from, tierFrom)
+				if err != nil {
+					return nil, err
+				}
+				rows = append(rows, rawRows...)
+			}
+
+			tierRows, err := db.aggregateRollups(query, tierFrom, split)
+			if err != nil {
+				return nil, err
+			}
+			rows = append(rows, tierRows...)
+			from = split
+		}
+	}
+
+	if from.Before(query.To) {
+		rawRows, err := db.aggregateRawValues(query, from, query.To)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, rawRows...)
+	}
+
+	buckets := make([]value.Bucket, len(rows))
+	for i, row := range rows {
+		buckets[i] = value.Bucket{
+			Timestamp: time.Unix(row.Bucket, 0).UTC(),
+			Value:     row.Value,
+			Count:     row.Count,
+		}
+		if query.Aggregation == value.AggregationCount {
+			buckets[i].Value = strconv.Itoa(row.Count)
+		} else if row.Number.Valid {
+			buckets[i].Value = strconv.FormatFloat(row.Number.Float64, 'f', -1, 64)
+		}
+	}
+	return buckets, nil
+}
+
+func (db *SqliteDevicesDatabase) aggregateRawValues(query *value.Query, from, to time.Time) ([]bucketRow, error) {
+	step := int64(query.Step / time.Second)
+
+	numeric := "CAST(value AS REAL)"
+	if query.Bool {
+		numeric = "CASE WHEN lower(value) IN ('true', 't', '1') THEN 1.0 ELSE 0.0 END"
+	}
+
+	// count is always selected, so count and last need no further aggregate
+	aggregate := "NULL AS number"
+	switch query.Aggregation {
+	case value.AggregationAvg, value.AggregationMin, value.AggregationMax, value.AggregationSum:
+		aggregate = fmt.Sprintf("%s(%s) AS number", query.Aggregation, numeric)
+	case value.AggregationLast:
+		// sqlite takes bare columns from the row matching max()
+		aggregate = "value, MAX(timestamp) AS last_timestamp"
+	case value.AggregationCount:
+	default:
+		return nil, fmt.Errorf("unsupported aggregation %s", query.Aggregation)
+	}
+
+	rows := make([]bucketRow, 0)
+	result := db.db.Model(&value.SensorValue{}).
+		Select(fmt.Sprintf("(CAST(strftime('%%s', timestamp) AS INTEGER) / %d) * %d AS bucket, COUNT(*) AS count, %s", step, step, aggregate)).
+		Where("device_id = ? AND sensor_id = ? AND timestamp >= ? AND timestamp < ?", query.DeviceID, query.SensorID, from.UTC(), to.UTC()).
+		Group("bucket").
+		Order("bucket asc").
+		Scan(&rows)
+	return rows, result.Error
+}
+
+func (db *SqliteDevicesDatabase) aggregateRollups(query *value.Query, from, to time.Time) ([]bucketRow, error) {
+	step := int64(query.Step / time.Second)
+
+	aggregate := "NULL AS number"
+	switch query.Aggregation {
+	case value.AggregationAvg:
+		aggregate = "SUM(sum) / SUM(count) AS number"
+	case value.AggregationMin:
+		aggregate = "MIN(min) AS number"
+	case value.AggregationMax:
+		aggregate = "MAX(max) AS number"
+	case value.AggregationSum:
+		aggregate = "SUM(sum) AS number"
+	case value.AggregationCount:
+	default:
+		return nil, fmt.Errorf("aggregation %s cannot be served from rollups", query.Aggregation)
+	}
+
+	rows := make([]bucketRow, 0)
+	result := db.db.Table(query.Tier.Table).
+		Select(fmt.Sprintf("(CAST(strftime('%%s', timestamp) AS INTEGER) / %d) * %d AS bucket, SUM(count) AS count, %s", step, step, aggregate)).
+		Where("device_id = ? AND sensor_id = ? AND timestamp >= ? AND timestamp < ?", query.DeviceID, query.SensorID, from.UTC(), to.UTC()).
+		Group("bucket").
+		Order("bucket asc").
+		Scan(&rows)
+	return rows, result.Error
+}
+
+// DeleteExpiredSensorValues deletes up to limit values, that expired before the given time.
+// Values of numeric and bool sensors are only deleted, once they are rolled up,
+// i.e. when they are older than rolledUpBefore.
+func (db *SqliteDevicesDatabase) DeleteExpiredSensorValues(before time.Time, rolledUpBefore time.Time, limit int) (int64, error) {
+	result := db.db.Exec(`DELETE FROM sensor_values WHERE id IN (
+			SELECT v.id FROM sensor_values v LEFT JOIN sensors s ON s.device_id = v.device_id AND s.id = v.sensor_id
+			WHERE v.expires_at < ? AND (v.timestamp < ? OR s.data_type IS NULL OR s.data_type NOT IN ('int', 'float', 'bool'))
+			LIMIT ?)`, before.UTC(), rolledUpBefore.UTC(), limit)
+	return result.RowsAffected, result.Error
+}
@@ -11,15 +11,32 @@ type DevicesDatabase interface {
 	ListDevices() ([]Device, error)
 	GetDevice(deviceId string) (*Device, error)
 	AddDevice(device *Device) error
+	UpdateDevice(device *Device) error
 	DeleteDevice(deviceId string) error
 }
 
+// ChangeListener is notified after devices have been changed through the API.
+type ChangeListener interface {
+	// DeviceDeleted is called after the device and its sensors have been deleted.
+	DeviceDeleted(deviceId string)
+}
+
+// ChangeListeners forwards changes to all of its listeners.
+type ChangeListeners []ChangeListener
+
+func (l ChangeListeners) DeviceDeleted(deviceId string) {
+	for _, listener := range l {
+		listener.DeviceDeleted(deviceId)
+	}
+}
+
 type DevicesController struct {
 	database DevicesDatabase
+	listener ChangeListener
 }
 
-func NewController(database DevicesDatabase) *DevicesController {
-	return &DevicesController{database: database}
+func NewController(database DevicesDatabase, listener ChangeListener) *DevicesController {
+	return &DevicesController{database: database, listener: listener}
 }
 
 func (c *DevicesController) GetDevices(context *gin.Context) {
@@ -73,6 +90,35 @@ func (c *DevicesController) GetDevice(context *gin.Context) {
 	context.JSON(200, device)
 }
 
+func (c *DevicesController) PutDevice(context *gin.Context) {
+	id := context.Param("deviceId")
+
+	device, err := c.database.GetDevice(id)
+	if err != nil {
+		context.JSON(404, gin.H{"error": "Device not found"})
+		return
+	}
+
+	var request CreateDeviceRequest
+	if err := context.BindJSON(&request); err != nil {
+		context.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := c.validateDevice(request); err != nil {
+		context.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	device.Name = request.Name
+
+	if err := c.database.UpdateDevice(device); err != nil {
+		context.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	context.JSON(200, device)
+}
+
 func (c *DevicesController) DeleteDevice(context *gin.Context) {
 	id := context.Param("deviceId")
 
@@ -87,6 +133,10 @@ func (c *DevicesController) DeleteDevice(context *gin.Context) {
 		context.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
+
+	if c.listener != nil {
+		c.listener.DeviceDeleted(id)
+	}
 	context.Status(204)
 }
 
@@ -15,3 +15,20 @@ type NotFoundError struct {
 func (e *NotFoundError) Error() string {
 	return e.Message
 }
+
+type ConflictError struct {
+	Message string
+}
+
+func (e *ConflictError) Error() string {
+	return e.Message
+}
+
+// RejectedError reports a sensor value that was dropped by the ingestion pipeline.
+type RejectedError struct {
+	Message string
+}
+
+func (e *RejectedError) Error() string {
+	return e.Message
+}
@@ -0,0 +1,220 @@
+package influx
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"github.com/soerenchrist/go_home/internal/device"
+	"github.com/soerenchrist/go_home/internal/sensor"
+	"github.com/soerenchrist/go_home/internal/value"
+	"github.com/soerenchrist/go_home/pkg/lineprotocol"
+)
+
+type Database interface {
+	GetDevice(deviceId string) (*device.Device, error)
+	AddDevice(device *device.Device) error
+	GetSensor(deviceId string, sensorId string) (*sensor.Sensor, error)
+	AddSensor(sensor *sensor.Sensor) error
+}
+
+// Controller accepts points in the InfluxDB line protocol, so that tools
+// like Telegraf can write to go_home directly. Every field of a point is
+// stored as a value of the sensor it is mapped to.
+type Controller struct {
+	database Database
+	values   *value.SensorValuesController
+	config   Config
+}
+
+func NewController(database Database, values *value.SensorValuesController, config Config) *Controller {
+	return &Controller{database: database, values: values, config: config}
+}
+
+// validId matches the ids of devices and sensors, that can be used in urls and
+// expressions of computed sensors.
+var validId = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// minNameLength is the minimal length of device and sensor names, as required
+// by the devices and sensors endpoints.
+const minNameLength = 3
+
+type invalidField struct {
+	Measurement string `json:"measurement"`
+	Field       string `json:"field"`
+	Error       string `json:"error"`
+}
+
+func (c *Controller) Write(context *gin.Context) {
+	precision, err := parsePrecision(context.DefaultQuery("precision", "ns"))
+	if err != nil {
+		context.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	points, err := lineprotocol.Parse(context.Request.Body, precision)
+	if err != nil {
+		context.JSON(400, gin.H{"error": fmt.Sprintf("Invalid line protocol: %s", err.Error())})
+		return
+	}
+
+	requests := make([]value.BatchSensorValueRequest, 0)
+	unmapped := make([]invalidField, 0)
+	known := make(map[string]bool)
+	for _, point := range points {
+		for _, field := range point.Fields {
+			deviceId, sensorId, err := c.mapField(&point, &field, known)
+			if err != nil {
+				unmapped = append(unmapped, invalidField{Measurement: point.Measurement, Field: field.Key, Error: err.Error()})
+				continue
+			}
+
+			request := value.BatchSensorValueRequest{DeviceID: deviceId, SensorID: sensorId, Value: formatValue(field.Value)}
+			if !point.Timestamp.IsZero() {
+				request.Timestamp = point.Timestamp.Format(time.RFC3339Nano)
+			}
+			requests = append(requests, request)
+		}
+	}
+
+	response, err := c.values.AddSensorValues(requests)
+	if err != nil {
+		context.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	if len(unmapped) > 0 || response.Invalid > 0 {
+		context.JSON(400, gin.H{
+			"error":    fmt.Sprintf("Partial write: %d of %d fields could not be stored", len(unmapped)+response.Invalid, len(unmapped)+len(requests)),
+			"unmapped": unmapped,
+			"results":  response.Results,
+		})
+		return
+	}
+
+	context.Status(204)
+}
+
+// mapField resolves the sensor of a field. Devices and sensors, that are known
+// to exist, are remembered in known, so that they are looked up once per write.
+func (c *Controller) mapField(point *lineprotocol.Point, field *lineprotocol.Field, known map[string]bool) (string, string, error) {
+	mapping := DefaultMapping
+	for _, m := range c.config.Mappings {
+		if m.matches(point.Measurement, field.Key, point.Tags) {
+			mapping = m
+			break
+		}
+	}
+
+	deviceId, sensorId, err := mapping.resolve(point.Measurement, field.Key, point.Tags)
+	if err != nil {
+		return "", "", err
+	}
+
+	if !validId.MatchString(deviceId) {
+		return "", "", fmt.Errorf("invalid device id %q", deviceId)
+	}
+	if !validId.MatchString(sensorId) {
+		return "", "", fmt.Errorf("invalid sensor id %q", sensorId)
+	}
+
+	if c.config.AutoCreate {
+		if err := c.ensureSensor(deviceId, sensorId, point, field, known); err != nil {
+			return "", "", err
+		}
+	}
+	return deviceId, sensorId, nil
+}
+
+func (c *Controller) ensureSensor(deviceId string, sensorId string, point *lineprotocol.Point, field *lineprotocol.Field, known map[string]bool) error {
+	sensorKey := deviceId + "." + sensorId
+	if known[sensorKey] {
+		return nil
+	}
+
+	if !known[deviceId] {
+		if _, err := c.database.GetDevice(deviceId); err != nil {
+			if err := c.database.AddDevice(&device.Device{ID: deviceId, Name: displayName(deviceId, "Device")}); err != nil {
+				return err
+			}
+			log.Info().Str("device_id", deviceId).Msg("Created device for line protocol write")
+		}
+		known[deviceId] = true
+	}
+
+	if _, err := c.database.GetSensor(deviceId, sensorId); err == nil {
+		known[sensorKey] = true
+		return nil
+	}
+
+	s := &sensor.Sensor{
+		ID:                      sensorId,
+		DeviceID:                deviceId,
+		Name:                    displayName(strings.TrimSpace(point.Measurement+" "+field.Key), "Sensor"),
+		DataType:                dataType(field.Value),
+		Type:                    sensor.SensorTypeExternal,
+		IsActive:                true,
+		RetainmentPeriodSeconds: -1,
+	}
+	if err := c.database.AddSensor(s); err != nil {
+		return err
+	}
+	log.Info().Str("device_id", deviceId).Str("sensor_id", sensorId).Msg("Created sensor for line protocol write")
+	known[sensorKey] = true
+	return nil
+}
+
+// displayName prefixes names, that are too short for the devices and sensors endpoints, e.g. `Device 1`.
+func displayName(name string, prefix string) string {
+	if len(name) < minNameLength {
+		return prefix + " " + name
+	}
+	return name
+}
+
+func dataType(v any) sensor.DataType {
+	switch v.(type) {
+	case float64:
+		return sensor.DataTypeFloat
+	case int64, uint64:
+		return sensor.DataTypeInt
+	case bool:
+		return sensor.DataTypeBool
+	default:
+		return sensor.DataTypeString
+	}
+}
+
+func formatValue(v any) string {
+	switch v := v.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case uint64:
+		return strconv.FormatUint(v, 10)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func parsePrecision(precision string) (time.Duration, error) {
+	switch precision {
+	case "ns", "n":
+		return time.Nanosecond, nil
+	case "us", "u":
+		return time.Microsecond, nil
+	case "ms":
+		return time.Millisecond, nil
+	case "s":
+		return time.Second, nil
+	default:
+		return 0, fmt.Errorf("Invalid precision %s - Should be one of ns, us, ms or s", precision)
+	}
+}
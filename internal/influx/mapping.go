@@ -0,0 +1,90 @@
+package influx
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Mapping maps the fields of line protocol points to sensors. Device and
+// sensor are templates, which may contain the placeholders {measurement},
+// {field} and {tag.<name>}.
+type Mapping struct {
+	// Measurement restricts the mapping to a measurement. Empty or * matches all measurements.
+	Measurement string `mapstructure:"measurement"`
+	// Field restricts the mapping to a field. Empty or * matches all fields.
+	Field string `mapstructure:"field"`
+	// Tags restricts the mapping to points with the given tag values.
+	Tags   map[string]string `mapstructure:"tags"`
+	Device string            `mapstructure:"device"`
+	Sensor string            `mapstructure:"sensor"`
+}
+
+type Config struct {
+	// AutoCreate creates devices and sensors, that do not exist yet.
+	AutoCreate bool      `mapstructure:"auto_create"`
+	Mappings   []Mapping `mapstructure:"mappings"`
+}
+
+// DefaultMapping is used for points, that no configured mapping matches.
+var DefaultMapping = Mapping{Device: "{tag.device}", Sensor: "{measurement}_{field}"}
+
+var placeholder = regexp.MustCompile(`\{([^}]+)\}`)
+
+func (m *Mapping) matches(measurement string, field string, tags map[string]string) bool {
+	if m.Measurement != "" && m.Measurement != "*" && m.Measurement != measurement {
+		return false
+	}
+	if m.Field != "" && m.Field != "*" && m.Field != field {
+		return false
+	}
+	for key, value := range m.Tags {
+		if tags[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *Mapping) resolve(measurement string, field string, tags map[string]string) (deviceId string, sensorId string, err error) {
+	deviceId, err = render(m.Device, measurement, field, tags)
+	if err != nil {
+		return "", "", err
+	}
+	sensorId, err = render(m.Sensor, measurement, field, tags)
+	if err != nil {
+		return "", "", err
+	}
+
+	if deviceId == "" || sensorId == "" {
+		return "", "", fmt.Errorf("mapping results in an empty device or sensor id")
+	}
+	return deviceId, sensorId, nil
+}
+
+func render(template string, measurement string, field string, tags map[string]string) (string, error) {
+	var err error
+	result := placeholder.ReplaceAllStringFunc(template, func(match string) string {
+		name := match[1 : len(match)-1]
+		switch {
+		case name == "measurement":
+			return measurement
+		case name == "field":
+			return field
+		case strings.HasPrefix(name, "tag."):
+			value, ok := tags[strings.TrimPrefix(name, "tag.")]
+			if !ok && err == nil {
+				err = fmt.Errorf("missing tag %s", strings.TrimPrefix(name, "tag."))
+			}
+			return value
+		default:
+			if err == nil {
+				err = fmt.Errorf("unknown placeholder %s", match)
+			}
+			return ""
+		}
+	})
+
+	// Sensor ids are referenced as deviceId.sensorId, so dots are not allowed
+	return strings.ReplaceAll(result, ".", "_"), err
+}
@@ -10,13 +10,15 @@ import (
 	"github.com/soerenchrist/go_home/internal/rules"
 	"github.com/soerenchrist/go_home/internal/sensor"
 	"github.com/soerenchrist/go_home/pkg/output"
+	"github.com/soerenchrist/go_home/pkg/units"
 )
 
 type SensorValueType string
 
 const (
-	PreviousSensorValue SensorValueType = "previous"
-	CurrentSensorValue  SensorValueType = "current"
+	PreviousSensorValue  SensorValueType = "previous"
+	CurrentSensorValue   SensorValueType = "current"
+	AvailableSensorValue SensorValueType = "available"
 )
 
 type UsedSensorValue struct {
@@ -27,15 +29,16 @@ type UsedSensorValue struct {
 
 type RulesEngine struct {
 	database    rules.RulesDatabase
+	dispatcher  *command.Dispatcher
 	lookupTable map[string][]rules.Rule
 }
 
-func NewRulesEngine(database rules.RulesDatabase) *RulesEngine {
+func NewRulesEngine(database rules.RulesDatabase, dispatcher *command.Dispatcher) *RulesEngine {
 	lookupTable, err := buildLookupTable(database)
 	if err != nil {
 		panic(err)
 	}
-	return &RulesEngine{lookupTable: lookupTable, database: database}
+	return &RulesEngine{lookupTable: lookupTable, database: database, dispatcher: dispatcher}
 }
 
 func (engine *RulesEngine) ListenForValues(rulesOutput *output.ChannelOutputBinding) {
@@ -46,6 +49,10 @@ func (engine *RulesEngine) ListenForValues(rulesOutput *output.ChannelOutputBind
 		key := sensor.DeviceID + "." + sensor.SensorID
 		if rules, ok := engine.lookupTable[key]; ok {
 			for _, rule := range rules {
+				// Availability changes only affect rules that ask for the availability of the sensor
+				if sensor.Event == output.EventAvailability && !usesAvailability(&rule, sensor.DeviceID, sensor.SensorID) {
+					continue
+				}
 				log.Debug().Int64("rule_id", rule.Id).Str("rule_name", rule.Name).Msg("Evaluating rule")
 				evalResult, err := engine.EvaluateRule(&rule)
 				if err != nil {
@@ -70,6 +77,10 @@ func (engine *RulesEngine) executeRule(rule rules.Rule) error {
 		return fmt.Errorf("error reading action: %v", err)
 	}
 
+	if action.SceneId != "" {
+		return engine.activateScene(action.SceneId)
+	}
+
 	device, err := engine.database.GetDevice(action.DeviceId)
 	if err != nil {
 		return fmt.Errorf("error reading device: %v", err)
@@ -80,7 +91,7 @@ func (engine *RulesEngine) executeRule(rule rules.Rule) error {
 		return fmt.Errorf("error reading command: %v", err)
 	}
 
-	log.Debug().Str("command_id", cmd.ID).Str("device_id", cmd.DeviceID).Msg("Executing command")
+	log.Debug().Str("command_id", cmd.ID).Str("device_id", cmd.DeviceID).Msg("Submitting command")
 
 	var params command.CommandParameters
 	if action.Payload != "" {
@@ -90,12 +101,26 @@ func (engine *RulesEngine) executeRule(rule rules.Rule) error {
 		}
 	}
 
-	resp, err := cmd.Invoke(device, &params)
+	if _, err := engine.dispatcher.Submit(cmd, device, params); err != nil {
+		return fmt.Errorf("error submitting command: %v", err)
+	}
+	return nil
+}
+
+func (engine *RulesEngine) activateScene(sceneId string) error {
+	s, err := engine.database.GetScene(sceneId)
 	if err != nil {
-		return fmt.Errorf("error invoking command: %v", err)
+		return fmt.Errorf("error reading scene: %v", err)
 	}
 
-	log.Debug().Int("response_status", resp.StatusCode).Msgf("Command response status: %d \n", resp.StatusCode)
+	log.Debug().Str("scene_id", s.ID).Msg("Activating scene")
+
+	go func() {
+		result := s.Activate(engine.database, engine.dispatcher)
+		if !result.Success {
+			log.Error().Str("scene_id", s.ID).Interface("steps", result.Steps).Msg("Scene activation failed")
+		}
+	}()
 	return nil
 }
 
@@ -154,25 +179,72 @@ func (engine *RulesEngine) evaluateAst(ast *rules.Node, values map[string]string
 }
 
 func (engine *RulesEngine) evaluateExpression(expression *rules.ConditionExpression, values map[string]string) (bool, error) {
+	if SensorValueType(expression.Variable) == AvailableSensorValue {
+		return engine.evaluateBoolExpression(expression, values)
+	}
+
 	s, err := engine.database.GetSensor(expression.DeviceId, expression.SensorId)
 	if err != nil {
 		return false, err
 	}
 
+	if expression.Field != "" {
+		return engine.evaluateFieldExpression(expression, values, s)
+	}
+
 	switch s.DataType {
 	case sensor.DataTypeBool:
 		return engine.evaluateBoolExpression(expression, values)
 	case sensor.DataTypeInt:
+		if _, err := strconv.Atoi(expression.Value); err != nil {
+			// Literals with units, e.g. 20°C, are compared as floats after conversion
+			return engine.evaluateFloatExpression(expression, values, s.Unit)
+		}
 		return engine.evaluateIntExpression(expression, values)
 	case sensor.DataTypeFloat:
-		return engine.evaluateFloatExpression(expression, values)
-	case sensor.DataTypeString:
+		return engine.evaluateFloatExpression(expression, values, s.Unit)
+	case sensor.DataTypeString, sensor.DataTypeEnum:
 		return engine.evaluateStringExpression(expression, values)
+	case sensor.DataTypeJson:
+		return false, fmt.Errorf("values of json sensor %s.%s can only be compared by field", s.DeviceID, s.ID)
 	default:
 		return false, fmt.Errorf("unknown data type: %s", s.DataType)
 	}
 }
 
+// evaluateFieldExpression compares a field of a json sensor value, using the data type of the field.
+func (engine *RulesEngine) evaluateFieldExpression(expression *rules.ConditionExpression, values map[string]string, s *sensor.Sensor) (bool, error) {
+	if s.DataType != sensor.DataTypeJson {
+		return false, fmt.Errorf("fields can only be read from json sensors, %s.%s has type %s", s.DeviceID, s.ID, s.DataType)
+	}
+
+	key := fmt.Sprintf("%s.%s.%s", expression.DeviceId, expression.SensorId, expression.Variable)
+	value, ok := values[key]
+	if !ok {
+		return false, fmt.Errorf("unknown sensor value: %s", key)
+	}
+
+	fieldValue, dataType, err := s.Field(value, expression.Field)
+	if err != nil {
+		return false, err
+	}
+
+	fieldExpression := *expression
+	fieldExpression.Field = ""
+	fieldValues := map[string]string{key: fieldValue}
+
+	switch dataType {
+	case sensor.DataTypeBool:
+		return engine.evaluateBoolExpression(&fieldExpression, fieldValues)
+	case sensor.DataTypeInt:
+		return engine.evaluateIntExpression(&fieldExpression, fieldValues)
+	case sensor.DataTypeFloat:
+		return engine.evaluateFloatExpression(&fieldExpression, fieldValues, "")
+	default:
+		return engine.evaluateStringExpression(&fieldExpression, fieldValues)
+	}
+}
+
 func (engine *RulesEngine) evaluateStringExpression(expression *rules.ConditionExpression, values map[string]string) (bool, error) {
 	key := fmt.Sprintf("%s.%s.%s", expression.DeviceId, expression.SensorId, expression.Variable)
 	value, ok := values[key]
@@ -217,7 +289,7 @@ func (engine *RulesEngine) evaluateBoolExpression(expression *rules.ConditionExp
 	}
 }
 
-func (engine *RulesEngine) evaluateFloatExpression(expression *rules.ConditionExpression, values map[string]string) (bool, error) {
+func (engine *RulesEngine) evaluateFloatExpression(expression *rules.ConditionExpression, values map[string]string, unit string) (bool, error) {
 	key := fmt.Sprintf("%s.%s.%s", expression.DeviceId, expression.SensorId, expression.Variable)
 	value, ok := values[key]
 	if !ok {
@@ -229,9 +301,9 @@ func (engine *RulesEngine) evaluateFloatExpression(expression *rules.ConditionEx
 		return false, fmt.Errorf("invalid value for type int: %s", value)
 	}
 
-	expValue, err := strconv.ParseFloat(expression.Value, 64)
+	expValue, err := parseLiteral(expression.Value, unit)
 	if err != nil {
-		return false, fmt.Errorf("invalid value for type int: %s", expression.Value)
+		return false, err
 	}
 
 	switch expression.Operator {
@@ -252,6 +324,25 @@ func (engine *RulesEngine) evaluateFloatExpression(expression *rules.ConditionEx
 	}
 }
 
+// parseLiteral parses a number, which may be suffixed with a unit like 20°C.
+// Values with units are converted to the unit of the sensor.
+func parseLiteral(literal string, unit string) (float64, error) {
+	if value, err := strconv.ParseFloat(literal, 64); err == nil {
+		return value, nil
+	}
+
+	value, literalUnit, err := units.ParseQuantity(literal)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value for type float: %s", literal)
+	}
+
+	converted, err := units.Convert(value, literalUnit.Symbol, unit)
+	if err != nil {
+		return 0, fmt.Errorf("cannot compare %s with values in %s: %v", literal, unit, err)
+	}
+	return converted, nil
+}
+
 func (engine *RulesEngine) evaluateIntExpression(expression *rules.ConditionExpression, values map[string]string) (bool, error) {
 	key := fmt.Sprintf("%s.%s.%s", expression.DeviceId, expression.SensorId, expression.Variable)
 	value, ok := values[key]
@@ -304,6 +395,12 @@ func (engine *RulesEngine) readDependentValues(deps []UsedSensorValue) (map[stri
 				return nil, err
 			}
 			results[key] = value.Value
+		} else if dep.Type == AvailableSensorValue {
+			s, err := engine.database.GetSensor(dep.DeviceId, dep.SensorId)
+			if err != nil {
+				return nil, err
+			}
+			results[key] = strconv.FormatBool(s.Health.Available())
 		} else {
 			return nil, fmt.Errorf("unknown sensor value type: %s", dep.Type)
 		}
@@ -367,6 +464,14 @@ func determineUsedSensorsRec(node *rules.Node, usedValues *[]UsedSensorValue) {
 	}
 }
 
+func usesAvailability(rule *rules.Rule, deviceId, sensorId string) bool {
+	usedSensors, err := DetermineUsedSensors(rule)
+	if err != nil {
+		return false
+	}
+	return contains(usedSensors, UsedSensorValue{deviceId, sensorId, AvailableSensorValue})
+}
+
 func contains(values []UsedSensorValue, value UsedSensorValue) bool {
 	for _, v := range values {
 		if v.DeviceId == value.DeviceId && v.SensorId == value.SensorId && v.Type == value.Type {
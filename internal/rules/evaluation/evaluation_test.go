@@ -9,6 +9,7 @@ import (
 	"github.com/soerenchrist/go_home/internal/device"
 	"github.com/soerenchrist/go_home/internal/rules"
 	"github.com/soerenchrist/go_home/internal/rules/evaluation"
+	"github.com/soerenchrist/go_home/internal/scene"
 	"github.com/soerenchrist/go_home/internal/sensor"
 	"github.com/soerenchrist/go_home/internal/value"
 )
@@ -17,6 +18,7 @@ func TestDetermineUsedSensorValues_ShouldFindCorrectValues(t *testing.T) {
 	expressions := []string{
 		"when ${device1.sensor1.current} > 10 AND ${device2.sensor2.previous} == 1",
 		"when ${device1.sensor3.current} <= 10.12 OR ${device2.sensor2.current} != true",
+		"when ${device1.sensor1.available} == false",
 	}
 
 	expected := [][]evaluation.UsedSensorValue{
@@ -45,6 +47,14 @@ func TestDetermineUsedSensorValues_ShouldFindCorrectValues(t *testing.T) {
 				Type:     evaluation.CurrentSensorValue,
 			},
 		},
+
+		{
+			{
+				DeviceId: "device1",
+				SensorId: "sensor1",
+				Type:     evaluation.AvailableSensorValue,
+			},
+		},
 	}
 
 	for i, expression := range expressions {
@@ -85,6 +95,36 @@ func (db FakeDatabase) ListRules() ([]rules.Rule, error) {
 			Name: "Test Rule 3",
 			Id:   2,
 		},
+		{
+			When: rules.WhenExpression("when ${device2.sensor2.available} == false AND ${device1.sensor1.available} == true"),
+			Then: rules.ThenExpression("then ${device1.switch1} = true"),
+			Name: "Test Rule 4",
+			Id:   4,
+		},
+		{
+			When: rules.WhenExpression("when ${device1.sensor1.current} > 50°F"),
+			Then: rules.ThenExpression("then ${device1.switch1} = true"),
+			Name: "Test Rule 5",
+			Id:   5,
+		},
+		{
+			When: rules.WhenExpression("when ${device1.sensor1.current} >= 285K"),
+			Then: rules.ThenExpression("then ${device1.switch1} = true"),
+			Name: "Test Rule 6",
+			Id:   6,
+		},
+		{
+			When: rules.WhenExpression("when ${device1.sensor3.current.temp} > 20 AND ${device1.sensor3.current.window.open} == true"),
+			Then: rules.ThenExpression("then ${device1.switch1} = true"),
+			Name: "Test Rule 7",
+			Id:   7,
+		},
+		{
+			When: rules.WhenExpression("when ${device1.sensor3.current.mode} == cooling"),
+			Then: rules.ThenExpression("then ${device1.switch1} = true"),
+			Name: "Test Rule 8",
+			Id:   8,
+		},
 	}, nil
 }
 
@@ -99,9 +139,20 @@ func (db FakeDatabase) GetSensor(deviceId, sensorId string) (*sensor.Sensor, err
 			ID:       "sensor1",
 			Type:     sensor.SensorTypeExternal,
 			DataType: sensor.DataTypeInt,
+			Unit:     "°C",
 			Name:     "Sensor 1",
 			IsActive: true,
 		}, nil
+	} else if deviceId == "device1" && sensorId == "sensor3" {
+		return &sensor.Sensor{
+			DeviceID: "device1",
+			ID:       "sensor3",
+			Type:     sensor.SensorTypeExternal,
+			DataType: sensor.DataTypeJson,
+			Schema:   sensor.Schema{"temp": sensor.DataTypeFloat},
+			Name:     "Sensor 3",
+			IsActive: true,
+		}, nil
 	} else if deviceId == "device2" && sensorId == "sensor2" {
 		return &sensor.Sensor{
 			DeviceID: "device2",
@@ -110,6 +161,7 @@ func (db FakeDatabase) GetSensor(deviceId, sensorId string) (*sensor.Sensor, err
 			DataType: sensor.DataTypeBool,
 			Name:     "Sensor 2",
 			IsActive: true,
+			Health:   &sensor.SensorHealth{Status: sensor.HealthStatusUnavailable, ConsecutiveFailures: 3},
 		}, nil
 	}
 	return nil, fmt.Errorf("Sensor not found for %s.%s", deviceId, sensorId)
@@ -129,6 +181,12 @@ func (db FakeDatabase) GetCurrentSensorValue(deviceId, sensorId string) (*value.
 			Value:     "true",
 			Timestamp: time.Now(),
 		},
+		"device1.sensor3": {
+			SensorID:  "sensor3",
+			DeviceID:  "device1",
+			Value:     `{"temp": 21.3, "mode": "heating", "window": {"open": true}}`,
+			Timestamp: time.Now(),
+		},
 	}
 
 	key := deviceId + "." + sensorId
@@ -171,16 +229,20 @@ func (db FakeDatabase) GetDevice(deviceId string) (*device.Device, error) {
 	return nil, fmt.Errorf("Not implemented")
 }
 
+func (db FakeDatabase) GetScene(sceneId string) (*scene.Scene, error) {
+	return nil, fmt.Errorf("Not implemented")
+}
+
 func TestRuleEvaluation(t *testing.T) {
 	database := FakeDatabase{}
-	rulesEngine := evaluation.NewRulesEngine(database)
+	rulesEngine := evaluation.NewRulesEngine(database, nil)
 
 	rules, err := database.ListRules()
 	if err != nil {
 		t.Errorf("Error while listing rules: %v", err)
 	}
 
-	expectedResults := []bool{true, false, true}
+	expectedResults := []bool{true, false, true, true, true, false, true, false}
 
 	for i, rule := range rules {
 		result, err := rulesEngine.EvaluateRule(&rule)
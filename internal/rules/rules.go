@@ -7,6 +7,7 @@ import (
 
 	"github.com/soerenchrist/go_home/internal/command"
 	"github.com/soerenchrist/go_home/internal/device"
+	"github.com/soerenchrist/go_home/internal/scene"
 	"github.com/soerenchrist/go_home/internal/sensor"
 	"github.com/soerenchrist/go_home/internal/value"
 )
@@ -22,6 +23,7 @@ type RulesDatabase interface {
 	GetPreviousSensorValue(deviceId, sensorId string) (*value.SensorValue, error)
 	GetCommand(deviceId, commandId string) (*command.Command, error)
 	GetDevice(deviceId string) (*device.Device, error)
+	GetScene(sceneId string) (*scene.Scene, error)
 }
 
 type Rule struct {
@@ -44,6 +46,8 @@ type ConditionExpression struct {
 	SensorId string
 	DeviceId string
 	Variable string
+	// Field addresses a field of json sensor values, e.g. `temp` in ${1.S5.current.temp}
+	Field    string
 	Operator Operator
 	Value    string
 }
@@ -52,6 +56,7 @@ type ActionExpression struct {
 	DeviceId  string
 	CommandId string
 	Payload   string
+	SceneId   string
 }
 
 type Node struct {
@@ -116,7 +121,7 @@ func (rule *Rule) parseWhenExpression(tokens []string) (*Node, error) {
 		return nil, fmt.Errorf("invalid rule: %s - Expected variable", rule.When)
 	}
 
-	deviceId, sensorId, variable, err := rule.readSensorVariable(token)
+	deviceId, sensorId, variable, field, err := rule.readSensorVariable(token)
 	if err != nil {
 		return nil, err
 	}
@@ -137,6 +142,7 @@ func (rule *Rule) parseWhenExpression(tokens []string) (*Node, error) {
 		SensorId: sensorId,
 		DeviceId: deviceId,
 		Variable: variable,
+		Field:    field,
 		Operator: operator,
 		Value:    value,
 	}
@@ -175,6 +181,10 @@ func (rule *Rule) parseThenExpression(tokens []string) (*ActionExpression, error
 	}
 	currentIndex := 0
 
+	if strings.ToUpper(tokens[currentIndex]) == "SCENE" {
+		return rule.parseSceneAction(tokens[currentIndex+1:])
+	}
+
 	token := tokens[currentIndex]
 	if !rule.isVariable(token) {
 		return nil, fmt.Errorf("invalid rule: %s - Expected command variable", rule.Then)
@@ -200,18 +210,35 @@ func (rule *Rule) parseThenExpression(tokens []string) (*ActionExpression, error
 	return action, nil
 }
 
+func (rule *Rule) parseSceneAction(tokens []string) (*ActionExpression, error) {
+	if len(tokens) == 0 || !rule.isVariable(tokens[0]) {
+		return nil, fmt.Errorf("invalid rule: %s - Expected scene variable", rule.Then)
+	}
+
+	if len(tokens) > 1 {
+		return nil, fmt.Errorf("invalid rule: %s - Scenes do not accept a payload", rule.Then)
+	}
+
+	sceneId := tokens[0][2 : len(tokens[0])-1]
+	if sceneId == "" || strings.Contains(sceneId, ".") {
+		return nil, fmt.Errorf("invalid variable: %s - Should consist of sceneId", tokens[0])
+	}
+
+	return &ActionExpression{SceneId: sceneId}, nil
+}
+
 func (rule *Rule) isVariable(token string) bool {
 	return strings.HasPrefix(token, "${") && strings.HasSuffix(token, "}")
 }
 
-func (rule *Rule) readSensorVariable(token string) (deviceId string, sensorId string, variable string, err error) {
+func (rule *Rule) readSensorVariable(token string) (deviceId string, sensorId string, variable string, field string, err error) {
 	parts := strings.Split(token[2:len(token)-1], ".")
 
-	if len(parts) != 3 {
-		return "", "", "", fmt.Errorf("invalid variable: %s - Should consist of deviceId.sensorId.variable", token)
+	if len(parts) < 3 {
+		return "", "", "", "", fmt.Errorf("invalid variable: %s - Should consist of deviceId.sensorId.variable", token)
 	}
 
-	return parts[0], parts[1], parts[2], nil
+	return parts[0], parts[1], parts[2], strings.Join(parts[3:], "."), nil
 }
 
 func (rule *Rule) readCommandVariable(token string) (deviceId string, commandId string, err error) {
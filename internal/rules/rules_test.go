@@ -64,6 +64,7 @@ func TestReadDependentSensors_ShouldReturnCorrectValues(t *testing.T) {
 		"when ${1.S1.curr} > 1",
 		"when ${1.S1.curr} > 1 AND ${1.S1.prev} < 2",
 		"when ${1.S2.curr} != true OR ${1.S2.prev} == false",
+		"when ${1.S5.current.outdoor.temp} > 20",
 	}
 
 	expectedResult := []rules.Node{
@@ -118,6 +119,16 @@ func TestReadDependentSensors_ShouldReturnCorrectValues(t *testing.T) {
 				},
 			},
 		},
+		{
+			Expression: &rules.ConditionExpression{
+				SensorId: "S5",
+				DeviceId: "1",
+				Variable: "current",
+				Field:    "outdoor.temp",
+				Operator: ">",
+				Value:    "20",
+			},
+		},
 	}
 
 	for i, expression := range expressions {
@@ -187,6 +198,10 @@ func assertSensor(t *testing.T, expected, got *rules.ConditionExpression) {
 		t.Errorf("Expected variable '%s', but got '%s'", expected.Variable, got.Variable)
 	}
 
+	if expected.Field != got.Field {
+		t.Errorf("Expected field '%s', but got '%s'", expected.Field, got.Field)
+	}
+
 	if expected.Operator != got.Operator {
 		t.Errorf("Expected operator '%s', but got '%s'", expected.Operator, got.Operator)
 	}
@@ -203,6 +218,9 @@ func TestInvalidActionRules(t *testing.T) {
 		"then",
 		"then something",
 		"then ${something}",
+		"then scene",
+		"then scene ${movie.night}",
+		"then scene ${movie_night} on",
 	}
 
 	expectedMessages := []string{
@@ -211,6 +229,9 @@ func TestInvalidActionRules(t *testing.T) {
 		"Then Expression is empty",
 		"Expected command variable",
 		"Should consist of deviceId.commandId",
+		"Expected scene variable",
+		"Should consist of sceneId",
+		"Scenes do not accept a payload",
 	}
 
 	for i, exp := range invalidExpressions {
@@ -241,6 +262,7 @@ func TestValidActionRules(t *testing.T) {
 		"then ${device1.command1}",
 		"then ${device2.command2} ON",
 		`then ${device2.command2} {"key": "value"}`,
+		"then SCENE ${movie_night}",
 	}
 
 	expectedActions := []rules.ActionExpression{
@@ -259,6 +281,9 @@ func TestValidActionRules(t *testing.T) {
 			CommandId: "command2",
 			Payload:   `{"key": "value"}`,
 		},
+		{
+			SceneId: "movie_night",
+		},
 	}
 
 	for i, exp := range validExpressions {
@@ -289,5 +314,9 @@ func TestValidActionRules(t *testing.T) {
 		if result.Payload != expectedAction.Payload {
 			t.Errorf("Expected payload '%s', but got '%s'", expectedAction.Payload, result.Payload)
 		}
+
+		if result.SceneId != expectedAction.SceneId {
+			t.Errorf("Expected sceneId '%s', but got '%s'", expectedAction.SceneId, result.SceneId)
+		}
 	}
 }
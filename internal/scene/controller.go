@@ -0,0 +1,162 @@
+package scene
+
+import (
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	"github.com/soerenchrist/go_home/internal/command"
+	"github.com/soerenchrist/go_home/internal/errors"
+)
+
+type ScenesDatabase interface {
+	ListScenes() ([]Scene, error)
+	GetScene(sceneId string) (*Scene, error)
+	AddScene(scene *Scene) error
+	UpdateScene(scene *Scene) error
+	DeleteScene(sceneId string) error
+	CommandsReader
+}
+
+type ScenesController struct {
+	database   ScenesDatabase
+	dispatcher *command.Dispatcher
+}
+
+func NewController(database ScenesDatabase, dispatcher *command.Dispatcher) *ScenesController {
+	return &ScenesController{database: database, dispatcher: dispatcher}
+}
+
+func (c *ScenesController) ListScenes(context *gin.Context) {
+	scenes, err := c.database.ListScenes()
+	if err != nil {
+		context.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	context.JSON(200, scenes)
+}
+
+func (c *ScenesController) GetScene(context *gin.Context) {
+	sceneId := context.Param("sceneId")
+
+	scene, err := c.database.GetScene(sceneId)
+	if err != nil {
+		context.JSON(404, gin.H{"error": "Scene not found"})
+		return
+	}
+	context.JSON(200, scene)
+}
+
+func (c *ScenesController) PostScene(context *gin.Context) {
+	var request CreateSceneRequest
+	if err := context.BindJSON(&request); err != nil {
+		context.JSON(400, gin.H{"error": "Invalid JSON"})
+		return
+	}
+
+	if _, err := c.database.GetScene(request.ID); err == nil {
+		context.JSON(409, gin.H{"error": fmt.Sprintf("Scene with id %s does already exist", request.ID)})
+		return
+	}
+
+	if err := c.validateScene(&request); err != nil {
+		context.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	scene := &Scene{
+		ID:    request.ID,
+		Name:  request.Name,
+		Steps: request.steps(request.ID),
+	}
+
+	if err := c.database.AddScene(scene); err != nil {
+		context.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	context.JSON(201, scene)
+}
+
+func (c *ScenesController) PutScene(context *gin.Context) {
+	sceneId := context.Param("sceneId")
+
+	scene, err := c.database.GetScene(sceneId)
+	if err != nil {
+		context.JSON(404, gin.H{"error": "Scene not found"})
+		return
+	}
+
+	var request CreateSceneRequest
+	if err := context.BindJSON(&request); err != nil {
+		context.JSON(400, gin.H{"error": "Invalid JSON"})
+		return
+	}
+	request.ID = sceneId
+
+	if err := c.validateScene(&request); err != nil {
+		context.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	scene.Name = request.Name
+	scene.Steps = request.steps(sceneId)
+
+	if err := c.database.UpdateScene(scene); err != nil {
+		context.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	context.JSON(200, scene)
+}
+
+func (c *ScenesController) DeleteScene(context *gin.Context) {
+	sceneId := context.Param("sceneId")
+
+	err := c.database.DeleteScene(sceneId)
+
+	if notFound, isOk := err.(*errors.NotFoundError); isOk {
+		context.JSON(404, gin.H{"error": notFound.Error()})
+		return
+	}
+
+	if err != nil {
+		context.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	context.Status(204)
+}
+
+func (c *ScenesController) ActivateScene(context *gin.Context) {
+	sceneId := context.Param("sceneId")
+
+	scene, err := c.database.GetScene(sceneId)
+	if err != nil {
+		context.JSON(404, gin.H{"error": "Scene not found"})
+		return
+	}
+
+	result := scene.Activate(c.database, c.dispatcher)
+	context.JSON(200, result)
+}
+
+func (c *ScenesController) validateScene(request *CreateSceneRequest) error {
+	if request.ID == "" {
+		return &errors.ValidationError{Message: "Id is required"}
+	}
+
+	if len(request.Name) < 3 {
+		return &errors.ValidationError{Message: "Name must be at least 3 characters long"}
+	}
+
+	if len(request.Steps) == 0 {
+		return &errors.ValidationError{Message: "At least one step is required"}
+	}
+
+	for i, step := range request.Steps {
+		if _, err := c.database.GetCommand(step.DeviceID, step.CommandID); err != nil {
+			return &errors.ValidationError{Message: fmt.Sprintf("Step %d: Command %s.%s does not exist", i+1, step.DeviceID, step.CommandID)}
+		}
+	}
+
+	return nil
+}
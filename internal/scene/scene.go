@@ -0,0 +1,146 @@
+package scene
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/soerenchrist/go_home/internal/command"
+	"github.com/soerenchrist/go_home/internal/device"
+)
+
+type Scene struct {
+	ID    string      `json:"id" gorm:"primaryKey"`
+	Name  string      `json:"name"`
+	Steps []SceneStep `json:"steps" gorm:"foreignKey:SceneID"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type SceneStep struct {
+	ID        uint                      `json:"-"`
+	SceneID   string                    `json:"-"`
+	Position  int                       `json:"position"`
+	DeviceID  string                    `json:"device_id"`
+	CommandID string                    `json:"command_id"`
+	Params    command.CommandParameters `json:"params" gorm:"serializer:json"`
+}
+
+func (s *Scene) String() string {
+	return fmt.Sprintf("Scene<%s %s>", s.ID, s.Name)
+}
+
+type CommandsReader interface {
+	GetDevice(deviceId string) (*device.Device, error)
+	GetCommand(deviceId string, commandId string) (*command.Command, error)
+}
+
+type StepResult struct {
+	Position   int    `json:"position"`
+	DeviceID   string `json:"device_id"`
+	CommandID  string `json:"command_id"`
+	Success    bool   `json:"success"`
+	StatusCode int    `json:"status_code,omitempty"`
+	Response   string `json:"response,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+type ActivationResult struct {
+	SceneID string       `json:"scene_id"`
+	Success bool         `json:"success"`
+	Steps   []StepResult `json:"steps"`
+}
+
+// Activate runs the steps of the scene in order. Every step waits for the
+// previous one to finish, and a failing step does not stop the remaining ones.
+func (s *Scene) Activate(database CommandsReader, dispatcher *command.Dispatcher) *ActivationResult {
+	result := &ActivationResult{
+		SceneID: s.ID,
+		Success: true,
+		Steps:   make([]StepResult, 0, len(s.Steps)),
+	}
+
+	for _, step := range s.Steps {
+		stepResult := step.run(database, dispatcher)
+		if !stepResult.Success {
+			result.Success = false
+		}
+		result.Steps = append(result.Steps, stepResult)
+	}
+
+	return result
+}
+
+func (step *SceneStep) run(database CommandsReader, dispatcher *command.Dispatcher) StepResult {
+	result := StepResult{
+		Position:  step.Position,
+		DeviceID:  step.DeviceID,
+		CommandID: step.CommandID,
+	}
+
+	device, err := database.GetDevice(step.DeviceID)
+	if err != nil {
+		result.Error = "Device not found"
+		return result
+	}
+
+	cmd, err := database.GetCommand(step.DeviceID, step.CommandID)
+	if err != nil {
+		result.Error = "Command not found"
+		return result
+	}
+
+	invocation, err := dispatcher.Submit(cmd, device, step.Params)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	invocationResult, err := invocation.Wait()
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	result.StatusCode = invocationResult.StatusCode
+	result.Response = invocationResult.Response
+
+	// Commands without a http response, like wake on lan, have no status code
+	if result.StatusCode != 0 && (result.StatusCode < 200 || result.StatusCode >= 300) {
+		result.Error = fmt.Sprintf("Command returned status code %d", result.StatusCode)
+		return result
+	}
+
+	result.Success = true
+	return result
+}
+
+type CreateSceneRequest struct {
+	ID    string              `json:"id"`
+	Name  string              `json:"name"`
+	Steps []CreateStepRequest `json:"steps"`
+}
+
+type CreateStepRequest struct {
+	DeviceID  string                    `json:"device_id"`
+	CommandID string                    `json:"command_id"`
+	Params    command.CommandParameters `json:"params"`
+}
+
+func (request *CreateSceneRequest) steps(sceneId string) []SceneStep {
+	steps := make([]SceneStep, 0, len(request.Steps))
+	for i, step := range request.Steps {
+		params := step.Params
+		if params == nil {
+			params = make(command.CommandParameters)
+		}
+		steps = append(steps, SceneStep{
+			SceneID:   sceneId,
+			Position:  i + 1,
+			DeviceID:  step.DeviceID,
+			CommandID: step.CommandID,
+			Params:    params,
+		})
+	}
+	return steps
+}
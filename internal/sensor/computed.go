@@ -0,0 +1,60 @@
+package sensor
+
+import (
+	"github.com/soerenchrist/go_home/pkg/expression"
+)
+
+// Key identifies a sensor in expressions, e.g. `1.S1`.
+func (s *Sensor) Key() string {
+	return s.DeviceID + "." + s.ID
+}
+
+func (s *Sensor) IsComputed() bool {
+	return s.Type == SensorTypeComputed
+}
+
+// Inputs returns the keys of all sensors the expression of a computed sensor depends on.
+func (s *Sensor) Inputs() ([]string, error) {
+	expr, err := expression.Parse(s.Expression)
+	if err != nil {
+		return nil, err
+	}
+	return expr.Variables(), nil
+}
+
+// CyclicSensors returns the keys of all computed sensors that depend on
+// themselves, either directly or through other computed sensors.
+func CyclicSensors(computed []Sensor) map[string]bool {
+	dependencies := make(map[string][]string)
+	for i := range computed {
+		inputs, err := computed[i].Inputs()
+		if err != nil {
+			continue
+		}
+		dependencies[computed[i].Key()] = inputs
+	}
+
+	cyclic := make(map[string]bool)
+	for key := range dependencies {
+		if reaches(dependencies, key, key, make(map[string]bool)) {
+			cyclic[key] = true
+		}
+	}
+	return cyclic
+}
+
+func reaches(dependencies map[string][]string, from string, target string, visited map[string]bool) bool {
+	for _, input := range dependencies[from] {
+		if input == target {
+			return true
+		}
+		if visited[input] {
+			continue
+		}
+		visited[input] = true
+		if reaches(dependencies, input, target, visited) {
+			return true
+		}
+	}
+	return false
+}
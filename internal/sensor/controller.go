@@ -2,10 +2,18 @@ package sensor
 
 import (
 	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/soerenchrist/go_home/internal/device"
 	"github.com/soerenchrist/go_home/internal/errors"
+	"github.com/soerenchrist/go_home/pkg/jsonpath"
+	"github.com/soerenchrist/go_home/pkg/units"
 )
 
 type SensorsDatabase interface {
@@ -13,15 +21,48 @@ type SensorsDatabase interface {
 	GetSensor(deviceId string, sensorId string) (*Sensor, error)
 	GetDevice(deviceId string) (*device.Device, error)
 	AddSensor(sensor *Sensor) error
+	UpdateSensor(sensor *Sensor) error
 	DeleteSensor(deviceId string, sensorId string) error
+	ListSensorHealth() ([]SensorHealth, error)
+	ListComputedSensors() ([]Sensor, error)
+}
+
+// ChangeListener is notified after sensors have been changed through the API.
+type ChangeListener interface {
+	SensorCreated(sensor *Sensor)
+	SensorUpdated(sensor *Sensor)
+	SensorDeleted(deviceId string, sensorId string)
+}
+
+// ChangeListeners forwards changes to all of its listeners.
+type ChangeListeners []ChangeListener
+
+func (l ChangeListeners) SensorCreated(sensor *Sensor) {
+	for _, listener := range l {
+		listener.SensorCreated(sensor)
+	}
+}
+
+func (l ChangeListeners) SensorUpdated(sensor *Sensor) {
+	for _, listener := range l {
+		listener.SensorUpdated(sensor)
+	}
+}
+
+func (l ChangeListeners) SensorDeleted(deviceId string, sensorId string) {
+	for _, listener := range l {
+		listener.SensorDeleted(deviceId, sensorId)
+	}
 }
 
 type SensorsController struct {
 	database SensorsDatabase
+	listener ChangeListener
+	access   LocalAccess
 }
 
-func NewController(database SensorsDatabase) *SensorsController {
-	return &SensorsController{database: database}
+func NewController(database SensorsDatabase, listener ChangeListener, access LocalAccess) *SensorsController {
+	return &SensorsController{database: database, listener: listener, access: access}
 }
 
 func (c *SensorsController) GetSensors(context *gin.Context) {
@@ -37,7 +78,11 @@ func (c *SensorsController) GetSensors(context *gin.Context) {
 		context.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
-	context.JSON(200, sensors)
+	redacted := make([]*Sensor, 0, len(sensors))
+	for i := range sensors {
+		redacted = append(redacted, sensors[i].Redacted())
+	}
+	context.JSON(200, redacted)
 }
 
 func (c *SensorsController) PostSensor(context *gin.Context) {
@@ -63,57 +108,122 @@ func (c *SensorsController) PostSensor(context *gin.Context) {
 		return
 	}
 
-	if request.Type != SensorTypePolling {
-		request.Type = SensorTypeExternal
+	if err := c.validateComputedSensor(deviceId, request.Id, request); err != nil {
+		context.JSON(400, gin.H{"error": err.Error()})
+		return
 	}
 
-	if request.Type == SensorTypePolling {
-		request.PollingStrategy = PollingStrategyPing
+	sensor := &Sensor{
+		ID:       request.Id,
+		DeviceID: deviceId,
+		IsActive: true,
 	}
+	sensor.apply(&request)
 
-	if request.RetainmentPeriodSeconds == 0 {
-		request.RetainmentPeriodSeconds = -1
+	err := c.database.AddSensor(sensor)
+	if err != nil {
+		context.JSON(500, gin.H{"error": err.Error()})
+		return
 	}
 
-	sensor := &Sensor{
-		ID:                      request.Id,
-		Name:                    request.Name,
-		DeviceID:                deviceId,
-		DataType:                DataType(request.DataType),
-		Unit:                    request.Unit,
-		Type:                    request.Type,
-		PollingInterval:         request.PollingInterval,
-		PollingEndpoint:         request.PollingEndpoint,
-		PollingStrategy:         request.PollingStrategy,
-		RetainmentPeriodSeconds: request.RetainmentPeriodSeconds,
-		IsActive:                true,
+	if c.listener != nil {
+		c.listener.SensorCreated(sensor)
 	}
 
-	err := c.database.AddSensor(sensor)
+	context.JSON(201, sensor.Redacted())
+}
+
+func (c *SensorsController) PutSensor(context *gin.Context) {
+	c.updateSensor(context, false)
+}
+
+func (c *SensorsController) PatchSensor(context *gin.Context) {
+	c.updateSensor(context, true)
+}
+
+func (c *SensorsController) updateSensor(context *gin.Context, partial bool) {
+	deviceId := context.Param("deviceId")
+	sensorId := context.Param("sensorId")
+
+	if _, err := c.database.GetDevice(deviceId); err != nil {
+		context.JSON(404, gin.H{"error": "Device not found"})
+		return
+	}
+
+	sensor, err := c.database.GetSensor(deviceId, sensorId)
+	if err != nil {
+		context.JSON(404, gin.H{"error": "Sensor not found"})
+		return
+	}
+
+	var request CreateSensorRequest
+	if partial {
+		request = sensor.toRequest()
+	}
+
+	if err := context.BindJSON(&request); err != nil {
+		context.JSON(400, gin.H{"error": "Invalid JSON"})
+		return
+	}
+
+	if err := c.validateSensor(request); err != nil {
+		context.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := c.validateComputedSensor(deviceId, sensorId, request); err != nil {
+		context.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	sensor.apply(&request)
+
+	if err := c.database.UpdateSensor(sensor); err != nil {
+		context.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	if c.listener != nil {
+		c.listener.SensorUpdated(sensor)
+	}
+
+	context.JSON(200, sensor.Redacted())
+}
+
+func (c *SensorsController) GetSensorHealth(context *gin.Context) {
+	healths, err := c.database.ListSensorHealth()
 	if err != nil {
 		context.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
 
-	context.JSON(201, sensor)
+	context.JSON(200, healths)
 }
 
 func (c *SensorsController) GetSensor(context *gin.Context) {
 	deviceId := context.Param("deviceId")
 	sensorId := context.Param("sensorId")
 
-	device, err := c.database.GetSensor(deviceId, sensorId)
+	sensor, err := c.database.GetSensor(deviceId, sensorId)
 	if err != nil {
 		context.JSON(404, gin.H{"error": "Sensor not found"})
 		return
 	}
-	context.JSON(200, device)
+	context.JSON(200, sensor.Redacted())
 }
 
 func (c *SensorsController) DeleteSensor(context *gin.Context) {
 	deviceId := context.Param("deviceId")
 	sensorId := context.Param("sensorId")
 
+	if dependent, err := c.dependentSensor(deviceId, sensorId); err != nil {
+		context.JSON(500, gin.H{"error": err.Error()})
+		return
+	} else if dependent != nil {
+		context.JSON(409, gin.H{"error": fmt.Sprintf("Sensor is used by computed sensor %s", dependent.Key())})
+		return
+	}
+
 	err := c.database.DeleteSensor(deviceId, sensorId)
 
 	if notFound, isOk := err.(*errors.NotFoundError); isOk {
@@ -125,6 +235,10 @@ func (c *SensorsController) DeleteSensor(context *gin.Context) {
 		context.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
+
+	if c.listener != nil {
+		c.listener.SensorDeleted(deviceId, sensorId)
+	}
 	context.Status(204)
 }
 
@@ -133,27 +247,326 @@ func (c *SensorsController) validateSensor(sensor CreateSensorRequest) error {
 		return &errors.ValidationError{Message: "Name must be at least 3 characters long"}
 	}
 
-	if len(sensor.Unit) > 0 && (sensor.DataType == DataTypeBool || sensor.DataType == DataTypeString) {
+	if len(sensor.Unit) > 0 && (sensor.DataType == DataTypeBool || sensor.DataType == DataTypeString || sensor.DataType == DataTypeEnum || sensor.DataType == DataTypeJson) {
 		return &errors.ValidationError{Message: "Unit is not allowed for this data type"}
 	}
 
-	if sensor.Type != SensorTypePolling && sensor.Type != SensorTypeExternal {
+	if sensor.Type != SensorTypePolling && sensor.Type != SensorTypeExternal && sensor.Type != SensorTypeComputed {
 		return &errors.ValidationError{Message: "Invalid sensor type"}
 	}
 
+	if err := validateDataType(sensor); err != nil {
+		return err
+	}
+
+	if err := validatePipeline(sensor); err != nil {
+		return err
+	}
+
 	if sensor.Type == SensorTypePolling && sensor.PollingInterval < 1 {
 		return &errors.ValidationError{Message: "Polling interval must be greater than 0"}
 	}
 
 	if sensor.Type == SensorTypePolling {
-		if sensor.PollingStrategy != PollingStrategyPing {
+		strategies := []string{string(PollingStrategyPing), string(PollingStrategyHttp), string(PollingStrategyTcp), string(PollingStrategyTls), string(PollingStrategyExec), string(PollingStrategyFile)}
+		if !contains(strategies, string(sensor.PollingStrategy)) {
 			return &errors.ValidationError{Message: "Invalid polling strategy"}
 		}
 
-		if sensor.PollingStrategy == PollingStrategyPing && len(sensor.PollingEndpoint) == 0 {
+		if len(sensor.PollingEndpoint) == 0 {
 			return &errors.ValidationError{Message: "Polling endpoint is required"}
 		}
+
+		switch sensor.PollingStrategy {
+		case PollingStrategyPing:
+			return validatePingPolling(sensor)
+		case PollingStrategyHttp:
+			return c.validateHttpPolling(sensor)
+		case PollingStrategyTcp:
+			if sensor.DataType != DataTypeBool && sensor.DataType != DataTypeInt {
+				return &errors.ValidationError{Message: "TCP polling requires data type bool or int"}
+			}
+			return validateHostPort(sensor)
+		case PollingStrategyTls:
+			if sensor.DataType != DataTypeInt {
+				return &errors.ValidationError{Message: "TLS certificate polling requires data type int"}
+			}
+			return validateHostPort(sensor)
+		case PollingStrategyExec:
+			return c.validateExecPolling(sensor)
+		case PollingStrategyFile:
+			return c.validateFilePolling(sensor)
+		}
 	}
 
 	return nil
 }
+
+func (c *SensorsController) validateHttpPolling(sensor CreateSensorRequest) error {
+	endpoint, err := url.Parse(sensor.PollingEndpoint)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return &errors.ValidationError{Message: "Polling endpoint must be a http or https URL"}
+	}
+
+	options := sensor.PollingOptions
+	methods := []string{"", "GET", "POST", "PUT"}
+	if !contains(methods, options.Method) {
+		return &errors.ValidationError{Message: "Method must be one of GET, POST or PUT"}
+	}
+
+	if options.TimeoutSeconds < 0 {
+		return &errors.ValidationError{Message: "Timeout must not be negative"}
+	}
+
+	return validateExtractor(options)
+}
+
+func (c *SensorsController) validateExecPolling(sensor CreateSensorRequest) error {
+	if !IsCommandAllowed(c.access.Commands, sensor.PollingEndpoint, sensor.PollingOptions.Args) {
+		return &errors.ValidationError{Message: fmt.Sprintf("Command %s is not allowed", CommandLine(sensor.PollingEndpoint, sensor.PollingOptions.Args))}
+	}
+
+	if sensor.PollingOptions.TimeoutSeconds < 0 {
+		return &errors.ValidationError{Message: "Timeout must not be negative"}
+	}
+
+	return validateExtractor(sensor.PollingOptions)
+}
+
+func (c *SensorsController) validateFilePolling(sensor CreateSensorRequest) error {
+	if !strings.HasPrefix(sensor.PollingEndpoint, "/") {
+		return &errors.ValidationError{Message: "Polling endpoint must be an absolute path"}
+	}
+
+	if !IsPathAllowed(c.access.Paths, sensor.PollingEndpoint) {
+		return &errors.ValidationError{Message: fmt.Sprintf("Path %s is not allowed", sensor.PollingEndpoint)}
+	}
+
+	if sensor.PollingOptions.Scale != 0 && sensor.DataType != DataTypeInt && sensor.DataType != DataTypeFloat {
+		return &errors.ValidationError{Message: "Scale is only allowed for int and float data types"}
+	}
+
+	return validateExtractor(sensor.PollingOptions)
+}
+
+func validateExtractor(options PollingOptions) error {
+	switch options.Extractor {
+	case "", ExtractorBody:
+	case ExtractorJson:
+		if _, err := jsonpath.Parse(options.Expression); err != nil {
+			return &errors.ValidationError{Message: fmt.Sprintf("Invalid JSON path: %s", err.Error())}
+		}
+	case ExtractorRegex:
+		if _, err := regexp.Compile(options.Expression); err != nil {
+			return &errors.ValidationError{Message: fmt.Sprintf("Invalid regular expression: %s", err.Error())}
+		}
+	default:
+		return &errors.ValidationError{Message: "Extractor must be one of body, json or regex"}
+	}
+
+	return nil
+}
+
+func validateDataType(sensor CreateSensorRequest) error {
+	if sensor.DataType == DataTypeEnum {
+		if len(sensor.AllowedValues) == 0 {
+			return &errors.ValidationError{Message: "Allowed values are required for enum sensors"}
+		}
+
+		seen := make(map[string]bool)
+		for _, allowed := range sensor.AllowedValues {
+			if allowed == "" || seen[allowed] {
+				return &errors.ValidationError{Message: "Allowed values must be unique and not empty"}
+			}
+			seen[allowed] = true
+		}
+	} else if len(sensor.AllowedValues) > 0 {
+		return &errors.ValidationError{Message: "Allowed values are only allowed for enum sensors"}
+	}
+
+	if len(sensor.Schema) > 0 && sensor.DataType != DataTypeJson {
+		return &errors.ValidationError{Message: "Schema is only allowed for json sensors"}
+	}
+
+	fields := make([]string, 0, len(sensor.Schema))
+	for field := range sensor.Schema {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	for _, field := range fields {
+		switch sensor.Schema[field] {
+		case DataTypeString, DataTypeInt, DataTypeFloat, DataTypeBool:
+		default:
+			return &errors.ValidationError{Message: fmt.Sprintf("Invalid data type %s for field %s", sensor.Schema[field], field)}
+		}
+	}
+
+	return nil
+}
+
+func validatePipeline(sensor CreateSensorRequest) error {
+	pipeline := sensor.Pipeline
+	if pipeline.IsEmpty() {
+		return nil
+	}
+
+	if sensor.DataType != DataTypeInt && sensor.DataType != DataTypeFloat {
+		return &errors.ValidationError{Message: "Pipeline is only allowed for int and float data types"}
+	}
+
+	if sensor.Type == SensorTypeComputed {
+		return &errors.ValidationError{Message: "Pipeline is not allowed for computed sensors"}
+	}
+
+	if pipeline.ConvertFrom != "" {
+		if _, err := units.Convert(0, pipeline.ConvertFrom, sensor.Unit); err != nil {
+			return &errors.ValidationError{Message: fmt.Sprintf("Invalid unit conversion: %s", err.Error())}
+		}
+	}
+
+	if pipeline.Min != nil && pipeline.Max != nil && *pipeline.Min > *pipeline.Max {
+		return &errors.ValidationError{Message: "Min must not be greater than max"}
+	}
+
+	if pipeline.Precision != nil && (*pipeline.Precision < 0 || *pipeline.Precision > 10) {
+		return &errors.ValidationError{Message: "Precision must be between 0 and 10"}
+	}
+
+	if pipeline.DeadBand < 0 || pipeline.MaxChange < 0 {
+		return &errors.ValidationError{Message: "Dead band and max change must not be negative"}
+	}
+
+	return nil
+}
+
+// dependentSensor returns a computed sensor, whose expression uses the sensor.
+func (c *SensorsController) dependentSensor(deviceId string, sensorId string) (*Sensor, error) {
+	computed, err := c.database.ListComputedSensors()
+	if err != nil {
+		return nil, err
+	}
+
+	key := deviceId + "." + sensorId
+	for i := range computed {
+		inputs, err := computed[i].Inputs()
+		if err != nil {
+			continue
+		}
+		for _, input := range inputs {
+			if input == key {
+				return &computed[i], nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// validateComputedSensor checks that the expression of a computed sensor only
+// references existing sensors and does not introduce a dependency cycle.
+func (c *SensorsController) validateComputedSensor(deviceId string, sensorId string, request CreateSensorRequest) error {
+	if request.Type != SensorTypeComputed {
+		return nil
+	}
+
+	if request.DataType != DataTypeInt && request.DataType != DataTypeFloat {
+		return &errors.ValidationError{Message: "Computed sensors require data type int or float"}
+	}
+
+	if strings.TrimSpace(request.Expression) == "" {
+		return &errors.ValidationError{Message: "Expression is required"}
+	}
+
+	computed := &Sensor{ID: sensorId, DeviceID: deviceId, Expression: request.Expression}
+	inputs, err := computed.Inputs()
+	if err != nil {
+		return &errors.ValidationError{Message: fmt.Sprintf("Invalid expression: %s", err.Error())}
+	}
+
+	for _, input := range inputs {
+		parts := strings.Split(input, ".")
+		if len(parts) != 2 {
+			return &errors.ValidationError{Message: fmt.Sprintf("Invalid sensor %s in expression - Should consist of deviceId.sensorId", input)}
+		}
+		if input == computed.Key() {
+			return &errors.ValidationError{Message: "Expression must not reference the sensor itself"}
+		}
+		if _, err := c.database.GetSensor(parts[0], parts[1]); err != nil {
+			return &errors.ValidationError{Message: fmt.Sprintf("Sensor %s in expression does not exist", input)}
+		}
+	}
+
+	existing, err := c.database.ListComputedSensors()
+	if err != nil {
+		return err
+	}
+
+	sensors := []Sensor{*computed}
+	for _, s := range existing {
+		if s.Key() != computed.Key() {
+			sensors = append(sensors, s)
+		}
+	}
+	if CyclicSensors(sensors)[computed.Key()] {
+		return &errors.ValidationError{Message: "Expression creates a dependency cycle"}
+	}
+
+	return nil
+}
+
+func validatePingPolling(sensor CreateSensorRequest) error {
+	options := sensor.PollingOptions
+	if options.Count < 0 || options.Count > 100 {
+		return &errors.ValidationError{Message: "Count must be between 1 and 100, or 0 for the default"}
+	}
+
+	if options.TimeoutSeconds < 0 {
+		return &errors.ValidationError{Message: "Timeout must not be negative"}
+	}
+
+	switch options.Metric {
+	case "":
+		if sensor.DataType != DataTypeBool && sensor.DataType != DataTypeFloat {
+			return &errors.ValidationError{Message: "Ping polling requires data type bool or float"}
+		}
+	case PingMetricReachable:
+		if sensor.DataType != DataTypeBool {
+			return &errors.ValidationError{Message: "Metric reachable requires data type bool"}
+		}
+	case PingMetricRtt, PingMetricPacketLoss:
+		if sensor.DataType != DataTypeFloat {
+			return &errors.ValidationError{Message: fmt.Sprintf("Metric %s requires data type float", options.Metric)}
+		}
+	default:
+		return &errors.ValidationError{Message: "Metric must be one of reachable, rtt or packet_loss"}
+	}
+
+	return nil
+}
+
+func validateHostPort(sensor CreateSensorRequest) error {
+	if sensor.PollingOptions.TimeoutSeconds < 0 {
+		return &errors.ValidationError{Message: "Timeout must not be negative"}
+	}
+
+	host, port, err := net.SplitHostPort(sensor.PollingEndpoint)
+	if err != nil || host == "" || strings.Contains(sensor.PollingEndpoint, "/") {
+		return &errors.ValidationError{Message: "Polling endpoint must be in the format host:port"}
+	}
+
+	number, err := strconv.Atoi(port)
+	if err != nil || number < 1 || number > 65535 {
+		return &errors.ValidationError{Message: "Port must be a number between 1 and 65535"}
+	}
+
+	return nil
+}
+
+func contains(s []string, e string) bool {
+	for _, a := range s {
+		if a == e {
+			return true
+		}
+	}
+	return false
+}
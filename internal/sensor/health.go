@@ -0,0 +1,28 @@
+package sensor
+
+import "time"
+
+type HealthStatus string
+
+const (
+	HealthStatusOk          HealthStatus = "ok"
+	HealthStatusUnavailable HealthStatus = "unavailable"
+)
+
+// SensorHealth describes how reliably values are received for a sensor.
+type SensorHealth struct {
+	DeviceID            string       `json:"device_id" gorm:"primaryKey"`
+	SensorID            string       `json:"sensor_id" gorm:"primaryKey"`
+	Status              HealthStatus `json:"status"`
+	LastSuccess         *time.Time   `json:"last_success,omitempty"`
+	LastError           string       `json:"last_error,omitempty"`
+	LastErrorAt         *time.Time   `json:"last_error_at,omitempty"`
+	ConsecutiveFailures int          `json:"consecutive_failures"`
+	LastValueAt         *time.Time   `json:"last_value_at,omitempty"`
+}
+
+// Available reports whether the sensor is considered available. Sensors
+// without any recorded health are available.
+func (h *SensorHealth) Available() bool {
+	return h == nil || h.Status != HealthStatusUnavailable
+}
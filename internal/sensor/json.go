@@ -0,0 +1,112 @@
+package sensor
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/soerenchrist/go_home/internal/errors"
+	"github.com/soerenchrist/go_home/pkg/jsonpath"
+)
+
+// ValidateJson checks that a value of a json sensor is an object, whose
+// fields match the types declared in the schema of the sensor. Fields of the
+// schema may be missing in the value.
+func (s *Sensor) ValidateJson(v string) error {
+	var data map[string]any
+	if err := json.Unmarshal([]byte(v), &data); err != nil {
+		return &errors.ValidationError{Message: "Sensor value is not a json object"}
+	}
+
+	fields := make([]string, 0, len(s.Schema))
+	for field := range s.Schema {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	for _, field := range fields {
+		value, err := lookupField(data, field)
+		if err != nil {
+			continue
+		}
+		if _, err := formatField(value, s.Schema[field]); err != nil {
+			return &errors.ValidationError{Message: fmt.Sprintf("Field %s is not %s", field, article(s.Schema[field]))}
+		}
+	}
+
+	return nil
+}
+
+// Field reads a field of a json value, e.g. `temp` or `outdoor.temp`. The data
+// type of the field is taken from the schema, or derived from the value if the
+// field is not declared.
+func (s *Sensor) Field(v string, field string) (string, DataType, error) {
+	var data any
+	if err := json.Unmarshal([]byte(v), &data); err != nil {
+		return "", "", fmt.Errorf("value %s is not valid json", v)
+	}
+
+	value, err := lookupField(data, field)
+	if err != nil {
+		return "", "", fmt.Errorf("field %s not found: %v", field, err)
+	}
+
+	dataType, ok := s.Schema[field]
+	if !ok {
+		switch value.(type) {
+		case float64:
+			dataType = DataTypeFloat
+		case bool:
+			dataType = DataTypeBool
+		case string:
+			dataType = DataTypeString
+		default:
+			return "", "", fmt.Errorf("field %s is not a number, bool or string", field)
+		}
+	}
+
+	formatted, err := formatField(value, dataType)
+	if err != nil {
+		return "", "", fmt.Errorf("field %s is not %s", field, article(dataType))
+	}
+	return formatted, dataType, nil
+}
+
+func lookupField(data any, field string) (any, error) {
+	path, err := jsonpath.Parse("$." + field)
+	if err != nil {
+		return nil, err
+	}
+	return path.Lookup(data)
+}
+
+func formatField(value any, dataType DataType) (string, error) {
+	switch dataType {
+	case DataTypeInt:
+		if f, ok := value.(float64); ok && f == math.Trunc(f) {
+			return strconv.FormatInt(int64(f), 10), nil
+		}
+	case DataTypeFloat:
+		if f, ok := value.(float64); ok {
+			return strconv.FormatFloat(f, 'f', -1, 64), nil
+		}
+	case DataTypeBool:
+		if b, ok := value.(bool); ok {
+			return strconv.FormatBool(b), nil
+		}
+	case DataTypeString:
+		if str, ok := value.(string); ok {
+			return str, nil
+		}
+	}
+	return "", fmt.Errorf("unexpected type %T", value)
+}
+
+func article(dataType DataType) string {
+	if dataType == DataTypeInt {
+		return "an int"
+	}
+	return "a " + string(dataType)
+}
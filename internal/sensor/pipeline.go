@@ -0,0 +1,82 @@
+package sensor
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/soerenchrist/go_home/internal/errors"
+	"github.com/soerenchrist/go_home/pkg/units"
+)
+
+// maxOutliers is the number of consecutive outliers, after which the next value
+// is accepted as a step change instead of being rejected as well.
+const maxOutliers = 3
+
+// PipelineOptions describe how raw numeric values are transformed before
+// they are stored. The steps are applied in the order of the fields.
+type PipelineOptions struct {
+	// ConvertFrom is the unit raw values are reported in. They are converted to the unit of the sensor.
+	ConvertFrom string  `json:"convert_from,omitempty"`
+	Scale       float64 `json:"scale,omitempty"`
+	Offset      float64 `json:"offset,omitempty"`
+	// MaxChange rejects values that differ from the current value by more than the given amount.
+	// After 3 consecutive outliers, the next value is accepted as a step change.
+	MaxChange float64  `json:"max_change,omitempty"`
+	Min       *float64 `json:"min,omitempty"`
+	Max       *float64 `json:"max,omitempty"`
+	Precision *int     `json:"precision,omitempty"`
+	// DeadBand drops values that differ from the current value by less than the given amount.
+	DeadBand float64 `json:"dead_band,omitempty"`
+}
+
+func (o PipelineOptions) IsEmpty() bool {
+	return o.ConvertFrom == "" && o.Scale == 0 && o.Offset == 0 && o.MaxChange == 0 &&
+		o.Min == nil && o.Max == nil && o.Precision == nil && o.DeadBand == 0
+}
+
+// ApplyPipeline transforms a raw value of the sensor. current is the last
+// stored value of the sensor, if there is one. Dropped values are reported
+// as *errors.RejectedError. Consecutive outliers are counted on the sensor.
+func (s *Sensor) ApplyPipeline(raw float64, current *float64) (float64, error) {
+	o := s.Pipeline
+	v := raw
+
+	if o.ConvertFrom != "" {
+		converted, err := units.Convert(v, o.ConvertFrom, s.Unit)
+		if err != nil {
+			return 0, err
+		}
+		v = converted
+	}
+
+	if o.Scale != 0 {
+		v *= o.Scale
+	}
+	v += o.Offset
+
+	if o.MaxChange > 0 && current != nil && math.Abs(v-*current) > o.MaxChange && s.Outliers < maxOutliers {
+		s.Outliers++
+		return 0, &errors.RejectedError{Message: fmt.Sprintf("Value %v is an outlier", v)}
+	}
+	s.Outliers = 0
+
+	if o.Min != nil && v < *o.Min {
+		v = *o.Min
+	}
+	if o.Max != nil && v > *o.Max {
+		v = *o.Max
+	}
+
+	if s.DataType == DataTypeInt {
+		v = math.Round(v)
+	} else if o.Precision != nil {
+		factor := math.Pow(10, float64(*o.Precision))
+		v = math.Round(v*factor) / factor
+	}
+
+	if o.DeadBand > 0 && current != nil && math.Abs(v-*current) < o.DeadBand {
+		return 0, &errors.RejectedError{Message: fmt.Sprintf("Value %v is within the dead band", v)}
+	}
+
+	return v, nil
+}
@@ -1,6 +1,13 @@
 package sensor
 
-import "time"
+import (
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/soerenchrist/go_home/pkg/units"
+)
 
 type Sensor struct {
 	ID              string          `json:"id" gorm:"primaryKey"`
@@ -13,19 +20,266 @@ type Sensor struct {
 	PollingInterval int             `json:"polling_interval"`
 	PollingEndpoint string          `json:"polling_endpoint"`
 	PollingStrategy PollingStrategy `json:"polling_strategy"`
+	PollingOptions  PollingOptions  `json:"polling_options" gorm:"serializer:json"`
+	Expression      string          `json:"expression,omitempty"`
+	Pipeline        PipelineOptions `json:"pipeline" gorm:"serializer:json"`
+	AllowedValues   []string        `json:"allowed_values,omitempty" gorm:"serializer:json"`
+	Schema          Schema          `json:"schema,omitempty" gorm:"serializer:json"`
 
 	RetainmentPeriodSeconds int `json:"retainment_period_seconds"`
 
+	// RejectedValues counts the values dropped by the pipeline of the sensor.
+	RejectedValues int `json:"rejected_values"`
+	// Outliers counts the consecutive values rejected by the max change of the pipeline.
+	Outliers int `json:"-"`
+
+	Health *SensorHealth `json:"health,omitempty" gorm:"-"`
+
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
+func (s *Sensor) toRequest() CreateSensorRequest {
+	isActive := s.IsActive
+	pollingOptions := s.PollingOptions
+	if s.PollingOptions.Headers != nil {
+		// the request body is decoded into the headers, so they must not be shared with the sensor
+		pollingOptions.Headers = make(map[string]string, len(s.PollingOptions.Headers))
+		for name, value := range s.PollingOptions.Headers {
+			pollingOptions.Headers[name] = value
+		}
+	}
+	return CreateSensorRequest{
+		Id:                      s.ID,
+		Name:                    s.Name,
+		DataType:                s.DataType,
+		Unit:                    s.Unit,
+		Type:                    s.Type,
+		PollingInterval:         s.PollingInterval,
+		PollingEndpoint:         s.PollingEndpoint,
+		PollingStrategy:         s.PollingStrategy,
+		PollingOptions:          pollingOptions,
+		Expression:              s.Expression,
+		Pipeline:                s.Pipeline,
+		AllowedValues:           s.AllowedValues,
+		Schema:                  s.Schema,
+		RetainmentPeriodSeconds: s.RetainmentPeriodSeconds,
+		IsActive:                &isActive,
+	}
+}
+
+func (s *Sensor) apply(request *CreateSensorRequest) {
+	if request.Type != SensorTypePolling && request.Type != SensorTypeComputed {
+		request.Type = SensorTypeExternal
+	}
+
+	if request.Type == SensorTypePolling && request.PollingStrategy == "" {
+		request.PollingStrategy = PollingStrategyPing
+	}
+
+	if request.RetainmentPeriodSeconds == 0 {
+		request.RetainmentPeriodSeconds = -1
+	}
+
+	s.Name = request.Name
+	s.DataType = request.DataType
+	// Unknown units are kept as they are, their values just cannot be converted
+	s.Unit = request.Unit
+	if unit, ok := units.Lookup(request.Unit); ok {
+		s.Unit = unit.Symbol
+	}
+	s.Type = request.Type
+	s.PollingInterval = request.PollingInterval
+	s.PollingEndpoint = request.PollingEndpoint
+	s.PollingStrategy = request.PollingStrategy
+	s.PollingOptions = request.PollingOptions.withSecretsOf(s.PollingOptions)
+	s.Expression = request.Expression
+	s.Pipeline = request.Pipeline
+	s.AllowedValues = request.AllowedValues
+	s.Schema = request.Schema
+	s.RetainmentPeriodSeconds = request.RetainmentPeriodSeconds
+
+	if request.IsActive != nil {
+		s.IsActive = *request.IsActive
+	}
+}
+
 type PollingStrategy string
 
 const (
 	PollingStrategyPing PollingStrategy = "ping"
+	PollingStrategyHttp PollingStrategy = "http"
+	PollingStrategyTcp  PollingStrategy = "tcp"
+	PollingStrategyTls  PollingStrategy = "tls_cert"
+	PollingStrategyExec PollingStrategy = "exec"
+	PollingStrategyFile PollingStrategy = "file"
 )
 
+type ExtractorType string
+
+const (
+	ExtractorBody  ExtractorType = "body"
+	ExtractorJson  ExtractorType = "json"
+	ExtractorRegex ExtractorType = "regex"
+)
+
+type PingMetric string
+
+const (
+	PingMetricReachable  PingMetric = "reachable"
+	PingMetricRtt        PingMetric = "rtt"
+	PingMetricPacketLoss PingMetric = "packet_loss"
+)
+
+type PollingOptions struct {
+	Method         string            `json:"method,omitempty"`
+	Headers        map[string]string `json:"headers,omitempty"`
+	Body           string            `json:"body,omitempty"`
+	TimeoutSeconds int               `json:"timeout_seconds,omitempty"`
+	Extractor      ExtractorType     `json:"extractor,omitempty"`
+	Expression     string            `json:"expression,omitempty"`
+	Metric         PingMetric        `json:"metric,omitempty"`
+	Count          int               `json:"count,omitempty"`
+	Privileged     bool              `json:"privileged,omitempty"`
+	Args           []string          `json:"args,omitempty"`
+	Scale          float64           `json:"scale,omitempty"`
+}
+
+// LocalAccess restricts the local programs and files, that polling sensors may use.
+type LocalAccess struct {
+	// Commands are the complete command lines exec sensors may run.
+	Commands []string
+	// Paths are the path prefixes file sensors may read.
+	Paths []string
+}
+
+// DefaultAllowedPaths is used, if no path prefixes are configured.
+var DefaultAllowedPaths = []string{"/sys/"}
+
+// IsPathAllowed reports whether the cleaned path is located below one of the
+// allowed path prefixes.
+func IsPathAllowed(allowedPaths []string, path string) bool {
+	path = filepath.Clean(path)
+	for _, allowed := range allowedPaths {
+		prefix := filepath.Clean(allowed)
+		if path == prefix || strings.HasPrefix(path, strings.TrimSuffix(prefix, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// CommandLine joins the program and the arguments of an exec polling sensor.
+func CommandLine(program string, args []string) string {
+	return strings.Join(append([]string{program}, args...), " ")
+}
+
+// IsCommandAllowed reports whether the program may run with exactly these
+// arguments. Allowed commands are complete command lines, so clients cannot
+// pass their own arguments to an allowed program.
+func IsCommandAllowed(allowedCommands []string, program string, args []string) bool {
+	for _, allowed := range allowedCommands {
+		fields := splitCommandLine(allowed)
+		if len(fields) == 0 || fields[0] != program || len(fields)-1 != len(args) {
+			continue
+		}
+
+		matches := true
+		for i, arg := range args {
+			if fields[i+1] != arg {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return true
+		}
+	}
+	return false
+}
+
+// splitCommandLine splits at whitespace, except inside single or double quotes.
+func splitCommandLine(line string) []string {
+	fields := make([]string, 0)
+	var field strings.Builder
+	inField := false
+	var quote rune
+	for _, r := range line {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			field.WriteRune(r)
+		case r == '\'' || r == '"':
+			quote = r
+			inField = true
+		case unicode.IsSpace(r):
+			if inField {
+				fields = append(fields, field.String())
+				field.Reset()
+				inField = false
+			}
+		default:
+			field.WriteRune(r)
+			inField = true
+		}
+	}
+	if inField {
+		fields = append(fields, field.String())
+	}
+	return fields
+}
+
+// RedactedHeader replaces the values of sensitive polling headers in responses.
+const RedactedHeader = "********"
+
+var sensitiveHeaderParts = []string{"auth", "cookie", "token", "secret", "key", "password"}
+
+func isSensitiveHeader(name string) bool {
+	name = strings.ToLower(name)
+	for _, part := range sensitiveHeaderParts {
+		if strings.Contains(name, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// Redacted returns a copy of the sensor, that hides the values of sensitive
+// polling headers, so they can be returned by the api.
+func (s *Sensor) Redacted() *Sensor {
+	redacted := *s
+	if len(s.PollingOptions.Headers) == 0 {
+		return &redacted
+	}
+
+	redacted.PollingOptions.Headers = make(map[string]string, len(s.PollingOptions.Headers))
+	for name, value := range s.PollingOptions.Headers {
+		if isSensitiveHeader(name) {
+			value = RedactedHeader
+		}
+		redacted.PollingOptions.Headers[name] = value
+	}
+	return &redacted
+}
+
+// withSecretsOf keeps the stored values of headers, that were sent back redacted.
+func (o PollingOptions) withSecretsOf(stored PollingOptions) PollingOptions {
+	if len(o.Headers) == 0 {
+		return o
+	}
+
+	headers := make(map[string]string, len(o.Headers))
+	for name, value := range o.Headers {
+		if storedValue, ok := stored.Headers[name]; ok && value == RedactedHeader {
+			value = storedValue
+		}
+		headers[name] = value
+	}
+	o.Headers = headers
+	return o
+}
+
 type DataType string
 
 const (
@@ -33,13 +287,19 @@ const (
 	DataTypeInt    DataType = "int"
 	DataTypeFloat  DataType = "float"
 	DataTypeBool   DataType = "bool"
+	DataTypeEnum   DataType = "enum"
+	DataTypeJson   DataType = "json"
 )
 
+// Schema declares the data types of the fields of json sensor values.
+type Schema map[string]DataType
+
 type SensorType string
 
 const (
 	SensorTypeExternal SensorType = "external"
 	SensorTypePolling  SensorType = "polling"
+	SensorTypeComputed SensorType = "computed"
 )
 
 type CreateSensorRequest struct {
@@ -51,5 +311,11 @@ type CreateSensorRequest struct {
 	PollingInterval         int             `json:"polling_interval"`
 	PollingEndpoint         string          `json:"polling_endpoint"`
 	PollingStrategy         PollingStrategy `json:"polling_strategy"`
+	PollingOptions          PollingOptions  `json:"polling_options"`
+	Expression              string          `json:"expression"`
+	Pipeline                PipelineOptions `json:"pipeline"`
+	AllowedValues           []string        `json:"allowed_values"`
+	Schema                  Schema          `json:"schema"`
 	RetainmentPeriodSeconds int             `json:"retainment_period_seconds"`
+	IsActive                *bool           `json:"is_active"`
 }
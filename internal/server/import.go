@@ -0,0 +1,44 @@
+package server
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/rs/zerolog/log"
+	"github.com/soerenchrist/go_home/internal/config"
+	"github.com/soerenchrist/go_home/internal/db"
+	"github.com/soerenchrist/go_home/internal/value"
+)
+
+// Import backfills the values of a sensor from a csv file into the configured database.
+func Import(deviceId, sensorId, path string, options value.ImportOptions) error {
+	config := config.GetConfig()
+	setupLogging(config)
+
+	database, err := db.NewDevicesDatabase(openDatabase(config.GetString("database.path")))
+	if err != nil {
+		return err
+	}
+
+	s, err := database.GetSensor(deviceId, sensorId)
+	if err != nil {
+		return fmt.Errorf("sensor %s.%s not found", deviceId, sensorId)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	result, err := value.NewImporter(database).Import(s, file, options)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range result.Errors {
+		log.Warn().Int("line", e.Line).Msg(e.Error)
+	}
+	log.Info().Int("imported", result.Imported).Int("invalid", result.Invalid).Msg("Imported values")
+	return nil
+}
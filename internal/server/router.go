@@ -7,16 +7,37 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/rs/zerolog/log"
 	frontend "github.com/soerenchrist/go_home/internal/app"
+	"github.com/soerenchrist/go_home/internal/background"
 	"github.com/soerenchrist/go_home/internal/command"
 	"github.com/soerenchrist/go_home/internal/db"
 	"github.com/soerenchrist/go_home/internal/device"
+	"github.com/soerenchrist/go_home/internal/influx"
 	"github.com/soerenchrist/go_home/internal/rules"
+	"github.com/soerenchrist/go_home/internal/scene"
 	"github.com/soerenchrist/go_home/internal/sensor"
 	"github.com/soerenchrist/go_home/internal/value"
 	"github.com/soerenchrist/go_home/pkg/output"
+	"github.com/soerenchrist/go_home/pkg/units"
 )
 
-func NewRouter(database db.Database, outputBindings *output.OutputBindingsManager) *gin.Engine {
+// RouterOptions configure the optional parts of the router. Background jobs,
+// that are nil, are reported as not running.
+type RouterOptions struct {
+	Poller   *background.Poller
+	Cleanup  *background.Cleanup
+	Computed *background.ComputedSensors
+	// LocalAccess restricts exec and file polling sensors.
+	LocalAccess sensor.LocalAccess
+	// Influx may be nil, if line protocol writes should only use the default mapping.
+	Influx *influx.Config
+}
+
+// NewRouter creates the router of the api and frontend.
+func NewRouter(database db.Database, outputBindings *output.OutputBindingsManager, dispatcher *command.Dispatcher, options RouterOptions) *gin.Engine {
+	poller := options.Poller
+	cleanup := options.Cleanup
+	influxConfig := options.Influx
+
 	router := gin.New()
 	router.Use(DefaultStructuredLogger())
 	router.Use(gin.Recovery())
@@ -24,40 +45,80 @@ func NewRouter(database db.Database, outputBindings *output.OutputBindingsManage
 	app := frontend.NewApp(router, database)
 	app.ServeHtml()
 
-	devicesController := device.NewController(database)
-	sensorsController := sensor.NewController(database)
+	var sensorListeners sensor.ChangeListeners
+	var deviceListeners device.ChangeListeners
+	if poller != nil {
+		sensorListeners = append(sensorListeners, poller)
+		deviceListeners = append(deviceListeners, poller)
+	}
+	if options.Computed != nil {
+		sensorListeners = append(sensorListeners, options.Computed)
+		deviceListeners = append(deviceListeners, options.Computed)
+	}
+
+	devicesController := device.NewController(database, deviceListeners)
+	sensorsController := sensor.NewController(database, sensorListeners, options.LocalAccess)
 	sensorValuesController := value.NewController(database, outputBindings)
-	commandsController := command.NewController(database)
+	if influxConfig == nil {
+		influxConfig = &influx.Config{}
+	}
+	influxController := influx.NewController(database, sensorValuesController, *influxConfig)
+	commandsController := command.NewController(database, dispatcher)
 	rulesController := rules.NewController(database)
+	scenesController := scene.NewController(database, dispatcher)
 
 	api := router.Group("/api")
 	v1 := api.Group("/v1")
 
 	v1.GET("/health", health)
+	v1.GET("/health/sensors", sensorsController.GetSensorHealth)
+	v1.GET("/polling", pollingStats(poller))
+	v1.GET("/cleanup", cleanupStats(cleanup))
+	v1.GET("/units", listUnits)
 
 	v1.GET("/devices", devicesController.GetDevices)
 	v1.GET("/devices/:deviceId", devicesController.GetDevice)
 	v1.POST("/devices", devicesController.PostDevice)
+	v1.PUT("/devices/:deviceId", devicesController.PutDevice)
 	v1.DELETE("/devices/:deviceId", devicesController.DeleteDevice)
 
 	v1.GET("/devices/:deviceId/sensors", sensorsController.GetSensors)
 	v1.POST("/devices/:deviceId/sensors", sensorsController.PostSensor)
 	v1.GET("/devices/:deviceId/sensors/:sensorId", sensorsController.GetSensor)
+	v1.PUT("/devices/:deviceId/sensors/:sensorId", sensorsController.PutSensor)
+	v1.PATCH("/devices/:deviceId/sensors/:sensorId", sensorsController.PatchSensor)
 	v1.DELETE("/devices/:deviceId/sensors/:sensorId", sensorsController.DeleteSensor)
 
 	v1.POST("/devices/:deviceId/sensors/:sensorId/values", sensorValuesController.PostSensorValue)
 	v1.GET("/devices/:deviceId/sensors/:sensorId/values", sensorValuesController.GetSensorValues)
+	v1.GET("/devices/:deviceId/sensors/:sensorId/values/export", sensorValuesController.ExportSensorValues)
+	v1.POST("/devices/:deviceId/sensors/:sensorId/values/import", sensorValuesController.ImportSensorValues)
+	v1.GET("/devices/:deviceId/sensors/:sensorId/stats", sensorValuesController.GetSensorValueStats)
 	v1.GET("/devices/:deviceId/sensors/:sensorId/current", sensorValuesController.GetCurrentSensorValue)
+	v1.POST("/values/batch", sensorValuesController.PostSensorValues)
+	v1.GET("/values/export", sensorValuesController.ExportJoinedSensorValues)
+	v1.POST("/write", influxController.Write)
 
 	v1.GET("/devices/:deviceId/commands", commandsController.GetCommands)
 	v1.GET("/devices/:deviceId/commands/:commandId", commandsController.GetCommand)
 	v1.POST("/devices/:deviceId/commands", commandsController.PostCommand)
+	v1.PUT("/devices/:deviceId/commands/:commandId", commandsController.PutCommand)
+	v1.PATCH("/devices/:deviceId/commands/:commandId", commandsController.PatchCommand)
 	v1.POST("/devices/:deviceId/commands/:commandId/invoke", commandsController.InvokeCommand)
 	v1.DELETE("/devices/:deviceId/commands/:commandId", commandsController.DeleteCommand)
 
+	v1.GET("/commands/queue", commandsController.GetQueue)
+
 	v1.GET("/rules", rulesController.ListRules)
 	v1.POST("/rules", rulesController.PostRule)
 
+	v1.GET("/scenes", scenesController.ListScenes)
+	v1.POST("/scenes", scenesController.PostScene)
+	v1.GET("/scenes/:sceneId", scenesController.GetScene)
+	v1.PUT("/scenes/:sceneId", scenesController.PutScene)
+	v1.DELETE("/scenes/:sceneId", scenesController.DeleteScene)
+	v1.POST("/scenes/:sceneId/activate", scenesController.ActivateScene)
+
 	router.POST("/echo", echo)
 	router.GET("/websocket", websocketPage)
 	return router
@@ -80,6 +141,30 @@ func echo(context *gin.Context) {
 	context.Writer.Write(body)
 }
 
+func listUnits(context *gin.Context) {
+	context.JSON(200, units.List())
+}
+
+func pollingStats(poller *background.Poller) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		if poller == nil {
+			context.JSON(503, gin.H{"error": "Poller is not running"})
+			return
+		}
+		context.JSON(200, poller.Stats())
+	}
+}
+
+func cleanupStats(cleanup *background.Cleanup) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		if cleanup == nil {
+			context.JSON(503, gin.H{"error": "Cleanup is not running"})
+			return
+		}
+		context.JSON(200, cleanup.Stats())
+	}
+}
+
 func health(context *gin.Context) {
 	context.JSON(200, gin.H{
 		"status": "ok",
@@ -1,18 +1,27 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/soerenchrist/go_home/internal/background"
+	"github.com/soerenchrist/go_home/internal/command"
 	"github.com/soerenchrist/go_home/internal/config"
 	"github.com/soerenchrist/go_home/internal/db"
+	"github.com/soerenchrist/go_home/internal/influx"
 	"github.com/soerenchrist/go_home/internal/mqtt"
 	"github.com/soerenchrist/go_home/internal/rules/evaluation"
+	"github.com/soerenchrist/go_home/internal/sensor"
+	"github.com/soerenchrist/go_home/internal/value"
 	"github.com/soerenchrist/go_home/pkg/output"
 	"github.com/spf13/viper"
 	"golang.org/x/sync/errgroup"
@@ -21,10 +30,16 @@ import (
 )
 
 var (
-	g errgroup.Group
+	g *errgroup.Group
 )
 
 func Init() {
+	signalCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// A failing server cancels ctx as well, so that the remaining ones shut down.
+	var ctx context.Context
+	g, ctx = errgroup.WithContext(signalCtx)
+
 	config := config.GetConfig()
 	setupLogging(config)
 	databasePath := config.GetString("database.path")
@@ -41,13 +56,28 @@ func Init() {
 		database.SeedDatabase()
 	}
 	outputBindings := output.NewManager()
-	go background.CleanupExpiredSensorValues(sqlite)
-	addRulesEngine(database, outputBindings)
+	cleanup := startCleanup(ctx, config, database)
+	dispatcher := startCommandDispatcher(config, database)
+	addRulesEngine(database, outputBindings, dispatcher)
+	computed := addComputedSensors(ctx, database, outputBindings)
+	addRollups(ctx, config, database)
+	access := localAccess(config)
+	poller := newPoller(ctx, config, database, outputBindings, access)
+	g.Go(func() error {
+		return poller.Run(ctx)
+	})
 
-	runHomeServer(config, database, outputBindings)
-	runMqttBridge(config, outputBindings)
+	runHomeServer(ctx, config, database, outputBindings, dispatcher, RouterOptions{
+		Poller:      poller,
+		Cleanup:     cleanup,
+		Computed:    computed,
+		LocalAccess: access,
+	})
+	runMqttBridge(ctx, config, outputBindings)
 
-	if err := g.Wait(); err != nil {
+	err = g.Wait()
+	dispatcher.Stop()
+	if err != nil {
 		log.Fatal().Err(err).Msg("Failed to start")
 	}
 }
@@ -66,8 +96,14 @@ func setupLogging(config *viper.Viper) {
 	}
 }
 
-func runHomeServer(config *viper.Viper, database db.Database, outputBindings *output.OutputBindingsManager) {
-	r := NewRouter(database, outputBindings)
+func runHomeServer(ctx context.Context, config *viper.Viper, database db.Database, outputBindings *output.OutputBindingsManager, dispatcher *command.Dispatcher, options RouterOptions) {
+	var influxConfig influx.Config
+	if err := config.UnmarshalKey("influx", &influxConfig); err != nil {
+		log.Fatal().Err(err).Msg("Invalid influx configuration")
+	}
+	options.Influx = &influxConfig
+
+	r := NewRouter(database, outputBindings, dispatcher, options)
 	addWebsocket(outputBindings, r)
 
 	port := config.GetString("server.port")
@@ -84,11 +120,11 @@ func runHomeServer(config *viper.Viper, database db.Database, outputBindings *ou
 
 	g.Go(func() error {
 		log.Info().Str("address", addr).Msg("Starting home server")
-		return server.ListenAndServe()
+		return serve(ctx, server)
 	})
 }
 
-func runMqttBridge(config *viper.Viper, outputBindings *output.OutputBindingsManager) {
+func runMqttBridge(ctx context.Context, config *viper.Viper, outputBindings *output.OutputBindingsManager) {
 	router, err := addMqttBridge(config)
 	if err != nil {
 		log.Warn().Err(err)
@@ -109,18 +145,118 @@ func runMqttBridge(config *viper.Viper, outputBindings *output.OutputBindingsMan
 
 	g.Go(func() error {
 		log.Info().Str("address", addr).Msg("Starting MQTT bridge")
-		return server.ListenAndServe()
+		return serve(ctx, server)
+	})
+}
+
+// serve runs the server until ctx is cancelled and then shuts it down gracefully.
+func serve(ctx context.Context, server *http.Server) error {
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func startCommandDispatcher(config *viper.Viper, database db.Database) *command.Dispatcher {
+	workers := config.GetInt("commands.workers")
+	if workers == 0 {
+		workers = 4
+	}
+	queueSize := config.GetInt("commands.queue_size")
+	if queueSize == 0 {
+		queueSize = 100
+	}
+
+	dispatcher := command.NewDispatcher(database, workers, queueSize)
+	dispatcher.Start()
+	return dispatcher
+}
+
+func localAccess(config *viper.Viper) sensor.LocalAccess {
+	allowedPaths := config.GetStringSlice("polling.file.allowed_paths")
+	if len(allowedPaths) == 0 {
+		allowedPaths = sensor.DefaultAllowedPaths
+	}
+
+	return sensor.LocalAccess{
+		Commands: config.GetStringSlice("polling.exec.allowed_commands"),
+		Paths:    allowedPaths,
+	}
+}
+
+func newPoller(ctx context.Context, config *viper.Viper, database db.Database, outputBindings *output.OutputBindingsManager, access sensor.LocalAccess) *background.Poller {
+	workers := config.GetInt("polling.workers")
+	if workers == 0 {
+		workers = 4
+	}
+	unavailableAfter := config.GetInt("polling.unavailable_after")
+	if unavailableAfter == 0 {
+		unavailableAfter = 3
+	}
+
+	health := background.NewHealthTracker(database, outputBindings, unavailableAfter)
+	outputBindings.Register(health)
+	g.Go(func() error {
+		return health.Run(ctx)
 	})
+
+	return background.NewPoller(database, outputBindings, health, workers, access)
 }
 
-func addRulesEngine(database db.Database, outputBindings *output.OutputBindingsManager) {
-	rulesEngine := evaluation.NewRulesEngine(database)
+func addRulesEngine(database db.Database, outputBindings *output.OutputBindingsManager, dispatcher *command.Dispatcher) {
+	rulesEngine := evaluation.NewRulesEngine(database, dispatcher)
 
 	rulesOutput := output.NewChannelOutput()
 	outputBindings.Register(rulesOutput)
 
 	go rulesEngine.ListenForValues(rulesOutput)
-	go background.PollSensorValues(database, outputBindings)
+}
+
+func addComputedSensors(ctx context.Context, database db.Database, outputBindings *output.OutputBindingsManager) *background.ComputedSensors {
+	computedSensors := background.NewComputedSensors(database, outputBindings)
+	outputBindings.Register(computedSensors)
+
+	g.Go(func() error {
+		return computedSensors.Run(ctx)
+	})
+	return computedSensors
+}
+
+func startCleanup(ctx context.Context, config *viper.Viper, database db.Database) *background.Cleanup {
+	interval := config.GetDuration("cleanup.interval")
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	batchSize := config.GetInt("cleanup.batch_size")
+
+	cleanup := background.NewCleanup(database, interval, batchSize)
+	g.Go(func() error {
+		return cleanup.Run(ctx)
+	})
+	return cleanup
+}
+
+func addRollups(ctx context.Context, config *viper.Viper, database db.Database) {
+	interval := config.GetDuration("rollups.interval")
+	if interval <= 0 {
+		interval = 5 * time.Minute
+	}
+	retention := map[string]time.Duration{
+		value.TierHourly.Name: config.GetDuration("rollups.hourly.retention"),
+		value.TierDaily.Name:  config.GetDuration("rollups.daily.retention"),
+	}
+
+	rollups := background.NewRollups(database, interval, retention)
+	g.Go(func() error {
+		return rollups.Run(ctx)
+	})
 }
 
 func addWebsocket(outputBindings *output.OutputBindingsManager, router *gin.Engine) {
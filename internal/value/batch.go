@@ -0,0 +1,342 @@
+package value
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"github.com/soerenchrist/go_home/internal/errors"
+	"github.com/soerenchrist/go_home/internal/sensor"
+	"github.com/soerenchrist/go_home/internal/util"
+)
+
+// maxBatchSize limits the number of values in a single batch request.
+const maxBatchSize = 10000
+
+type BatchSensorValueRequest struct {
+	DeviceID  string `json:"device_id"`
+	SensorID  string `json:"sensor_id"`
+	Value     string `json:"value"`
+	Timestamp string `json:"timestamp"`
+}
+
+// UnmarshalJSON accepts values as strings or as plain json, like AddSensorValueRequest.
+func (r *BatchSensorValueRequest) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		DeviceID  string          `json:"device_id"`
+		SensorID  string          `json:"sensor_id"`
+		Value     json.RawMessage `json:"value"`
+		Timestamp string          `json:"timestamp"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	r.DeviceID = raw.DeviceID
+	r.SensorID = raw.SensorID
+	r.Timestamp = raw.Timestamp
+	value, err := decodeValue(raw.Value)
+	r.Value = value
+	return err
+}
+
+type BatchStatus string
+
+const (
+	BatchStatusCreated  BatchStatus = "created"
+	BatchStatusRejected BatchStatus = "rejected"
+	BatchStatusInvalid  BatchStatus = "invalid"
+)
+
+type BatchResult struct {
+	Index    int          `json:"index"`
+	DeviceID string       `json:"device_id"`
+	SensorID string       `json:"sensor_id"`
+	Status   BatchStatus  `json:"status"`
+	Error    string       `json:"error,omitempty"`
+	Value    *SensorValue `json:"value,omitempty"`
+}
+
+// RejectedValues counts the values of a sensor, that were dropped by the pipeline.
+// Outliers is the number of consecutive outliers of the sensor after the batch.
+type RejectedValues struct {
+	DeviceID string
+	SensorID string
+	Count    int
+	Outliers int
+}
+
+type BatchResponse struct {
+	Created  int           `json:"created"`
+	Rejected int           `json:"rejected"`
+	Invalid  int           `json:"invalid"`
+	Results  []BatchResult `json:"results"`
+}
+
+// PostSensorValues stores many values of arbitrary sensors at once. The body is
+// either a json array or, with content type application/x-ndjson, one json
+// object per line.
+func (c *SensorValuesController) PostSensorValues(context *gin.Context) {
+	requests, err := readBatch(context.ContentType(), context.Request.Body)
+	if err != nil {
+		context.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	if len(requests) > maxBatchSize {
+		context.JSON(400, gin.H{"error": fmt.Sprintf("Too many values, at most %d are allowed per request", maxBatchSize)})
+		return
+	}
+
+	response, err := c.AddSensorValues(requests)
+	if err != nil {
+		context.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	context.JSON(200, response)
+}
+
+// AddSensorValues validates every value on its own and runs it through the
+// pipeline of its sensor, like PostSensorValue does. All valid values are
+// inserted in a single transaction, together with the rejected counters, and
+// pushed to the output bindings in timestamp order.
+func (c *SensorValuesController) AddSensorValues(requests []BatchSensorValueRequest) (*BatchResponse, error) {
+	results := make([]BatchResult, len(requests))
+	pending := make([]int, 0, len(requests))
+	sensors := make(map[string]*sensor.Sensor)
+	timestamps := make([]time.Time, len(requests))
+
+	for i, request := range requests {
+		results[i] = BatchResult{Index: i, DeviceID: request.DeviceID, SensorID: request.SensorID}
+
+		s, err := c.batchSensor(sensors, request.DeviceID, request.SensorID)
+		if err == nil {
+			err = c.validateBatchValue(s, &request)
+		}
+		if err != nil {
+			results[i].Status = BatchStatusInvalid
+			results[i].Error = err.Error()
+			continue
+		}
+
+		timestamps[i], _ = time.Parse(time.RFC3339Nano, request.Timestamp)
+		pending = append(pending, i)
+	}
+
+	// The pipeline sees values in timestamp order, as if they were sent one by one
+	sort.SliceStable(pending, func(a, b int) bool {
+		return timestamps[pending[a]].Before(timestamps[pending[b]])
+	})
+
+	pipelineDatabase := &batchPipelineDatabase{
+		PipelineDatabase: c.database,
+		latest:           make(map[string]*SensorValue),
+		sensors:          sensors,
+		rejected:         make([]RejectedValues, 0),
+	}
+	values := make([]*SensorValue, 0, len(pending))
+	for _, i := range pending {
+		s := sensors[requests[i].DeviceID+"."+requests[i].SensorID]
+
+		v, err := Ingest(pipelineDatabase, s, requests[i].Value)
+		if err != nil {
+			if _, isRejected := err.(*errors.RejectedError); isRejected {
+				results[i].Status = BatchStatusRejected
+			} else {
+				results[i].Status = BatchStatusInvalid
+			}
+			results[i].Error = err.Error()
+			continue
+		}
+
+		sensorValue := NewSensorValue(s, v, timestamps[i])
+		pipelineDatabase.latest[s.Key()] = sensorValue
+		values = append(values, sensorValue)
+		results[i].Status = BatchStatusCreated
+		results[i].Value = sensorValue
+	}
+
+	if err := c.database.AddSensorValueBatch(values, pipelineDatabase.rejected); err != nil {
+		return nil, err
+	}
+
+	c.rollupLateBatch(sensors, values)
+
+	for _, v := range values {
+		c.outputBindings.Push(v.ToBindingValue())
+	}
+
+	response := &BatchResponse{Results: results}
+	for _, result := range results {
+		switch result.Status {
+		case BatchStatusCreated:
+			response.Created++
+		case BatchStatusRejected:
+			response.Rejected++
+		default:
+			response.Invalid++
+		}
+	}
+
+	return response, nil
+}
+
+// rollupLateBatch summarizes the rolled up buckets again, that received values
+// of the batch. The values are stored already, so failures are only logged.
+func (c *SensorValuesController) rollupLateBatch(sensors map[string]*sensor.Sensor, values []*SensorValue) {
+	ranges := make(map[string][2]time.Time)
+	for _, v := range values {
+		key := v.DeviceID + "." + v.SensorID
+		r, ok := ranges[key]
+		if !ok || v.Timestamp.Before(r[0]) {
+			r[0] = v.Timestamp
+		}
+		if !ok || v.Timestamp.After(r[1]) {
+			r[1] = v.Timestamp
+		}
+		ranges[key] = r
+	}
+
+	for key, r := range ranges {
+		s := sensors[key]
+		if err := rollupLateValues(c.database, s, r[0], r[1]); err != nil {
+			log.Error().Err(err).Str("device_id", s.DeviceID).Str("sensor_id", s.ID).Msg("Failed to roll up late values")
+		}
+	}
+}
+
+func (c *SensorValuesController) batchSensor(sensors map[string]*sensor.Sensor, deviceId string, sensorId string) (*sensor.Sensor, error) {
+	key := deviceId + "." + sensorId
+	if s, ok := sensors[key]; ok {
+		return s, nil
+	}
+
+	s, err := c.database.GetSensor(deviceId, sensorId)
+	if err != nil {
+		return nil, &errors.NotFoundError{Message: "Sensor not found"}
+	}
+	sensors[key] = s
+	return s, nil
+}
+
+func (c *SensorValuesController) validateBatchValue(s *sensor.Sensor, request *BatchSensorValueRequest) error {
+	if s.IsComputed() {
+		return &errors.ValidationError{Message: "Values of computed sensors cannot be set"}
+	}
+
+	single := AddSensorValueRequest{Value: request.Value, Timestamp: request.Timestamp}
+	if err := c.validateSensorData(s, &single); err != nil {
+		return err
+	}
+
+	if request.Timestamp == "" {
+		request.Timestamp = util.GetTimestamp()
+	}
+	return nil
+}
+
+func readBatch(contentType string, body io.Reader) ([]BatchSensorValueRequest, error) {
+	requests := make([]BatchSensorValueRequest, 0)
+
+	if contentType != "application/x-ndjson" && contentType != "application/ndjson" {
+		return readBatchArray(body)
+	}
+
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		var request BatchSensorValueRequest
+		if err := json.Unmarshal([]byte(text), &request); err != nil {
+			return nil, fmt.Errorf("Invalid batch: line %d: %s", line, err.Error())
+		}
+		requests = append(requests, request)
+		if len(requests) > maxBatchSize {
+			break
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Invalid batch: %s", err.Error())
+	}
+	return requests, nil
+}
+
+// readBatchArray decodes the elements of a json array one by one, so that
+// oversized batches are refused before the whole body is read.
+func readBatchArray(body io.Reader) ([]BatchSensorValueRequest, error) {
+	requests := make([]BatchSensorValueRequest, 0)
+	decoder := json.NewDecoder(body)
+
+	if token, err := decoder.Token(); err != nil || token != json.Delim('[') {
+		return nil, fmt.Errorf("Invalid batch: expected a json array")
+	}
+
+	for decoder.More() {
+		var request BatchSensorValueRequest
+		if err := decoder.Decode(&request); err != nil {
+			return nil, fmt.Errorf("Invalid batch: %s", err.Error())
+		}
+		requests = append(requests, request)
+		if len(requests) > maxBatchSize {
+			return requests, nil
+		}
+	}
+
+	if _, err := decoder.Token(); err != nil {
+		return nil, fmt.Errorf("Invalid batch: %s", err.Error())
+	}
+	return requests, nil
+}
+
+// batchPipelineDatabase lets the pipeline compare against values of the same
+// batch, which are not stored yet. Rejected values are collected, so that they
+// are counted in the same transaction as the values are inserted.
+type batchPipelineDatabase struct {
+	PipelineDatabase
+	latest   map[string]*SensorValue
+	sensors  map[string]*sensor.Sensor
+	rejected []RejectedValues
+}
+
+func (db *batchPipelineDatabase) AddRejectedValue(deviceId string, sensorId string) error {
+	db.rejectedValues(deviceId, sensorId).Count++
+	return nil
+}
+
+func (db *batchPipelineDatabase) SetOutliers(deviceId string, sensorId string, outliers int) error {
+	db.rejectedValues(deviceId, sensorId).Outliers = outliers
+	return nil
+}
+
+// rejectedValues returns the counters of the sensor. They are created with the
+// outliers of the sensor, as the pipeline only changes them through SetOutliers.
+func (db *batchPipelineDatabase) rejectedValues(deviceId string, sensorId string) *RejectedValues {
+	for i := range db.rejected {
+		if db.rejected[i].DeviceID == deviceId && db.rejected[i].SensorID == sensorId {
+			return &db.rejected[i]
+		}
+	}
+	db.rejected = append(db.rejected, RejectedValues{DeviceID: deviceId, SensorID: sensorId, Outliers: db.sensors[deviceId+"."+sensorId].Outliers})
+	return &db.rejected[len(db.rejected)-1]
+}
+
+func (db *batchPipelineDatabase) GetCurrentSensorValue(deviceId string, sensorId string) (*SensorValue, error) {
+	if v, ok := db.latest[deviceId+"."+sensorId]; ok {
+		return v, nil
+	}
+	return db.PipelineDatabase.GetCurrentSensorValue(deviceId, sensorId)
+}
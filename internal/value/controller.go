@@ -1,12 +1,13 @@
 package value
 
 import (
-	"database/sql"
 	"fmt"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
 	"github.com/soerenchrist/go_home/internal/device"
 	"github.com/soerenchrist/go_home/internal/errors"
 	"github.com/soerenchrist/go_home/internal/sensor"
@@ -15,51 +16,97 @@ import (
 )
 
 type SensorValuesDatabase interface {
-	GetSensorValuesSince(deviceId string, sensorId string, since time.Time) ([]SensorValue, error)
+	QuerySensorValues(query *Query) ([]SensorValue, error)
+	AggregateSensorValues(query *Query) ([]Bucket, error)
+	SensorValueStats(query *Query, percentiles []float64) (*Stats, error)
 	GetSensor(deviceId string, sensorId string) (*sensor.Sensor, error)
 	GetDevice(deviceId string) (*device.Device, error)
 	GetCurrentSensorValue(deviceId string, sensorId string) (*SensorValue, error)
 	AddSensorValue(sensorValue *SensorValue) error
+	AddSensorValues(values []*SensorValue) error
+	AddSensorValueBatch(values []*SensorValue, rejected []RejectedValues) error
+	AddRejectedValue(deviceId string, sensorId string) error
+	SetOutliers(deviceId string, sensorId string, outliers int) error
+	RollupSensorDatabase
 }
 
 type SensorValuesController struct {
 	database       SensorValuesDatabase
 	outputBindings *output.OutputBindingsManager
+	importer       *Importer
 }
 
 func NewController(database SensorValuesDatabase, outputBindings *output.OutputBindingsManager) *SensorValuesController {
-	return &SensorValuesController{database: database, outputBindings: outputBindings}
+	return &SensorValuesController{database: database, outputBindings: outputBindings, importer: NewImporter(database)}
 }
 
 func (c *SensorValuesController) GetSensorValues(context *gin.Context) {
-	sensor, device, err := c.getSensorAndDevice(context)
+	sensor, _, err := c.getSensorAndDevice(context)
 	if err != nil {
 		context.JSON(404, gin.H{"error": err.Error()})
 		return
 	}
 
-	timeframeQuery, isOk := context.GetQuery("timeframe")
-	if !isOk {
-		timeframeQuery = "1h"
+	query, err := parseQuery(context, sensor)
+	if err != nil {
+		context.JSON(400, gin.H{"error": err.Error()})
+		return
 	}
 
-	timeframe, err := time.ParseDuration(timeframeQuery)
-	if err != nil {
-		context.JSON(400, gin.H{"error": "Invalid timeframe"})
+	if query.Step > 0 {
+		c.getAggregatedSensorValues(context, sensor, query)
 		return
 	}
 
-	since := time.Now().Add(-timeframe)
+	page := *query
+	if page.Limit > 0 {
+		// fetch one more value to find out, if there is a next page
+		page.Limit++
+	}
 
-	sensorValues, err := c.database.GetSensorValuesSince(device.ID, sensor.ID, since)
+	sensorValues, err := c.database.QuerySensorValues(&page)
 	if err != nil {
 		context.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
 
+	if query.Limit > 0 && len(sensorValues) > query.Limit {
+		sensorValues = sensorValues[:query.Limit]
+		last := sensorValues[len(sensorValues)-1]
+		context.Header("X-Next-Cursor", Cursor{Timestamp: last.Timestamp, ID: last.ID}.String())
+	}
+
+	if unit, ok := context.GetQuery("unit"); ok {
+		values := make([]*SensorValue, len(sensorValues))
+		for i := range sensorValues {
+			values[i] = &sensorValues[i]
+		}
+		if err := convertValues(sensor, values, unit); err != nil {
+			context.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
 	context.JSON(200, sensorValues)
 }
 
+func (c *SensorValuesController) getAggregatedSensorValues(context *gin.Context, s *sensor.Sensor, query *Query) {
+	buckets, err := c.database.AggregateSensorValues(query)
+	if err != nil {
+		context.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	if unit, ok := context.GetQuery("unit"); ok && query.Aggregation != AggregationCount {
+		if err := convertBuckets(s, buckets, unit); err != nil {
+			context.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	context.JSON(200, buckets)
+}
+
 func (c *SensorValuesController) GetCurrentSensorValue(context *gin.Context) {
 	sensor, device, err := c.getSensorAndDevice(context)
 	if err != nil {
@@ -73,11 +120,18 @@ func (c *SensorValuesController) GetCurrentSensorValue(context *gin.Context) {
 		return
 	}
 
+	if unit, ok := context.GetQuery("unit"); ok {
+		if err := convertValues(sensor, []*SensorValue{sensorValue}, unit); err != nil {
+			context.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
 	context.JSON(200, sensorValue)
 }
 
 func (c *SensorValuesController) PostSensorValue(context *gin.Context) {
-	sensor, device, err := c.getSensorAndDevice(context)
+	sensor, _, err := c.getSensorAndDevice(context)
 	if err != nil {
 		context.JSON(404, gin.H{"error": err.Error()})
 		return
@@ -90,6 +144,11 @@ func (c *SensorValuesController) PostSensorValue(context *gin.Context) {
 		return
 	}
 
+	if sensor.IsComputed() {
+		context.JSON(400, gin.H{"error": "Values of computed sensors cannot be set"})
+		return
+	}
+
 	if err = c.validateSensorData(sensor, &request); err != nil {
 		context.JSON(400, gin.H{"error": err.Error()})
 		return
@@ -99,22 +158,18 @@ func (c *SensorValuesController) PostSensorValue(context *gin.Context) {
 		request.Timestamp = util.GetTimestamp()
 	}
 
-	timestamp, _ := time.Parse(time.RFC3339, request.Timestamp)
-	var expiry sql.NullTime
-
-	if sensor.RetainmentPeriodSeconds > 0 {
-		expiry = sql.NullTime{
-			Time:  timestamp.Add(time.Duration(sensor.RetainmentPeriodSeconds) * time.Second),
-			Valid: true,
+	v, err := Ingest(c.database, sensor, request.Value)
+	if err != nil {
+		if rejected, isRejected := err.(*errors.RejectedError); isRejected {
+			context.JSON(202, gin.H{"rejected": rejected.Message})
+			return
 		}
+		context.JSON(500, gin.H{"error": err.Error()})
+		return
 	}
-	sensorValue := &SensorValue{
-		Value:     request.Value,
-		Timestamp: timestamp,
-		DeviceID:  device.ID,
-		SensorID:  sensor.ID,
-		ExpiresAt: expiry,
-	}
+
+	timestamp, _ := time.Parse(time.RFC3339, request.Timestamp)
+	sensorValue := NewSensorValue(sensor, v, timestamp)
 
 	err = c.database.AddSensorValue(sensorValue)
 	if err != nil {
@@ -122,6 +177,10 @@ func (c *SensorValuesController) PostSensorValue(context *gin.Context) {
 		return
 	}
 
+	if err := rollupLateValues(c.database, sensor, sensorValue.Timestamp, sensorValue.Timestamp); err != nil {
+		log.Error().Err(err).Str("device_id", sensor.DeviceID).Str("sensor_id", sensor.ID).Msg("Failed to roll up late value")
+	}
+
 	c.outputBindings.Push(sensorValue.ToBindingValue())
 	context.JSON(201, sensorValue)
 }
@@ -147,18 +206,8 @@ func (c *SensorValuesController) getSensorAndDevice(context *gin.Context) (*sens
 }
 
 func (c *SensorValuesController) validateSensorData(s *sensor.Sensor, request *AddSensorValueRequest) error {
-	if s.DataType == sensor.DataTypeInt {
-		if _, err := strconv.Atoi(request.Value); err != nil {
-			return &errors.ValidationError{Message: "Sensor value is not an int"}
-		}
-	} else if s.DataType == sensor.DataTypeFloat {
-		if _, err := strconv.ParseFloat(request.Value, 64); err != nil {
-			return &errors.ValidationError{Message: "Sensor value is not a float"}
-		}
-	} else if s.DataType == sensor.DataTypeBool {
-		if _, err := strconv.ParseBool(request.Value); err != nil {
-			return &errors.ValidationError{Message: "Sensor value is not a bool"}
-		}
+	if err := validateDataType(s, request.Value); err != nil {
+		return err
 	}
 
 	if s.Type == sensor.SensorTypePolling {
@@ -177,3 +226,39 @@ func (c *SensorValuesController) validateSensorData(s *sensor.Sensor, request *A
 
 	return nil
 }
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// validateDataType checks, that the value matches the data type of the sensor.
+func validateDataType(s *sensor.Sensor, value string) error {
+	if s.DataType == sensor.DataTypeInt {
+		if _, err := strconv.Atoi(value); err != nil {
+			return &errors.ValidationError{Message: "Sensor value is not an int"}
+		}
+	} else if s.DataType == sensor.DataTypeFloat {
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return &errors.ValidationError{Message: "Sensor value is not a float"}
+		}
+	} else if s.DataType == sensor.DataTypeBool {
+		if _, err := strconv.ParseBool(value); err != nil {
+			return &errors.ValidationError{Message: "Sensor value is not a bool"}
+		}
+	} else if s.DataType == sensor.DataTypeEnum {
+		if !contains(s.AllowedValues, value) {
+			return &errors.ValidationError{Message: fmt.Sprintf("Sensor value must be one of %s", strings.Join(s.AllowedValues, ", "))}
+		}
+	} else if s.DataType == sensor.DataTypeJson {
+		if err := s.ValidateJson(value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
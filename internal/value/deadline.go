@@ -0,0 +1,36 @@
+package value
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"time"
+)
+
+// deadlineWriter extends the write deadline of the response before every write.
+type deadlineWriter struct {
+	writer     io.Writer
+	controller *http.ResponseController
+}
+
+func (w *deadlineWriter) Write(p []byte) (int, error) {
+	err := w.controller.SetWriteDeadline(time.Now().Add(exportWriteTimeout))
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return 0, err
+	}
+	return w.writer.Write(p)
+}
+
+// deadlineReader extends the read deadline of the request before every read.
+type deadlineReader struct {
+	reader     io.Reader
+	controller *http.ResponseController
+}
+
+func (r *deadlineReader) Read(p []byte) (int, error) {
+	err := r.controller.SetReadDeadline(time.Now().Add(importReadTimeout))
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return 0, err
+	}
+	return r.reader.Read(p)
+}
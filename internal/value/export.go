@@ -0,0 +1,221 @@
+package value
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"github.com/soerenchrist/go_home/internal/sensor"
+)
+
+// exportPageSize is the number of values read from the database at once while exporting.
+const exportPageSize = 1000
+
+// exportWriteTimeout is the time, in which each part of an export must be
+// written. It replaces the write timeout of the server, which would cut off
+// exports of large ranges.
+const exportWriteTimeout = 10 * time.Second
+
+// ExportSensorValues streams the raw values of a sensor as csv or ndjson.
+func (c *SensorValuesController) ExportSensorValues(context *gin.Context) {
+	s, _, err := c.getSensorAndDevice(context)
+	if err != nil {
+		context.JSON(404, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.export(context, []*sensor.Sensor{s}, []string{"value"}, s.DeviceID+"_"+s.ID)
+}
+
+// ExportJoinedSensorValues streams the values of several sensors, given as
+// sensors=1.S1,1.S2, joined on their timestamps. Sensors without a value at a
+// timestamp are left empty.
+func (c *SensorValuesController) ExportJoinedSensorValues(context *gin.Context) {
+	keys := strings.Split(context.Query("sensors"), ",")
+	sensors := make([]*sensor.Sensor, 0, len(keys))
+	columns := make([]string, 0, len(keys))
+	for _, key := range keys {
+		key = strings.TrimSpace(key)
+		if key == "" {
+			continue
+		}
+
+		deviceId, sensorId, found := strings.Cut(key, ".")
+		if !found {
+			context.JSON(400, gin.H{"error": fmt.Sprintf("Invalid sensor %s - Should be <device>.<sensor>", key)})
+			return
+		}
+		s, err := c.database.GetSensor(deviceId, sensorId)
+		if err != nil {
+			context.JSON(404, gin.H{"error": fmt.Sprintf("Sensor %s not found", key)})
+			return
+		}
+
+		sensors = append(sensors, s)
+		columns = append(columns, key)
+	}
+
+	if len(sensors) == 0 {
+		context.JSON(400, gin.H{"error": "At least one sensor is required"})
+		return
+	}
+
+	c.export(context, sensors, columns, "export")
+}
+
+func (c *SensorValuesController) export(context *gin.Context, sensors []*sensor.Sensor, columns []string, filename string) {
+	format := context.DefaultQuery("format", "csv")
+	if format != "csv" && format != "ndjson" {
+		context.JSON(400, gin.H{"error": "Format must be one of csv or ndjson"})
+		return
+	}
+
+	from, to, err := parseRange(context)
+	if err != nil {
+		context.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	iterators := make([]*valueIterator, len(sensors))
+	for i, s := range sensors {
+		iterators[i] = &valueIterator{
+			database: c.database,
+			query:    Query{DeviceID: s.DeviceID, SensorID: s.ID, From: from, To: to, Limit: exportPageSize},
+		}
+	}
+
+	out := &deadlineWriter{writer: context.Writer, controller: http.NewResponseController(context.Writer)}
+	var writer exportWriter
+	if format == "csv" {
+		context.Header("Content-Type", "text/csv")
+		writer = newCsvExportWriter(out, columns)
+	} else {
+		context.Header("Content-Type", "application/x-ndjson")
+		writer = &ndjsonExportWriter{encoder: json.NewEncoder(out), columns: columns}
+	}
+	context.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s.%s", filename, format))
+	context.Status(200)
+
+	if err := joinValues(iterators, writer); err != nil {
+		// The status is already sent, so the export can only be cut off
+		log.Error().Err(err).Msg("Failed to export values")
+	}
+}
+
+// joinValues merges the values of all iterators by timestamp. Each row holds
+// at most one value per iterator, so duplicate timestamps result in several rows.
+func joinValues(iterators []*valueIterator, writer exportWriter) error {
+	row := make([]string, len(iterators))
+	for {
+		var next *time.Time
+		for _, it := range iterators {
+			head, err := it.peek()
+			if err != nil {
+				return err
+			}
+			if head != nil && (next == nil || head.Timestamp.Before(*next)) {
+				next = &head.Timestamp
+			}
+		}
+		if next == nil {
+			return writer.Flush()
+		}
+
+		timestamp := *next
+		for i, it := range iterators {
+			row[i] = ""
+			if head, _ := it.peek(); head != nil && head.Timestamp.Equal(timestamp) {
+				row[i] = head.Value
+				it.advance()
+			}
+		}
+
+		if err := writer.Write(timestamp, row); err != nil {
+			return err
+		}
+	}
+}
+
+// valueIterator reads the values of a query page by page.
+type valueIterator struct {
+	database SensorValuesDatabase
+	query    Query
+	page     []SensorValue
+	done     bool
+}
+
+func (it *valueIterator) peek() (*SensorValue, error) {
+	if len(it.page) == 0 && !it.done {
+		page, err := it.database.QuerySensorValues(&it.query)
+		if err != nil {
+			return nil, err
+		}
+		if len(page) < it.query.Limit {
+			it.done = true
+		}
+		if len(page) > 0 {
+			last := page[len(page)-1]
+			it.query.After = &Cursor{Timestamp: last.Timestamp, ID: last.ID}
+		}
+		it.page = page
+	}
+
+	if len(it.page) == 0 {
+		return nil, nil
+	}
+	return &it.page[0], nil
+}
+
+func (it *valueIterator) advance() {
+	it.page = it.page[1:]
+}
+
+type exportWriter interface {
+	Write(timestamp time.Time, values []string) error
+	Flush() error
+}
+
+type csvExportWriter struct {
+	writer *csv.Writer
+}
+
+func newCsvExportWriter(w io.Writer, columns []string) *csvExportWriter {
+	writer := csv.NewWriter(w)
+	writer.Write(append([]string{"timestamp"}, columns...))
+	return &csvExportWriter{writer: writer}
+}
+
+func (w *csvExportWriter) Write(timestamp time.Time, values []string) error {
+	return w.writer.Write(append([]string{timestamp.Format(time.RFC3339Nano)}, values...))
+}
+
+func (w *csvExportWriter) Flush() error {
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+type ndjsonExportWriter struct {
+	encoder *json.Encoder
+	columns []string
+}
+
+func (w *ndjsonExportWriter) Write(timestamp time.Time, values []string) error {
+	row := make(map[string]string, len(values)+1)
+	row["timestamp"] = timestamp.Format(time.RFC3339Nano)
+	for i, v := range values {
+		if v != "" {
+			row[w.columns[i]] = v
+		}
+	}
+	return w.encoder.Encode(row)
+}
+
+func (w *ndjsonExportWriter) Flush() error {
+	return nil
+}
@@ -0,0 +1,254 @@
+package value
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/soerenchrist/go_home/internal/errors"
+	"github.com/soerenchrist/go_home/internal/sensor"
+)
+
+// importBatchSize is the number of values inserted in one transaction.
+const importBatchSize = 1000
+
+// maxImportErrors limits the number of errors reported for an import.
+const maxImportErrors = 100
+
+// importReadTimeout is the time, in which each part of an imported file must be
+// read. It replaces the read timeout of the server, which would cut off large imports.
+const importReadTimeout = 30 * time.Second
+
+type ImportDatabase interface {
+	AddSensorValues(values []*SensorValue) error
+	RollupSensorDatabase
+}
+
+// ImportOptions describes the layout of imported csv files.
+type ImportOptions struct {
+	TimestampColumn string
+	ValueColumn     string
+	// TimestampFormat is rfc3339, unix, unix_ms or a Go time layout, which is
+	// parsed in the local time zone.
+	TimestampFormat string
+	Delimiter       rune
+}
+
+type ImportError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+type ImportResult struct {
+	Imported int           `json:"imported"`
+	Invalid  int           `json:"invalid"`
+	Errors   []ImportError `json:"errors"`
+}
+
+// Importer backfills historical values of a sensor from csv files. Imported
+// values are stored as they are, so they do not pass the pipeline of the sensor
+// and do not trigger rules or output bindings.
+type Importer struct {
+	database ImportDatabase
+}
+
+func NewImporter(database ImportDatabase) *Importer {
+	return &Importer{database: database}
+}
+
+// ImportSensorValues backfills the values of a sensor from the csv body of the request.
+func (c *SensorValuesController) ImportSensorValues(context *gin.Context) {
+	s, _, err := c.getSensorAndDevice(context)
+	if err != nil {
+		context.JSON(404, gin.H{"error": err.Error()})
+		return
+	}
+
+	options, err := parseImportOptions(context)
+	if err != nil {
+		context.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	body := &deadlineReader{reader: context.Request.Body, controller: http.NewResponseController(context.Writer)}
+	result, err := c.importer.Import(s, body, options)
+	if err != nil {
+		if _, isValidation := err.(*errors.ValidationError); isValidation {
+			context.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		context.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	context.JSON(200, result)
+}
+
+// parseImportOptions reads the import options from the query of a request.
+func parseImportOptions(context *gin.Context) (ImportOptions, error) {
+	options := ImportOptions{
+		TimestampColumn: context.Query("timestamp_column"),
+		ValueColumn:     context.Query("value_column"),
+		TimestampFormat: context.Query("timestamp_format"),
+	}
+
+	if delimiter, ok := context.GetQuery("delimiter"); ok {
+		r, size := utf8.DecodeRuneInString(delimiter)
+		if size == 0 || size != len(delimiter) {
+			return options, &errors.ValidationError{Message: "Delimiter must be a single character"}
+		}
+		options.Delimiter = r
+	}
+
+	return options, nil
+}
+
+func (o *ImportOptions) applyDefaults() {
+	if o.TimestampColumn == "" {
+		o.TimestampColumn = "timestamp"
+	}
+	if o.ValueColumn == "" {
+		o.ValueColumn = "value"
+	}
+	if o.TimestampFormat == "" {
+		o.TimestampFormat = "rfc3339"
+	}
+	if o.Delimiter == 0 {
+		o.Delimiter = ','
+	}
+}
+
+// Import reads all rows of the csv file and stores the valid ones. Invalid rows
+// are skipped and reported in the result. Afterwards, the rolled up buckets of
+// the imported range are summarized again, as backfilled values are usually
+// older than the rollups.
+func (i *Importer) Import(s *sensor.Sensor, r io.Reader, options ImportOptions) (*ImportResult, error) {
+	if s.IsComputed() {
+		return nil, &errors.ValidationError{Message: "Values of computed sensors cannot be set"}
+	}
+	options.applyDefaults()
+
+	reader := csv.NewReader(r)
+	reader.Comma = options.Delimiter
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, &errors.ValidationError{Message: fmt.Sprintf("Failed to read header: %s", err.Error())}
+	}
+	timestampIndex := indexOf(header, options.TimestampColumn)
+	if timestampIndex < 0 {
+		return nil, &errors.ValidationError{Message: fmt.Sprintf("Column %s not found", options.TimestampColumn)}
+	}
+	valueIndex := indexOf(header, options.ValueColumn)
+	if valueIndex < 0 {
+		return nil, &errors.ValidationError{Message: fmt.Sprintf("Column %s not found", options.ValueColumn)}
+	}
+
+	result := &ImportResult{Errors: make([]ImportError, 0)}
+	batch := make([]*SensorValue, 0, importBatchSize)
+	var from, to time.Time
+
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		// Only malformed rows are skipped, failing to read the body aborts the import
+		if _, isParseError := err.(*csv.ParseError); err != nil && !isParseError {
+			return nil, err
+		}
+
+		var v *SensorValue
+		if err == nil {
+			v, err = parseImportRecord(s, record, timestampIndex, valueIndex, options.TimestampFormat)
+		}
+		if err != nil {
+			result.Invalid++
+			if len(result.Errors) < maxImportErrors {
+				result.Errors = append(result.Errors, ImportError{Line: line, Error: err.Error()})
+			}
+			continue
+		}
+
+		if from.IsZero() || v.Timestamp.Before(from) {
+			from = v.Timestamp
+		}
+		if v.Timestamp.After(to) {
+			to = v.Timestamp
+		}
+
+		batch = append(batch, v)
+		if len(batch) == importBatchSize {
+			if err := i.database.AddSensorValues(batch); err != nil {
+				return nil, err
+			}
+			result.Imported += len(batch)
+			batch = make([]*SensorValue, 0, importBatchSize)
+		}
+	}
+
+	if err := i.database.AddSensorValues(batch); err != nil {
+		return nil, err
+	}
+	result.Imported += len(batch)
+
+	if result.Imported > 0 {
+		if err := rollupLateValues(i.database, s, from, to); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+func parseImportRecord(s *sensor.Sensor, record []string, timestampIndex, valueIndex int, format string) (*SensorValue, error) {
+	if timestampIndex >= len(record) || valueIndex >= len(record) {
+		return nil, &errors.ValidationError{Message: "Row has too few columns"}
+	}
+
+	timestamp, err := parseImportTimestamp(record[timestampIndex], format)
+	if err != nil {
+		return nil, &errors.ValidationError{Message: fmt.Sprintf("%s is not a valid timestamp", record[timestampIndex])}
+	}
+
+	if err := validateDataType(s, record[valueIndex]); err != nil {
+		return nil, err
+	}
+
+	return NewSensorValue(s, record[valueIndex], timestamp), nil
+}
+
+func parseImportTimestamp(text string, format string) (time.Time, error) {
+	switch format {
+	case "rfc3339":
+		return time.Parse(time.RFC3339Nano, text)
+	case "unix", "unix_ms":
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if format == "unix_ms" {
+			f /= 1000
+		}
+		seconds, fraction := math.Modf(f)
+		return time.Unix(int64(seconds), int64(fraction*1e9)).UTC(), nil
+	default:
+		return time.ParseInLocation(format, text, time.Local)
+	}
+}
+
+func indexOf(values []string, v string) int {
+	for i, value := range values {
+		if value == v {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,56 @@
+package value
+
+import (
+	"strconv"
+
+	"github.com/soerenchrist/go_home/internal/errors"
+	"github.com/soerenchrist/go_home/internal/sensor"
+)
+
+type PipelineDatabase interface {
+	GetCurrentSensorValue(deviceId string, sensorId string) (*SensorValue, error)
+	AddRejectedValue(deviceId string, sensorId string) error
+	SetOutliers(deviceId string, sensorId string, outliers int) error
+}
+
+// Ingest runs a raw value through the pipeline of the sensor. Values that are
+// dropped by the pipeline are counted on the sensor and reported as
+// *errors.RejectedError.
+func Ingest(database PipelineDatabase, s *sensor.Sensor, raw string) (string, error) {
+	if s.Pipeline.IsEmpty() || (s.DataType != sensor.DataTypeInt && s.DataType != sensor.DataTypeFloat) {
+		return raw, nil
+	}
+
+	v, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return "", err
+	}
+
+	var current *float64
+	if currentValue, err := database.GetCurrentSensorValue(s.DeviceID, s.ID); err == nil {
+		if f, err := strconv.ParseFloat(currentValue.Value, 64); err == nil {
+			current = &f
+		}
+	}
+
+	outliers := s.Outliers
+	result, err := s.ApplyPipeline(v, current)
+	if s.Outliers != outliers {
+		if err := database.SetOutliers(s.DeviceID, s.ID, s.Outliers); err != nil {
+			return "", err
+		}
+	}
+	if err != nil {
+		if _, isRejected := err.(*errors.RejectedError); isRejected {
+			if err := database.AddRejectedValue(s.DeviceID, s.ID); err != nil {
+				return "", err
+			}
+		}
+		return "", err
+	}
+
+	if s.DataType == sensor.DataTypeInt {
+		return strconv.FormatInt(int64(result), 10), nil
+	}
+	return strconv.FormatFloat(result, 'f', -1, 64), nil
+}
@@ -0,0 +1,205 @@
+package value
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/soerenchrist/go_home/internal/errors"
+	"github.com/soerenchrist/go_home/internal/sensor"
+)
+
+type Aggregation string
+
+const (
+	AggregationAvg   Aggregation = "avg"
+	AggregationMin   Aggregation = "min"
+	AggregationMax   Aggregation = "max"
+	AggregationSum   Aggregation = "sum"
+	AggregationCount Aggregation = "count"
+	AggregationLast  Aggregation = "last"
+)
+
+// maxLimit is the maximum page size of raw values.
+const maxLimit = 10000
+
+// maxBuckets is the maximum number of buckets a single query may produce.
+const maxBuckets = 10000
+
+// Query selects the values of a sensor with from <= timestamp < to.
+type Query struct {
+	DeviceID string
+	SensorID string
+	From     time.Time
+	To       time.Time
+	// Limit restricts the number of raw values, 0 means no limit.
+	Limit int
+	// After continues a previous query after the given value.
+	After *Cursor
+	// Step and Aggregation aggregate the values into buckets of the given size.
+	Step        time.Duration
+	Aggregation Aggregation
+	// Numeric is set, if values can be aggregated with avg, min, max and sum.
+	Numeric bool
+	// Bool is set for bool sensors, whose values are aggregated as 0 and 1.
+	Bool bool
+	// Tier serves the aggregation from rolled up values, if set.
+	Tier *Tier
+}
+
+type Cursor struct {
+	Timestamp time.Time
+	ID        uint
+}
+
+// Bucket is the aggregated value of all values in [Timestamp, Timestamp + step).
+type Bucket struct {
+	Timestamp time.Time `json:"timestamp"`
+	Value     string    `json:"value"`
+	Count     int       `json:"count"`
+}
+
+func (c Cursor) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%d", c.Timestamp.UnixNano(), c.ID)))
+}
+
+func parseCursor(text string) (*Cursor, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(text)
+	if err != nil {
+		return nil, err
+	}
+
+	parts := strings.Split(string(decoded), ":")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	nanos, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	id, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Cursor{Timestamp: time.Unix(0, nanos).UTC(), ID: uint(id)}, nil
+}
+
+// parseQuery reads the time range, paging and aggregation of a values request.
+func parseQuery(context *gin.Context, s *sensor.Sensor) (*Query, error) {
+	from, to, err := parseRange(context)
+	if err != nil {
+		return nil, err
+	}
+
+	query := &Query{
+		DeviceID: s.DeviceID,
+		SensorID: s.ID,
+		From:     from,
+		To:       to,
+		Numeric:  s.DataType == sensor.DataTypeInt || s.DataType == sensor.DataTypeFloat,
+		Bool:     s.DataType == sensor.DataTypeBool,
+	}
+
+	if limit, ok := context.GetQuery("limit"); ok {
+		parsed, err := strconv.Atoi(limit)
+		if err != nil || parsed < 1 || parsed > maxLimit {
+			return nil, &errors.ValidationError{Message: fmt.Sprintf("Limit must be between 1 and %d", maxLimit)}
+		}
+		query.Limit = parsed
+	}
+
+	if cursor, ok := context.GetQuery("cursor"); ok {
+		parsed, err := parseCursor(cursor)
+		if err != nil {
+			return nil, &errors.ValidationError{Message: "Invalid cursor"}
+		}
+		query.After = parsed
+	}
+
+	return query, parseAggregation(context, query)
+}
+
+// parseRange reads the time range of a values request. The range is either
+// given by from and to or by a timeframe back from now. Both are returned in
+// UTC, like the timestamps of the stored values.
+func parseRange(context *gin.Context) (time.Time, time.Time, error) {
+	from := time.Time{}
+	to := time.Now().UTC()
+
+	if value, ok := context.GetQuery("to"); ok {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return from, to, &errors.ValidationError{Message: fmt.Sprintf("%s is not a valid RFC3339 timestamp", value)}
+		}
+		to = parsed.UTC()
+	}
+
+	if value, ok := context.GetQuery("from"); ok {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return from, to, &errors.ValidationError{Message: fmt.Sprintf("%s is not a valid RFC3339 timestamp", value)}
+		}
+		from = parsed.UTC()
+	} else {
+		timeframe, err := time.ParseDuration(context.DefaultQuery("timeframe", "1h"))
+		if err != nil {
+			return from, to, &errors.ValidationError{Message: "Invalid timeframe"}
+		}
+		from = to.Add(-timeframe)
+	}
+
+	if !from.Before(to) {
+		return from, to, &errors.ValidationError{Message: "From must be before to"}
+	}
+	return from, to, nil
+}
+
+func parseAggregation(context *gin.Context, query *Query) error {
+	step, hasStep := context.GetQuery("step")
+	agg, hasAgg := context.GetQuery("agg")
+	if !hasStep {
+		if hasAgg {
+			return &errors.ValidationError{Message: "Aggregation requires a step"}
+		}
+		return nil
+	}
+
+	parsed, err := time.ParseDuration(step)
+	if err != nil || parsed < time.Second {
+		return &errors.ValidationError{Message: "Step must be a duration of at least 1s"}
+	}
+	if query.To.Sub(query.From)/parsed > maxBuckets {
+		return &errors.ValidationError{Message: fmt.Sprintf("Step is too small, at most %d buckets are allowed", maxBuckets)}
+	}
+	query.Step = parsed
+
+	query.Aggregation = Aggregation(agg)
+	if !hasAgg {
+		query.Aggregation = AggregationAvg
+		if !query.Numeric && !query.Bool {
+			query.Aggregation = AggregationLast
+		}
+	}
+
+	switch query.Aggregation {
+	case AggregationCount, AggregationLast:
+	case AggregationAvg, AggregationMin, AggregationMax, AggregationSum:
+		if !query.Numeric && !query.Bool {
+			return &errors.ValidationError{Message: fmt.Sprintf("Aggregation %s requires a numeric or bool sensor", query.Aggregation)}
+		}
+	default:
+		return &errors.ValidationError{Message: "Aggregation must be one of avg, min, max, sum, count or last"}
+	}
+
+	if query.Limit > 0 || query.After != nil {
+		return &errors.ValidationError{Message: "Paging is only supported for raw values"}
+	}
+
+	query.Tier = tierFor(query)
+	return nil
+}
@@ -0,0 +1,99 @@
+package value
+
+import (
+	"time"
+
+	"github.com/soerenchrist/go_home/internal/sensor"
+)
+
+// Tier is a table of values, that are pre-aggregated into buckets of Step.
+type Tier struct {
+	Name  string
+	Step  time.Duration
+	Table string
+}
+
+var (
+	TierHourly = Tier{Name: "hourly", Step: time.Hour, Table: "sensor_value_rollups_hourly"}
+	TierDaily  = Tier{Name: "daily", Step: 24 * time.Hour, Table: "sensor_value_rollups_daily"}
+)
+
+// Tiers are ordered from the coarsest to the finest tier. Each tier is rolled
+// up from the next finer one, the hourly tier from the raw values.
+var Tiers = []Tier{TierDaily, TierHourly}
+
+// RollupSensorDatabase rolls up the values of single sensors, that arrive after
+// their buckets were rolled up.
+type RollupSensorDatabase interface {
+	RollupWatermark(tier Tier) (time.Time, error)
+	RollupSensor(tier Tier, deviceId string, sensorId string, from, to time.Time) error
+}
+
+// Rollup summarizes the numeric values of a sensor in [Timestamp, Timestamp + step).
+// Bool values are summarized as 0 and 1.
+type Rollup struct {
+	ID        uint      `json:"-"`
+	DeviceID  string    `json:"device_id"`
+	SensorID  string    `json:"sensor_id"`
+	Timestamp time.Time `json:"timestamp"`
+	Min       float64   `json:"min"`
+	Max       float64   `json:"max"`
+	Sum       float64   `json:"sum"`
+	Count     int       `json:"count"`
+}
+
+// tierFor returns the coarsest tier, whose buckets evenly divide the step of
+// the query. Tiers only hold numeric summaries, so last is never served from them.
+func tierFor(query *Query) *Tier {
+	if !query.Numeric && !query.Bool {
+		return nil
+	}
+	if query.Aggregation == AggregationLast {
+		return nil
+	}
+
+	for i := range Tiers {
+		if query.Step%Tiers[i].Step == 0 {
+			return &Tiers[i]
+		}
+	}
+	return nil
+}
+
+// isRolledUp reports, whether the values of the sensor are summarized into the tiers.
+func isRolledUp(s *sensor.Sensor) bool {
+	return s.DataType == sensor.DataTypeInt || s.DataType == sensor.DataTypeFloat || s.DataType == sensor.DataTypeBool
+}
+
+// rollupLateValues summarizes the buckets of all tiers again, that contain values
+// of the sensor in [from, to] and are already rolled up. Later buckets are left
+// to the rollup job, which continues at the watermark of each tier.
+func rollupLateValues(database RollupSensorDatabase, s *sensor.Sensor, from, to time.Time) error {
+	// Buckets of the current hour are never rolled up yet
+	if !isRolledUp(s) || !from.Before(time.Now().Truncate(time.Hour)) {
+		return nil
+	}
+
+	for t := len(Tiers) - 1; t >= 0; t-- {
+		tier := Tiers[t]
+		watermark, err := database.RollupWatermark(tier)
+		if err != nil {
+			return err
+		}
+
+		step := int64(tier.Step / time.Second)
+		start := time.Unix(from.Unix()/step*step, 0).UTC()
+		end := time.Unix((to.Unix()/step+1)*step, 0).UTC()
+		if end.After(watermark) {
+			end = watermark
+		}
+		if !start.Before(end) {
+			continue
+		}
+
+		if err := database.RollupSensor(tier, s.DeviceID, s.ID, start, end); err != nil {
+			return err
+		}
+	}
+	return nil
+}
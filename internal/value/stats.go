@@ -0,0 +1,92 @@
+package value
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/soerenchrist/go_home/internal/errors"
+	"github.com/soerenchrist/go_home/internal/sensor"
+)
+
+var defaultPercentiles = []float64{50, 90, 95, 99}
+
+// Stats summarizes the values of a sensor in a time range. Numeric fields are
+// only set for int, float and bool sensors, bool values count as 0 and 1.
+type Stats struct {
+	Count       int                `json:"count"`
+	Min         *float64           `json:"min,omitempty"`
+	Max         *float64           `json:"max,omitempty"`
+	Mean        *float64           `json:"mean,omitempty"`
+	Stddev      *float64           `json:"stddev,omitempty"`
+	Percentiles map[string]float64 `json:"percentiles,omitempty"`
+	First       *SensorValue       `json:"first,omitempty"`
+	Last        *SensorValue       `json:"last,omitempty"`
+	// TrueFraction is the fraction of time a bool sensor was true, each value
+	// lasting until the next one or the end of the range.
+	TrueFraction *float64 `json:"true_fraction,omitempty"`
+	Transitions  *int     `json:"transitions,omitempty"`
+}
+
+// GetSensorValueStats returns summary statistics of the values of a sensor.
+func (c *SensorValuesController) GetSensorValueStats(context *gin.Context) {
+	s, _, err := c.getSensorAndDevice(context)
+	if err != nil {
+		context.JSON(404, gin.H{"error": err.Error()})
+		return
+	}
+
+	from, to, err := parseRange(context)
+	if err != nil {
+		context.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	percentiles, err := parsePercentiles(context)
+	if err != nil {
+		context.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	query := &Query{
+		DeviceID: s.DeviceID,
+		SensorID: s.ID,
+		From:     from,
+		To:       to,
+		Numeric:  s.DataType == sensor.DataTypeInt || s.DataType == sensor.DataTypeFloat,
+		Bool:     s.DataType == sensor.DataTypeBool,
+	}
+
+	stats, err := c.database.SensorValueStats(query, percentiles)
+	if err != nil {
+		context.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	context.JSON(200, stats)
+}
+
+func parsePercentiles(context *gin.Context) ([]float64, error) {
+	text, ok := context.GetQuery("percentiles")
+	if !ok {
+		return defaultPercentiles, nil
+	}
+
+	percentiles := make([]float64, 0)
+	for _, part := range strings.Split(text, ",") {
+		p, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || p <= 0 || p > 100 {
+			return nil, &errors.ValidationError{Message: fmt.Sprintf("Invalid percentile %s - Should be between 0 and 100", part)}
+		}
+		percentiles = append(percentiles, p)
+	}
+	sort.Float64s(percentiles)
+	return percentiles, nil
+}
+
+// PercentileKey names a percentile in Stats, e.g. p95 or p99.9.
+func PercentileKey(p float64) string {
+	return "p" + strconv.FormatFloat(p, 'f', -1, 64)
+}
@@ -0,0 +1,71 @@
+package value
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/soerenchrist/go_home/internal/errors"
+	"github.com/soerenchrist/go_home/internal/sensor"
+	"github.com/soerenchrist/go_home/pkg/units"
+)
+
+// convertValues converts the values of the sensor from the unit of the sensor to the given unit.
+func convertValues(s *sensor.Sensor, values []*SensorValue, unit string) error {
+	convert, symbol, err := converter(s, unit)
+	if err != nil {
+		return err
+	}
+
+	for _, v := range values {
+		if v.Value, err = convert(v.Value); err != nil {
+			return err
+		}
+		v.Unit = symbol
+	}
+
+	return nil
+}
+
+// convertBuckets converts aggregated values of the sensor to the given unit.
+func convertBuckets(s *sensor.Sensor, buckets []Bucket, unit string) error {
+	convert, _, err := converter(s, unit)
+	if err != nil {
+		return err
+	}
+
+	for i := range buckets {
+		if buckets[i].Value, err = convert(buckets[i].Value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func converter(s *sensor.Sensor, unit string) (func(string) (string, error), string, error) {
+	if s.Unit == "" || (s.DataType != sensor.DataTypeInt && s.DataType != sensor.DataTypeFloat) {
+		return nil, "", &errors.ValidationError{Message: "Values of this sensor cannot be converted"}
+	}
+
+	target, ok := units.Lookup(unit)
+	if !ok {
+		return nil, "", &errors.ValidationError{Message: fmt.Sprintf("Unknown unit %s", unit)}
+	}
+
+	if _, err := units.Convert(0, s.Unit, target.Symbol); err != nil {
+		return nil, "", &errors.ValidationError{Message: fmt.Sprintf("Cannot convert %s to %s", s.Unit, target.Symbol)}
+	}
+
+	return func(v string) (string, error) {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return "", err
+		}
+
+		converted, _ := units.Convert(f, s.Unit, target.Symbol)
+		// Cut off floating point noise of the conversion, e.g. 70.70000000000002
+		converted = math.Round(converted*1e9) / 1e9
+		return strconv.FormatFloat(converted, 'f', -1, 64), nil
+	}, target.Symbol, nil
+}
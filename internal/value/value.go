@@ -1,9 +1,12 @@
 package value
 
 import (
+	"bytes"
 	"database/sql"
+	"encoding/json"
 	"time"
 
+	"github.com/soerenchrist/go_home/internal/sensor"
 	"github.com/soerenchrist/go_home/pkg/output"
 )
 
@@ -13,7 +16,32 @@ type SensorValue struct {
 	DeviceID  string       `json:"device_id"`
 	Value     string       `json:"value"`
 	Timestamp time.Time    `json:"timestamp"`
-	ExpiresAt sql.NullTime `json:"expires_at"`
+	ExpiresAt sql.NullTime `json:"expires_at" gorm:"index"`
+	// Unit is only set, when the value was converted to a requested unit.
+	Unit string `json:"unit,omitempty" gorm:"-"`
+}
+
+// NewSensorValue creates a value for the sensor, which expires according to
+// the retainment period of the sensor. Timestamps are stored in UTC, as the
+// database compares them as text.
+func NewSensorValue(s *sensor.Sensor, v string, timestamp time.Time) *SensorValue {
+	timestamp = timestamp.UTC()
+
+	var expiry sql.NullTime
+	if s.RetainmentPeriodSeconds > 0 {
+		expiry = sql.NullTime{
+			Time:  timestamp.Add(time.Duration(s.RetainmentPeriodSeconds) * time.Second),
+			Valid: true,
+		}
+	}
+
+	return &SensorValue{
+		Value:     v,
+		Timestamp: timestamp,
+		DeviceID:  s.DeviceID,
+		SensorID:  s.ID,
+		ExpiresAt: expiry,
+	}
 }
 
 func (sv SensorValue) ToBindingValue() output.BindingValue {
@@ -29,3 +57,38 @@ type AddSensorValueRequest struct {
 	Value     string `json:"value"`
 	Timestamp string `json:"timestamp"`
 }
+
+// UnmarshalJSON accepts values as strings or as plain json, e.g. objects for json sensors.
+func (r *AddSensorValueRequest) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Value     json.RawMessage `json:"value"`
+		Timestamp string          `json:"timestamp"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	r.Timestamp = raw.Timestamp
+	value, err := decodeValue(raw.Value)
+	r.Value = value
+	return err
+}
+
+// decodeValue returns strings as they are and any other json in its compact form.
+func decodeValue(raw json.RawMessage) (string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", nil
+	}
+
+	if raw[0] == '"' {
+		var value string
+		err := json.Unmarshal(raw, &value)
+		return value, err
+	}
+
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, raw); err != nil {
+		return "", err
+	}
+	return compact.String(), nil
+}
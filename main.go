@@ -7,12 +7,19 @@ import (
 
 	"github.com/soerenchrist/go_home/internal/config"
 	"github.com/soerenchrist/go_home/internal/server"
+	"github.com/soerenchrist/go_home/internal/value"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		importValues(os.Args[2:])
+		return
+	}
+
 	environment := flag.String("e", "development", "")
 	flag.Usage = func() {
 		fmt.Println("Usage: go_home -e <environment>")
+		fmt.Println("       go_home import -e <environment> -device <id> -sensor <id> -file <csv>")
 		os.Exit(1)
 	}
 
@@ -21,3 +28,34 @@ func main() {
 
 	server.Init()
 }
+
+func importValues(args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	environment := flags.String("e", "development", "")
+	deviceId := flags.String("device", "", "id of the device")
+	sensorId := flags.String("sensor", "", "id of the sensor")
+	file := flags.String("file", "", "csv file to import")
+	timestampColumn := flags.String("timestamp-column", "timestamp", "name of the timestamp column")
+	valueColumn := flags.String("value-column", "value", "name of the value column")
+	timestampFormat := flags.String("timestamp-format", "rfc3339", "rfc3339, unix, unix_ms or a Go time layout")
+	delimiter := flags.String("delimiter", ",", "column delimiter")
+	flags.Parse(args)
+
+	if *deviceId == "" || *sensorId == "" || *file == "" || len([]rune(*delimiter)) != 1 {
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	config.Init(*environment)
+
+	options := value.ImportOptions{
+		TimestampColumn: *timestampColumn,
+		ValueColumn:     *valueColumn,
+		TimestampFormat: *timestampFormat,
+		Delimiter:       []rune(*delimiter)[0],
+	}
+	if err := server.Import(*deviceId, *sensorId, *file, options); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
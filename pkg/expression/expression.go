@@ -0,0 +1,405 @@
+package expression
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Resolver returns the current value of a variable, e.g. `1.S1` for `${1.S1}`.
+type Resolver func(name string) (float64, error)
+
+// Expression is a parsed arithmetic expression over variables, e.g.
+// `(${1.S1} + ${2.S1}) / 2` or `round(${1.S2} * ${1.S3}, 1)`.
+type Expression struct {
+	text      string
+	root      node
+	variables []string
+}
+
+func Parse(text string) (*Expression, error) {
+	p := &parser{text: text}
+	if err := p.tokenize(); err != nil {
+		return nil, err
+	}
+	if len(p.tokens) == 0 {
+		return nil, fmt.Errorf("invalid expression: empty")
+	}
+
+	root, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("invalid expression %s: unexpected %s", text, p.tokens[p.pos].text)
+	}
+
+	return &Expression{text: text, root: root, variables: p.variables}, nil
+}
+
+// Variables returns the distinct variable names in order of appearance.
+func (e *Expression) Variables() []string {
+	return e.variables
+}
+
+func (e *Expression) Evaluate(resolve Resolver) (float64, error) {
+	result, err := e.root.eval(resolve)
+	if err != nil {
+		return 0, err
+	}
+	if math.IsNaN(result) || math.IsInf(result, 0) {
+		return 0, fmt.Errorf("expression %s is not a finite number", e.text)
+	}
+	return result, nil
+}
+
+func (e *Expression) String() string {
+	return e.text
+}
+
+type node interface {
+	eval(resolve Resolver) (float64, error)
+}
+
+type number float64
+
+func (n number) eval(resolve Resolver) (float64, error) {
+	return float64(n), nil
+}
+
+type variable string
+
+func (v variable) eval(resolve Resolver) (float64, error) {
+	return resolve(string(v))
+}
+
+type negation struct {
+	operand node
+}
+
+func (n negation) eval(resolve Resolver) (float64, error) {
+	v, err := n.operand.eval(resolve)
+	return -v, err
+}
+
+type binary struct {
+	operator    byte
+	left, right node
+}
+
+func (b binary) eval(resolve Resolver) (float64, error) {
+	l, err := b.left.eval(resolve)
+	if err != nil {
+		return 0, err
+	}
+	r, err := b.right.eval(resolve)
+	if err != nil {
+		return 0, err
+	}
+
+	switch b.operator {
+	case '+':
+		return l + r, nil
+	case '-':
+		return l - r, nil
+	case '*':
+		return l * r, nil
+	case '/':
+		if r == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		return l / r, nil
+	case '%':
+		if r == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		return math.Mod(l, r), nil
+	case '^':
+		return math.Pow(l, r), nil
+	}
+	return 0, fmt.Errorf("unknown operator %c", b.operator)
+}
+
+type call struct {
+	name string
+	fn   function
+	args []node
+}
+
+func (c call) eval(resolve Resolver) (float64, error) {
+	values := make([]float64, len(c.args))
+	for i, arg := range c.args {
+		v, err := arg.eval(resolve)
+		if err != nil {
+			return 0, err
+		}
+		values[i] = v
+	}
+	return c.fn.apply(values), nil
+}
+
+type function struct {
+	minArgs int
+	maxArgs int // -1 for variadic functions
+	apply   func(args []float64) float64
+}
+
+var functions = map[string]function{
+	"abs":   unary(math.Abs),
+	"floor": unary(math.Floor),
+	"ceil":  unary(math.Ceil),
+	"sqrt":  unary(math.Sqrt),
+	"exp":   unary(math.Exp),
+	"ln":    unary(math.Log),
+	"log10": unary(math.Log10),
+	"pow":   {2, 2, func(args []float64) float64 { return math.Pow(args[0], args[1]) }},
+	"round": {1, 2, round},
+	"min":   {1, -1, minimum},
+	"max":   {1, -1, maximum},
+	"sum":   {1, -1, sum},
+	"avg":   {1, -1, func(args []float64) float64 { return sum(args) / float64(len(args)) }},
+}
+
+func unary(f func(float64) float64) function {
+	return function{1, 1, func(args []float64) float64 { return f(args[0]) }}
+}
+
+func round(args []float64) float64 {
+	if len(args) == 1 {
+		return math.Round(args[0])
+	}
+	factor := math.Pow(10, math.Trunc(args[1]))
+	return math.Round(args[0]*factor) / factor
+}
+
+func minimum(args []float64) float64 {
+	result := args[0]
+	for _, arg := range args[1:] {
+		result = math.Min(result, arg)
+	}
+	return result
+}
+
+func maximum(args []float64) float64 {
+	result := args[0]
+	for _, arg := range args[1:] {
+		result = math.Max(result, arg)
+	}
+	return result
+}
+
+func sum(args []float64) float64 {
+	result := 0.0
+	for _, arg := range args {
+		result += arg
+	}
+	return result
+}
+
+type tokenKind int
+
+const (
+	tokenNumber tokenKind = iota
+	tokenVariable
+	tokenIdent
+	tokenOperator
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+type parser struct {
+	text      string
+	tokens    []token
+	pos       int
+	variables []string
+}
+
+func (p *parser) tokenize() error {
+	text := p.text
+	for i := 0; i < len(text); {
+		c := rune(text[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case strings.HasPrefix(text[i:], "${"):
+			end := strings.Index(text[i:], "}")
+			if end == -1 {
+				return fmt.Errorf("invalid expression %s: missing } for variable", text)
+			}
+			name := strings.TrimSpace(text[i+2 : i+end])
+			if name == "" {
+				return fmt.Errorf("invalid expression %s: empty variable", text)
+			}
+			p.tokens = append(p.tokens, token{tokenVariable, name})
+			i += end + 1
+		case unicode.IsDigit(c) || c == '.':
+			start := i
+			for i < len(text) && (unicode.IsDigit(rune(text[i])) || text[i] == '.') {
+				i++
+			}
+			p.tokens = append(p.tokens, token{tokenNumber, text[start:i]})
+		case unicode.IsLetter(c):
+			start := i
+			for i < len(text) && (unicode.IsLetter(rune(text[i])) || unicode.IsDigit(rune(text[i]))) {
+				i++
+			}
+			p.tokens = append(p.tokens, token{tokenIdent, text[start:i]})
+		case strings.ContainsRune("+-*/%^(),", c):
+			p.tokens = append(p.tokens, token{tokenOperator, string(c)})
+			i++
+		default:
+			return fmt.Errorf("invalid expression %s: unexpected character %c", text, c)
+		}
+	}
+	return nil
+}
+
+func (p *parser) peek(operator string) bool {
+	return p.pos < len(p.tokens) && p.tokens[p.pos].kind == tokenOperator && p.tokens[p.pos].text == operator
+}
+
+func (p *parser) parseSum() (node, error) {
+	left, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek("+") || p.peek("-") {
+		operator := p.tokens[p.pos].text[0]
+		p.pos++
+		right, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		left = binary{operator, left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseProduct() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek("*") || p.peek("/") || p.peek("%") {
+		operator := p.tokens[p.pos].text[0]
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = binary{operator, left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.peek("-") {
+		p.pos++
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return negation{operand}, nil
+	}
+	return p.parsePower()
+}
+
+func (p *parser) parsePower() (node, error) {
+	base, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek("^") {
+		p.pos++
+		exponent, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return binary{'^', base, exponent}, nil
+	}
+	return base, nil
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("invalid expression %s: unexpected end", p.text)
+	}
+
+	t := p.tokens[p.pos]
+	p.pos++
+
+	switch t.kind {
+	case tokenNumber:
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expression %s: %s is not a number", p.text, t.text)
+		}
+		return number(f), nil
+	case tokenVariable:
+		p.addVariable(t.text)
+		return variable(t.text), nil
+	case tokenIdent:
+		return p.parseCall(t.text)
+	}
+
+	if t.text == "(" {
+		inner, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		if !p.peek(")") {
+			return nil, fmt.Errorf("invalid expression %s: missing )", p.text)
+		}
+		p.pos++
+		return inner, nil
+	}
+
+	return nil, fmt.Errorf("invalid expression %s: unexpected %s", p.text, t.text)
+}
+
+func (p *parser) parseCall(name string) (node, error) {
+	fn, ok := functions[name]
+	if !ok {
+		return nil, fmt.Errorf("invalid expression %s: unknown function %s", p.text, name)
+	}
+	if !p.peek("(") {
+		return nil, fmt.Errorf("invalid expression %s: expected ( after %s", p.text, name)
+	}
+	p.pos++
+
+	args := make([]node, 0)
+	for !p.peek(")") {
+		if len(args) > 0 {
+			if !p.peek(",") {
+				return nil, fmt.Errorf("invalid expression %s: expected , or ) in call to %s", p.text, name)
+			}
+			p.pos++
+		}
+		arg, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	p.pos++
+
+	if len(args) < fn.minArgs || (fn.maxArgs >= 0 && len(args) > fn.maxArgs) {
+		return nil, fmt.Errorf("invalid expression %s: wrong number of arguments for %s", p.text, name)
+	}
+
+	return call{name, fn, args}, nil
+}
+
+func (p *parser) addVariable(name string) {
+	for _, v := range p.variables {
+		if v == name {
+			return
+		}
+	}
+	p.variables = append(p.variables, name)
+}
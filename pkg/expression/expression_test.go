@@ -0,0 +1,111 @@
+package expression_test
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/soerenchrist/go_home/pkg/expression"
+)
+
+var values = map[string]float64{
+	"1.S1": 21.5,
+	"2.S1": 18.5,
+	"1.S2": 230,
+	"1.S3": 0.5,
+	"1.S4": 60,
+}
+
+func resolve(name string) (float64, error) {
+	if v, ok := values[name]; ok {
+		return v, nil
+	}
+	return 0, fmt.Errorf("no value for %s", name)
+}
+
+func TestEvaluate_ShouldCalculateResults(t *testing.T) {
+	expressions := []string{
+		"(${1.S1} + ${2.S1}) / 2",
+		"avg(${1.S1}, ${2.S1})",
+		"${1.S2} * ${1.S3}",
+		"1 + 2 * 3 - 4 / 2",
+		"-2 ^ 2",
+		"2 ^ 3 ^ 2",
+		"round(${1.S1} / 3, 2)",
+		"max(${1.S1}, ${2.S1}, 30) - min(1, -1)",
+		"10 % 4 + abs(-1) + sqrt(16) + sum(1, 2)",
+		"round(243.12 * (ln(${1.S4} / 100) + 17.62 * ${1.S1} / (243.12 + ${1.S1})) / (17.62 - (ln(${1.S4} / 100) + 17.62 * ${1.S1} / (243.12 + ${1.S1}))), 1)",
+	}
+	expected := []float64{20, 20, 115, 5, -4, 512, 7.17, 31, 10, 13.4}
+
+	for i, text := range expressions {
+		expr, err := expression.Parse(text)
+		if err != nil {
+			t.Errorf("Unexpected error for %s: %s", text, err)
+			continue
+		}
+
+		result, err := expr.Evaluate(resolve)
+		if err != nil {
+			t.Errorf("Unexpected error for %s: %s", text, err)
+			continue
+		}
+
+		if math.Abs(result-expected[i]) > 1e-9 {
+			t.Errorf("Expected %s to be %v, got %v", text, expected[i], result)
+		}
+	}
+}
+
+func TestVariables_ShouldReturnDistinctVariables(t *testing.T) {
+	expr, err := expression.Parse("${1.S1} + ${ 2.S1 } * ${1.S1}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	variables := expr.Variables()
+	if len(variables) != 2 || variables[0] != "1.S1" || variables[1] != "2.S1" {
+		t.Errorf("Expected [1.S1 2.S1], got %v", variables)
+	}
+}
+
+func TestParse_ShouldReturnError_WhenExpressionIsInvalid(t *testing.T) {
+	expressions := []string{
+		"",
+		"1 +",
+		"(1 + 2",
+		"1 2",
+		"${1.S1",
+		"${}",
+		"foo(1)",
+		"round(1, 2, 3)",
+		"avg()",
+		"1 & 2",
+		"1..2",
+	}
+
+	for _, text := range expressions {
+		if _, err := expression.Parse(text); err == nil {
+			t.Errorf("Expected error for %q", text)
+		}
+	}
+}
+
+func TestEvaluate_ShouldReturnError_WhenResultCannotBeCalculated(t *testing.T) {
+	expressions := []string{
+		"${1.S1} / 0",
+		"${9.S9} + 1",
+		"sqrt(-1)",
+	}
+
+	for _, text := range expressions {
+		expr, err := expression.Parse(text)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := expr.Evaluate(resolve); err == nil {
+			t.Errorf("Expected error for %s", text)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package jsonpath
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Path is a parsed subset of JSONPath, consisting of object keys and array
+// indices, e.g. `$.sensors[0].temperature`.
+type Path []segment
+
+type segment struct {
+	key     string
+	index   int
+	isIndex bool
+}
+
+func Parse(expression string) (Path, error) {
+	rest := strings.TrimPrefix(strings.TrimSpace(expression), "$")
+	if rest == "" {
+		return Path{}, nil
+	}
+
+	path := make(Path, 0)
+	for len(rest) > 0 {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end == -1 {
+				end = len(rest)
+			}
+			key := rest[:end]
+			if key == "" {
+				return nil, fmt.Errorf("invalid path %s: empty key", expression)
+			}
+			path = append(path, segment{key: key})
+			rest = rest[end:]
+		case '[':
+			end := strings.Index(rest, "]")
+			if end == -1 {
+				return nil, fmt.Errorf("invalid path %s: missing ]", expression)
+			}
+			inner := rest[1:end]
+			if len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0] {
+				path = append(path, segment{key: inner[1 : len(inner)-1]})
+			} else {
+				index, err := strconv.Atoi(inner)
+				if err != nil || index < 0 {
+					return nil, fmt.Errorf("invalid path %s: %s is not a valid index", expression, inner)
+				}
+				path = append(path, segment{index: index, isIndex: true})
+			}
+			rest = rest[end+1:]
+		default:
+			if len(path) > 0 {
+				return nil, fmt.Errorf("invalid path %s: unexpected %q", expression, rest[0])
+			}
+			rest = "." + rest
+		}
+	}
+
+	return path, nil
+}
+
+// Lookup resolves the path against a value decoded by encoding/json.
+func (path Path) Lookup(data any) (any, error) {
+	current := data
+	for _, s := range path {
+		if s.isIndex {
+			array, ok := current.([]any)
+			if !ok {
+				return nil, fmt.Errorf("cannot index non-array value with [%d]", s.index)
+			}
+			if s.index >= len(array) {
+				return nil, fmt.Errorf("index %d out of range", s.index)
+			}
+			current = array[s.index]
+			continue
+		}
+
+		object, ok := current.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("cannot read key %s from non-object value", s.key)
+		}
+		value, ok := object[s.key]
+		if !ok {
+			return nil, fmt.Errorf("key %s not found", s.key)
+		}
+		current = value
+	}
+
+	return current, nil
+}
@@ -0,0 +1,89 @@
+package jsonpath_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/soerenchrist/go_home/pkg/jsonpath"
+)
+
+const document = `{
+	"status": {"online": true, "name": "Living room"},
+	"sensors": [{"temp": 21.5}, {"temp": 19}],
+	"with.dot": 1
+}`
+
+func TestLookup_ShouldResolvePaths(t *testing.T) {
+	var data any
+	if err := json.Unmarshal([]byte(document), &data); err != nil {
+		t.Fatal(err)
+	}
+
+	expressions := []string{
+		"$.status.online",
+		"status.name",
+		"$.sensors[1].temp",
+		"$['with.dot']",
+		"$.sensors[0]['temp']",
+	}
+	expected := []any{true, "Living room", float64(19), float64(1), 21.5}
+
+	for i, expression := range expressions {
+		path, err := jsonpath.Parse(expression)
+		if err != nil {
+			t.Errorf("Failed to parse %s: %s", expression, err)
+			continue
+		}
+
+		result, err := path.Lookup(data)
+		if err != nil {
+			t.Errorf("Failed to lookup %s: %s", expression, err)
+			continue
+		}
+
+		if result != expected[i] {
+			t.Errorf("Expected %v for %s, got %v", expected[i], expression, result)
+		}
+	}
+}
+
+func TestParse_ShouldRejectInvalidPaths(t *testing.T) {
+	expressions := []string{
+		"$.",
+		"$.a..b",
+		"$.a[",
+		"$.a[x]",
+		"$.a[-1]",
+	}
+
+	for _, expression := range expressions {
+		if _, err := jsonpath.Parse(expression); err == nil {
+			t.Errorf("Expected error for %s, got none", expression)
+		}
+	}
+}
+
+func TestLookup_ShouldReturnError_WhenPathDoesNotMatch(t *testing.T) {
+	var data any
+	if err := json.Unmarshal([]byte(document), &data); err != nil {
+		t.Fatal(err)
+	}
+
+	expressions := []string{
+		"$.missing",
+		"$.sensors[5]",
+		"$.status[0]",
+		"$.sensors.temp",
+	}
+
+	for _, expression := range expressions {
+		path, err := jsonpath.Parse(expression)
+		if err != nil {
+			t.Fatalf("Failed to parse %s: %s", expression, err)
+		}
+
+		if _, err := path.Lookup(data); err == nil {
+			t.Errorf("Expected error for %s, got none", expression)
+		}
+	}
+}
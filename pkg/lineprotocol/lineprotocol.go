@@ -0,0 +1,171 @@
+// Package lineprotocol parses the InfluxDB line protocol, e.g.
+//
+//	weather,location=garden temperature=21.5,humidity=45i 1672574400000000000
+package lineprotocol
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Field struct {
+	Key string
+	// Value is a float64, int64, uint64, string or bool
+	Value any
+}
+
+type Point struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      []Field
+	// Timestamp is zero, if the line has no timestamp
+	Timestamp time.Time
+}
+
+// Parse reads all points from r. Timestamps are interpreted in the given
+// precision, e.g. time.Nanosecond or time.Second. Empty lines and comments are skipped.
+func Parse(r io.Reader, precision time.Duration) ([]Point, error) {
+	points := make([]Point, 0)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		point, err := ParseLine(text, precision)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		points = append(points, point)
+	}
+
+	return points, scanner.Err()
+}
+
+func ParseLine(line string, precision time.Duration) (Point, error) {
+	sections := splitUnescaped(line, ' ', true)
+	if len(sections) < 2 || len(sections) > 3 {
+		return Point{}, fmt.Errorf("expected measurement, fields and optional timestamp")
+	}
+
+	point := Point{Tags: make(map[string]string)}
+
+	key := splitUnescaped(sections[0], ',', false)
+	point.Measurement = unescape(key[0])
+	if point.Measurement == "" {
+		return Point{}, fmt.Errorf("missing measurement")
+	}
+	for _, tag := range key[1:] {
+		parts := splitUnescaped(tag, '=', false)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return Point{}, fmt.Errorf("invalid tag %s", tag)
+		}
+		point.Tags[unescape(parts[0])] = unescape(parts[1])
+	}
+
+	for _, field := range splitUnescaped(sections[1], ',', true) {
+		index := indexUnescaped(field, '=')
+		if index < 1 {
+			return Point{}, fmt.Errorf("invalid field %s", field)
+		}
+
+		value, err := parseFieldValue(field[index+1:])
+		if err != nil {
+			return Point{}, fmt.Errorf("invalid value of field %s: %v", unescape(field[:index]), err)
+		}
+		point.Fields = append(point.Fields, Field{Key: unescape(field[:index]), Value: value})
+	}
+
+	if len(sections) == 3 {
+		timestamp, err := strconv.ParseInt(sections[2], 10, 64)
+		if err != nil {
+			return Point{}, fmt.Errorf("invalid timestamp %s", sections[2])
+		}
+		point.Timestamp = time.Unix(0, timestamp*int64(precision)).UTC()
+	}
+
+	return point, nil
+}
+
+func parseFieldValue(text string) (any, error) {
+	if text == "" {
+		return nil, fmt.Errorf("missing value")
+	}
+
+	if text[0] == '"' {
+		if len(text) < 2 || text[len(text)-1] != '"' {
+			return nil, fmt.Errorf("unterminated string")
+		}
+		replacer := strings.NewReplacer(`\"`, `"`, `\\`, `\`)
+		return replacer.Replace(text[1 : len(text)-1]), nil
+	}
+
+	switch text {
+	case "t", "T", "true", "True", "TRUE":
+		return true, nil
+	case "f", "F", "false", "False", "FALSE":
+		return false, nil
+	}
+
+	switch text[len(text)-1] {
+	case 'i':
+		return strconv.ParseInt(text[:len(text)-1], 10, 64)
+	case 'u':
+		return strconv.ParseUint(text[:len(text)-1], 10, 64)
+	}
+
+	return strconv.ParseFloat(text, 64)
+}
+
+// splitUnescaped splits s at every sep, which is neither escaped by a
+// backslash nor, if quotes is set, inside a double quoted string. Repeated
+// separators are treated as one.
+func splitUnescaped(s string, sep byte, quotes bool) []string {
+	parts := make([]string, 0)
+	start := 0
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case quotes && s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			if i > start {
+				parts = append(parts, s[start:i])
+			}
+			start = i + 1
+		}
+	}
+	if start < len(s) {
+		parts = append(parts, s[start:])
+	}
+	if len(parts) == 0 {
+		parts = append(parts, "")
+	}
+	return parts
+}
+
+func indexUnescaped(s string, c byte) int {
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' {
+			i++
+		} else if s[i] == c {
+			return i
+		}
+	}
+	return -1
+}
+
+func unescape(s string) string {
+	return strings.NewReplacer(`\,`, ",", `\=`, "=", `\ `, " ").Replace(s)
+}
@@ -0,0 +1,80 @@
+package lineprotocol_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/soerenchrist/go_home/pkg/lineprotocol"
+)
+
+func TestParse_ShouldReadPoints(t *testing.T) {
+	input := `# comment
+weather,location=garden,sensor\ id=a\,b temperature=21.5,humidity=45i,raining=f 1672574400000000000
+
+cpu\ load,host=pi value=0.64
+status,host=pi message="up \"and\" running",code=200u,ok=true 1672574400
+`
+
+	points, err := lineprotocol.Parse(strings.NewReader(input), time.Nanosecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(points) != 3 {
+		t.Fatalf("Expected 3 points, got %d", len(points))
+	}
+
+	expected := lineprotocol.Point{
+		Measurement: "weather",
+		Tags:        map[string]string{"location": "garden", "sensor id": "a,b"},
+		Fields: []lineprotocol.Field{
+			{Key: "temperature", Value: 21.5},
+			{Key: "humidity", Value: int64(45)},
+			{Key: "raining", Value: false},
+		},
+		Timestamp: time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC),
+	}
+	if !reflect.DeepEqual(points[0], expected) {
+		t.Errorf("Expected %+v, got %+v", expected, points[0])
+	}
+
+	if points[1].Measurement != "cpu load" || !points[1].Timestamp.IsZero() {
+		t.Errorf("Expected measurement 'cpu load' without timestamp, got %+v", points[1])
+	}
+
+	fields := points[2].Fields
+	if fields[0].Value != `up "and" running` || fields[1].Value != uint64(200) || fields[2].Value != true {
+		t.Errorf("Unexpected fields %+v", fields)
+	}
+}
+
+func TestParseLine_ShouldUsePrecision(t *testing.T) {
+	point, err := lineprotocol.ParseLine("m value=1 1672574400", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !point.Timestamp.Equal(time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected timestamp %s", point.Timestamp)
+	}
+}
+
+func TestParse_ShouldRejectInvalidLines(t *testing.T) {
+	lines := []string{
+		"weather",
+		"weather temperature",
+		"weather,location temperature=1",
+		"weather temperature=warm",
+		`weather message="open`,
+		"weather temperature=1 yesterday",
+		"weather temperature=1 1 2",
+	}
+
+	for _, line := range lines {
+		if _, err := lineprotocol.Parse(strings.NewReader(line), time.Nanosecond); err == nil {
+			t.Errorf("Expected error for line %s", line)
+		}
+	}
+}
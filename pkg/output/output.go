@@ -6,11 +6,18 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
+type EventType string
+
+// EventAvailability is pushed with the value "true" or "false" when a sensor
+// becomes available or unavailable. Regular sensor values have no event type.
+const EventAvailability EventType = "availability"
+
 type BindingValue struct {
 	Timestamp time.Time
 	Value     string
 	SensorID  string
 	DeviceID  string
+	Event     EventType `json:",omitempty"`
 }
 
 type OutputBinding interface {
@@ -1,12 +1,15 @@
 package output
 
 import (
+	"sync"
+
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 	"github.com/rs/zerolog/log"
 )
 
 type WebsocketBinding struct {
+	mu   sync.Mutex
 	conn *websocket.Conn
 }
 
@@ -21,7 +24,16 @@ func NewWebsocketBinding(router *gin.Engine) *WebsocketBinding {
 	return binding
 }
 
+// Handle sends sensor values to the connected client. Events are not sent,
+// because the frontend expects every message to be a sensor value.
 func (b *WebsocketBinding) Handle(value BindingValue) {
+	if value.Event != "" {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
 	log.Debug().Interface("value", value).
 		Interface("conn", b.conn).Msg("Sending value to websocket")
 	if b.conn == nil {
@@ -39,11 +51,15 @@ func (b *WebsocketBinding) wsHandler(ctx *gin.Context) {
 		return
 	}
 
+	b.mu.Lock()
 	b.conn = c
+	b.mu.Unlock()
 
 	defer c.Close()
 	defer func() {
+		b.mu.Lock()
 		b.conn = nil
+		b.mu.Unlock()
 	}()
 
 	for {
@@ -0,0 +1,50 @@
+package output_test
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/soerenchrist/go_home/pkg/output"
+)
+
+func TestWebsocketBinding_ShouldNotSendEvents(t *testing.T) {
+	router := gin.New()
+	binding := output.NewWebsocketBinding(router)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// the connection is registered asynchronously, so values are pushed until one arrives
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(10 * time.Millisecond):
+				binding.Handle(output.BindingValue{SensorID: "S1", Value: "false", Event: output.EventAvailability})
+				binding.Handle(output.BindingValue{SensorID: "S1", Value: "21.5"})
+			}
+		}
+	}()
+
+	var received output.BindingValue
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := conn.ReadJSON(&received); err != nil {
+		t.Fatal(err)
+	}
+
+	if received.Value != "21.5" || received.Event != "" {
+		t.Errorf("Expected only the sensor value to be sent, got %+v", received)
+	}
+}
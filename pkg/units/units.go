@@ -0,0 +1,176 @@
+// Package units provides a registry of physical units and converts values
+// between units of the same quantity.
+package units
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type Quantity string
+
+const (
+	QuantityTemperature Quantity = "temperature"
+	QuantityPressure    Quantity = "pressure"
+	QuantityEnergy      Quantity = "energy"
+	QuantityPower       Quantity = "power"
+	QuantityPercentage  Quantity = "percentage"
+	QuantityLength      Quantity = "length"
+	QuantitySpeed       Quantity = "speed"
+	QuantityVolume      Quantity = "volume"
+	QuantityMass        Quantity = "mass"
+	QuantityDuration    Quantity = "duration"
+	QuantityVoltage     Quantity = "voltage"
+	QuantityCurrent     Quantity = "current"
+	QuantityFrequency   Quantity = "frequency"
+	QuantityIlluminance Quantity = "illuminance"
+)
+
+// Unit converts values linearly to the base unit of its quantity:
+// base = value * Factor + Offset.
+type Unit struct {
+	Symbol   string   `json:"symbol"`
+	Name     string   `json:"name"`
+	Quantity Quantity `json:"quantity"`
+	Aliases  []string `json:"aliases,omitempty"`
+	Factor   float64  `json:"-"`
+	Offset   float64  `json:"-"`
+}
+
+var registry = map[string]Unit{}
+
+func register(quantity Quantity, symbol string, name string, factor, offset float64, aliases ...string) {
+	unit := Unit{Symbol: symbol, Name: name, Quantity: quantity, Aliases: aliases, Factor: factor, Offset: offset}
+	for _, key := range append([]string{symbol, name}, aliases...) {
+		registry[strings.ToLower(key)] = unit
+	}
+}
+
+func init() {
+	register(QuantityTemperature, "°C", "Celsius", 1, 0, "C", "degC")
+	register(QuantityTemperature, "°F", "Fahrenheit", 5.0/9.0, -32*5.0/9.0, "F", "degF")
+	register(QuantityTemperature, "K", "Kelvin", 1, -273.15)
+
+	register(QuantityPressure, "Pa", "Pascal", 1, 0)
+	register(QuantityPressure, "hPa", "Hectopascal", 100, 0, "mbar")
+	register(QuantityPressure, "kPa", "Kilopascal", 1000, 0)
+	register(QuantityPressure, "bar", "Bar", 100000, 0)
+	register(QuantityPressure, "psi", "Pound per square inch", 6894.757293168, 0)
+
+	register(QuantityEnergy, "Wh", "Watt hour", 1, 0)
+	register(QuantityEnergy, "kWh", "Kilowatt hour", 1000, 0)
+	register(QuantityEnergy, "J", "Joule", 1.0/3600, 0)
+
+	register(QuantityPower, "W", "Watt", 1, 0)
+	register(QuantityPower, "kW", "Kilowatt", 1000, 0)
+
+	register(QuantityPercentage, "%", "Percent", 1, 0)
+
+	register(QuantityLength, "m", "Meter", 1, 0)
+	register(QuantityLength, "cm", "Centimeter", 0.01, 0)
+	register(QuantityLength, "mm", "Millimeter", 0.001, 0)
+	register(QuantityLength, "km", "Kilometer", 1000, 0)
+	register(QuantityLength, "in", "Inch", 0.0254, 0)
+	register(QuantityLength, "ft", "Foot", 0.3048, 0)
+
+	register(QuantitySpeed, "m/s", "Meter per second", 1, 0)
+	register(QuantitySpeed, "km/h", "Kilometer per hour", 1/3.6, 0)
+	register(QuantitySpeed, "mph", "Mile per hour", 0.44704, 0)
+
+	register(QuantityVolume, "l", "Liter", 1, 0)
+	register(QuantityVolume, "ml", "Milliliter", 0.001, 0)
+	register(QuantityVolume, "m³", "Cubic meter", 1000, 0, "m3")
+
+	register(QuantityMass, "kg", "Kilogram", 1, 0)
+	register(QuantityMass, "g", "Gram", 0.001, 0)
+
+	register(QuantityDuration, "s", "Second", 1, 0)
+	register(QuantityDuration, "ms", "Millisecond", 0.001, 0)
+	register(QuantityDuration, "min", "Minute", 60, 0)
+	register(QuantityDuration, "h", "Hour", 3600, 0)
+	register(QuantityDuration, "d", "Day", 86400, 0, "days")
+
+	register(QuantityVoltage, "V", "Volt", 1, 0)
+	register(QuantityVoltage, "mV", "Millivolt", 0.001, 0)
+
+	register(QuantityCurrent, "A", "Ampere", 1, 0)
+	register(QuantityCurrent, "mA", "Milliampere", 0.001, 0)
+
+	register(QuantityFrequency, "Hz", "Hertz", 1, 0)
+	register(QuantityFrequency, "kHz", "Kilohertz", 1000, 0)
+	register(QuantityFrequency, "MHz", "Megahertz", 1000000, 0)
+
+	register(QuantityIlluminance, "lx", "Lux", 1, 0)
+}
+
+// Lookup returns the unit with the given symbol, name or alias. Lookups are case insensitive.
+func Lookup(name string) (Unit, bool) {
+	unit, ok := registry[strings.ToLower(strings.TrimSpace(name))]
+	return unit, ok
+}
+
+// List returns all registered units ordered by quantity and symbol.
+func List() []Unit {
+	seen := make(map[string]bool)
+	list := make([]Unit, 0)
+	for _, unit := range registry {
+		if seen[unit.Symbol] {
+			continue
+		}
+		seen[unit.Symbol] = true
+		list = append(list, unit)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Quantity != list[j].Quantity {
+			return list[i].Quantity < list[j].Quantity
+		}
+		return list[i].Symbol < list[j].Symbol
+	})
+	return list
+}
+
+// Convert converts value from one unit to another unit of the same quantity.
+func Convert(value float64, from, to string) (float64, error) {
+	source, ok := Lookup(from)
+	if !ok {
+		return 0, fmt.Errorf("unknown unit %s", from)
+	}
+	target, ok := Lookup(to)
+	if !ok {
+		return 0, fmt.Errorf("unknown unit %s", to)
+	}
+	if source.Quantity != target.Quantity {
+		return 0, fmt.Errorf("cannot convert %s to %s", from, to)
+	}
+
+	base := value*source.Factor + source.Offset
+	return (base - target.Offset) / target.Factor, nil
+}
+
+// ParseQuantity parses a number followed by a unit, e.g. `20°C` or `1.5 kWh`.
+func ParseQuantity(text string) (float64, Unit, error) {
+	text = strings.TrimSpace(text)
+	end := 0
+	for end < len(text) && strings.ContainsRune("+-.0123456789eE", rune(text[end])) {
+		end++
+	}
+
+	// Walk back, until the prefix is a valid number (e.g. `2e` in `2eV`)
+	for ; end > 0; end-- {
+		value, err := strconv.ParseFloat(text[:end], 64)
+		if err != nil {
+			continue
+		}
+
+		unit, ok := Lookup(text[end:])
+		if !ok {
+			return 0, Unit{}, fmt.Errorf("unknown unit in %s", text)
+		}
+		return value, unit, nil
+	}
+
+	return 0, Unit{}, fmt.Errorf("%s is not a quantity", text)
+}
@@ -0,0 +1,89 @@
+package units_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/soerenchrist/go_home/pkg/units"
+)
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		value    float64
+		from     string
+		to       string
+		expected float64
+	}{
+		{100, "Celsius", "Fahrenheit", 212},
+		{32, "°F", "°C", 0},
+		{0, "celsius", "K", 273.15},
+		{1.5, "km", "m", 1500},
+		{1013.25, "hPa", "bar", 1.01325},
+		{36, "km/h", "m/s", 10},
+		{2.5, "kWh", "Wh", 2500},
+	}
+
+	for _, test := range tests {
+		result, err := units.Convert(test.value, test.from, test.to)
+		if err != nil {
+			t.Errorf("Unexpected error converting %s to %s: %s", test.from, test.to, err)
+			continue
+		}
+		if math.Abs(result-test.expected) > 1e-9 {
+			t.Errorf("Expected %v %s to be %v %s, got %v", test.value, test.from, test.expected, test.to, result)
+		}
+	}
+}
+
+func TestConvert_ShouldFail_WhenUnitsAreIncompatible(t *testing.T) {
+	if _, err := units.Convert(1, "Celsius", "m"); err == nil {
+		t.Error("Expected error when converting between quantities")
+	}
+	if _, err := units.Convert(1, "furlong", "m"); err == nil {
+		t.Error("Expected error for unknown unit")
+	}
+}
+
+func TestLookup_ShouldReturnCanonicalSymbol(t *testing.T) {
+	for _, name := range []string{"°C", "celsius", "C", "degC"} {
+		unit, ok := units.Lookup(name)
+		if !ok || unit.Symbol != "°C" {
+			t.Errorf("Expected %s to be °C, got %v", name, unit)
+		}
+	}
+
+	if _, ok := units.Lookup("XX"); ok {
+		t.Error("Expected XX to be unknown")
+	}
+}
+
+func TestParseQuantity(t *testing.T) {
+	tests := []struct {
+		text   string
+		value  float64
+		symbol string
+	}{
+		{"20°C", 20, "°C"},
+		{"-3.5 °F", -3.5, "°F"},
+		{"1.5kWh", 1.5, "kWh"},
+		{"2e3 ms", 2000, "ms"},
+		{"75%", 75, "%"},
+	}
+
+	for _, test := range tests {
+		value, unit, err := units.ParseQuantity(test.text)
+		if err != nil {
+			t.Errorf("Unexpected error parsing %s: %s", test.text, err)
+			continue
+		}
+		if value != test.value || unit.Symbol != test.symbol {
+			t.Errorf("Expected %s to be %v %s, got %v %s", test.text, test.value, test.symbol, value, unit.Symbol)
+		}
+	}
+
+	for _, text := range []string{"20", "°C", "20 parsecs"} {
+		if _, _, err := units.ParseQuantity(text); err == nil {
+			t.Errorf("Expected error parsing %s", text)
+		}
+	}
+}
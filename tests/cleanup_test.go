@@ -0,0 +1,78 @@
+package tests
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/magiconair/properties/assert"
+	"github.com/soerenchrist/go_home/internal/background"
+	"github.com/soerenchrist/go_home/internal/value"
+)
+
+func TestCleanup_ShouldDeleteExpiredValuesInBatches(t *testing.T) {
+	database := CreateTestDatabase(t.Name())
+	now := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		expired := &value.SensorValue{SensorID: "S1", Value: "1", DeviceID: "1", Timestamp: now.Add(-2 * time.Hour), ExpiresAt: sql.NullTime{Time: now.Add(-time.Hour), Valid: true}}
+		if err := database.AddSensorValue(expired); err != nil {
+			t.Fatal(err)
+		}
+	}
+	kept := []*value.SensorValue{
+		{SensorID: "S1", Value: "2", DeviceID: "1", Timestamp: now.Add(-time.Minute), ExpiresAt: sql.NullTime{Time: now.Add(time.Hour), Valid: true}},
+		{SensorID: "S1", Value: "3", DeviceID: "1", Timestamp: now.Add(-time.Minute)},
+	}
+	if err := database.AddSensorValues(kept); err != nil {
+		t.Fatal(err)
+	}
+
+	cleanup := background.NewCleanup(database, time.Minute, 2)
+
+	// Expired values are kept, until they are rolled up
+	deleted, err := cleanup.Cleanup(context.Background(), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, deleted, int64(0))
+
+	if err := background.NewRollups(database, time.Minute, nil).Rollup(context.Background(), now); err != nil {
+		t.Fatal(err)
+	}
+	deleted, err = cleanup.Cleanup(context.Background(), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, deleted, int64(5))
+
+	values, err := database.QuerySensorValues(&value.Query{DeviceID: "1", SensorID: "S1", From: now.Add(-24 * time.Hour), To: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(values), 2)
+
+	stats := cleanup.Stats()
+	assert.Equal(t, stats.Runs, uint64(2))
+	assert.Equal(t, stats.Deleted, uint64(5))
+	assert.Equal(t, stats.LastDeleted, int64(5))
+}
+
+func TestCleanup_ShouldStop_WhenContextIsCancelled(t *testing.T) {
+	database := CreateTestDatabase(t.Name())
+	cleanup := background.NewCleanup(database, time.Millisecond, 10)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- cleanup.Run(ctx)
+	}()
+	cancel()
+
+	select {
+	case err := <-done:
+		assert.Equal(t, err, nil)
+	case <-time.After(time.Second):
+		t.Fatal("cleanup did not stop")
+	}
+}
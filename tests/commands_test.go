@@ -2,11 +2,17 @@ package tests
 
 import (
 	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/magiconair/properties/assert"
 	"github.com/soerenchrist/go_home/internal/command"
 	"github.com/soerenchrist/go_home/internal/db"
+	"github.com/soerenchrist/go_home/internal/server"
+	"github.com/soerenchrist/go_home/pkg/output"
 )
 
 func TestListCommands_ShouldReturn404_WhenDeviceDoesNotExist(t *testing.T) {
@@ -88,6 +94,8 @@ func TestCreateCommand_ShouldReturn400_WhenBodyIsInvalid(t *testing.T) {
 		`{"name": "Test", "endpoint": "http://localhost:8080"}`,
 		`{"name": "Test", "endpoint": "http://localhost:8080", "payload_template": "on"}`,
 		`{"name": "Test", "endpoint": "http://localhost:8080", "payload_template": "on", "method": "TEST"}`,
+		`{"name": "Test", "endpoint": "http://localhost:8080/{{.p_id", "payload_template": "on", "method": "POST"}`,
+		`{"name": "Test", "endpoint": "http://localhost:8080", "payload_template": "{{unknown .p_id}}", "method": "POST"}`,
 	}
 	messages := []string{
 		"Name is required",
@@ -96,6 +104,8 @@ func TestCreateCommand_ShouldReturn400_WhenBodyIsInvalid(t *testing.T) {
 		"Payload template is required",
 		"Method must be one of GET, POST, PUT or DELETE",
 		"Method must be one of GET, POST, PUT or DELETE",
+		"Invalid endpoint template: template: endpoint:1: unclosed action",
+		"Invalid payload template: template: payload:1: function \"unknown\" not defined",
 	}
 
 	for i, body := range bodies {
@@ -188,3 +198,200 @@ func TestInvokeCommand_ShouldReturn404_WhenCommandDoesNotExist(t *testing.T) {
 	assert.Equal(t, w.Code, 404)
 	assertErrorMessageEquals(t, w.Body.Bytes(), "Command not found")
 }
+
+func TestInvokeCommand_ShouldNotBeCutOff_ByWriteTimeout(t *testing.T) {
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		fmt.Fprint(w, "done")
+	}))
+	defer endpoint.Close()
+
+	database := CreateTestDatabase(t.Name())
+	if err := database.AddCommand(&command.Command{ID: "C2", Name: "Slow", DeviceID: "1", Endpoint: endpoint.URL, Method: "POST"}); err != nil {
+		t.Fatal(err)
+	}
+	dispatcher := command.NewDispatcher(database, 1, 1)
+	dispatcher.Start()
+	defer dispatcher.Stop()
+
+	router := server.NewRouter(database, output.NewManager(), dispatcher, server.RouterOptions{})
+	s := httptest.NewUnstartedServer(router)
+	s.Config.WriteTimeout = 50 * time.Millisecond
+	s.Start()
+	defer s.Close()
+
+	res, err := http.Post(s.URL+"/api/v1/devices/1/commands/C2/invoke", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	assert.Equal(t, res.StatusCode, 200)
+
+	var result command.InvocationResult
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, result.Response, "done")
+}
+
+func TestGetCommandQueue_ShouldReturnQueueStats(t *testing.T) {
+	w := RecordGetCall(t, "/api/v1/commands/queue")
+
+	assert.Equal(t, w.Code, 200)
+
+	var stats command.QueueStats
+	err := json.Unmarshal(w.Body.Bytes(), &stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, stats.Workers, 2)
+	assert.Equal(t, stats.Capacity, 10)
+	assert.Equal(t, stats.Queued, 0)
+}
+
+func TestCreateCommand_ShouldReturn409_WhenCommandDoesAlreadyExist(t *testing.T) {
+	body := `{
+		"id": "C1",
+		"name": "Test",
+		"endpoint": "http://localhost:8080",
+		"payload_template": "on",
+		"method": "POST"
+	}`
+
+	w := RecordPostCall(t, "/api/v1/devices/1/commands", body)
+
+	assert.Equal(t, w.Code, 409)
+	assertErrorMessageEquals(t, w.Body.Bytes(), "Command with id C1 does already exist")
+}
+
+func TestCreateCommand_ShouldReturn409_WhenCommandIdIsUsedByOtherDevice(t *testing.T) {
+	body := `{
+		"id": "C1",
+		"name": "Test",
+		"endpoint": "http://localhost:8080",
+		"payload_template": "on",
+		"method": "POST"
+	}`
+
+	w := RecordPostCall(t, "/api/v1/devices/2/commands", body)
+
+	assert.Equal(t, w.Code, 409)
+	assertErrorMessageEquals(t, w.Body.Bytes(), "Command with id C1 does already exist")
+}
+
+func TestUpdateCommand_ShouldReturn404_WhenCommandDoesNotExist(t *testing.T) {
+	w := RecordPutCallWithDb(t, "/api/v1/devices/1/commands/C2", `{}`, nil)
+
+	assert.Equal(t, w.Code, 404)
+	assertErrorMessageEquals(t, w.Body.Bytes(), "Command not found")
+}
+
+func TestUpdateCommand_ShouldReturn400_WhenCommandIsInvalid(t *testing.T) {
+	body := `{
+		"name": "Test",
+		"endpoint": "http://localhost:8080",
+		"payload_template": "on"
+	}`
+
+	w := RecordPutCallWithDb(t, "/api/v1/devices/1/commands/C1", body, nil)
+
+	assert.Equal(t, w.Code, 400)
+	assertErrorMessageEquals(t, w.Body.Bytes(), "Method must be one of GET, POST, PUT or DELETE")
+}
+
+func TestUpdateCommand_ShouldReplaceCommand(t *testing.T) {
+	body := `{
+		"name": "Turn off",
+		"endpoint": "http://localhost:8080/off",
+		"payload_template": "off",
+		"method": "PUT"
+	}`
+
+	validator := func(database db.Database) {
+		c, err := database.GetCommand("1", "C1")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, c.Name, "Turn off")
+		assert.Equal(t, c.Endpoint, "http://localhost:8080/off")
+		assert.Equal(t, c.PayloadTemplate, "off")
+		assert.Equal(t, c.Method, "PUT")
+	}
+
+	w := RecordPutCallWithDb(t, "/api/v1/devices/1/commands/C1", body, validator)
+
+	assert.Equal(t, w.Code, 200)
+}
+
+func TestPatchCommand_ShouldOnlyUpdateGivenFields(t *testing.T) {
+	body := `{
+		"method": "PUT"
+	}`
+
+	validator := func(database db.Database) {
+		c, err := database.GetCommand("1", "C1")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, c.Name, "Turn on")
+		assert.Equal(t, c.Endpoint, "http://localhost:8080/echo")
+		assert.Equal(t, c.Method, "PUT")
+	}
+
+	w := RecordPatchCallWithDb(t, "/api/v1/devices/1/commands/C1", body, validator)
+
+	assert.Equal(t, w.Code, 200)
+}
+
+func TestCreateCommand_ShouldReturn400_WhenWolCommandIsInvalid(t *testing.T) {
+	bodies := []string{
+		`{"name": "Wake", "type": "smoke_signal"}`,
+		`{"name": "Wake", "type": "wol"}`,
+		`{"name": "Wake", "type": "wol", "mac_address": "00:11:22"}`,
+		`{"name": "Wake", "type": "wol", "mac_address": "00:11:22:33:44:55", "broadcast_address": "everyone"}`,
+		`{"name": "Wake", "type": "wol", "mac_address": "00:11:22:33:44:55", "port": 70000}`,
+		`{"name": "Wake", "type": "wol", "mac_address": "00:11:22:33:44:55", "port": 0}`,
+	}
+	messages := []string{
+		"Type must be one of http or wol",
+		"MAC address is required",
+		"Invalid MAC address: 00:11:22",
+		"Invalid broadcast address: everyone",
+		"Port must be between 1 and 65535",
+		"Port must be between 1 and 65535",
+	}
+
+	for i, body := range bodies {
+		w := RecordPostCall(t, "/api/v1/devices/1/commands", body)
+
+		assert.Equal(t, w.Code, 400)
+		assertErrorMessageEquals(t, w.Body.Bytes(), messages[i])
+	}
+}
+
+func TestCreateCommand_ShouldApplyDefaults_WhenWolCommandIsCreated(t *testing.T) {
+	body := `{
+		"id": "wake",
+		"name": "Wake up",
+		"type": "wol",
+		"mac_address": "00:11:22:33:44:55"
+	}`
+
+	w := RecordPostCall(t, "/api/v1/devices/1/commands", body)
+
+	assert.Equal(t, w.Code, 201)
+
+	var c command.Command
+	err := json.Unmarshal(w.Body.Bytes(), &c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, c.Type, command.CommandTypeWol)
+	assert.Equal(t, c.MacAddress, "00:11:22:33:44:55")
+	assert.Equal(t, c.BroadcastAddress, "255.255.255.255")
+	assert.Equal(t, c.Port, 9)
+}
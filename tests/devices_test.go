@@ -134,9 +134,42 @@ func TestDeleteDevice_ShouldReturn204AndDelete_WhenTheGivenIdDoesExist(t *testin
 		assert.Equal(t, 1, len(devices))
 		assert.Equal(t, "My Device 2", devices[0].Name)
 		assert.Equal(t, "2", devices[0].ID)
+
+		sensors, err := database.ListSensors("1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, 0, len(sensors))
 	}
 
 	w := RecordDeleteCallWithDb(t, "/api/v1/devices/1", validator)
 
 	assert.Equal(t, w.Code, 204)
 }
+
+func TestUpdateDevice_ShouldReturn404_WhenTheGivenIdDoesNotExist(t *testing.T) {
+	w := RecordPutCallWithDb(t, "/api/v1/devices/123", `{"name": "Test Device"}`, nil)
+
+	assert.Equal(t, w.Code, 404)
+}
+
+func TestUpdateDevice_ShouldReturn400_WhenNameIsNotValid(t *testing.T) {
+	w := RecordPutCallWithDb(t, "/api/v1/devices/1", `{"name": "XX"}`, nil)
+
+	assert.Equal(t, w.Code, 400)
+}
+
+func TestUpdateDevice_ShouldUpdateName(t *testing.T) {
+	validator := func(database db.Database) {
+		d, err := database.GetDevice("1")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, "Renamed Device", d.Name)
+	}
+
+	w := RecordPutCallWithDb(t, "/api/v1/devices/1", `{"name": "Renamed Device"}`, validator)
+
+	assert.Equal(t, w.Code, 200)
+}
@@ -0,0 +1,122 @@
+package tests
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/magiconair/properties/assert"
+	"github.com/soerenchrist/go_home/internal/command"
+	"github.com/soerenchrist/go_home/internal/db"
+	"github.com/soerenchrist/go_home/internal/server"
+	"github.com/soerenchrist/go_home/internal/value"
+	"github.com/soerenchrist/go_home/pkg/output"
+)
+
+var exportStart = time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func recordExport(t *testing.T, database db.Database, url string) *httptest.ResponseRecorder {
+	router := server.NewRouter(database, output.NewManager(), command.NewDispatcher(database, 1, 1), server.RouterOptions{})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+	return w
+}
+
+func TestExportSensorValues_ShouldStreamAllValuesAsCsv(t *testing.T) {
+	database := CreateTestDatabase(t.Name())
+	values := make([]*value.SensorValue, 0)
+	for i := 0; i < 2500; i++ {
+		values = append(values, &value.SensorValue{SensorID: "S1", DeviceID: "1", Value: fmt.Sprint(i), Timestamp: exportStart.Add(time.Duration(i) * time.Second)})
+	}
+	if err := database.AddSensorValues(values); err != nil {
+		t.Fatal(err)
+	}
+
+	w := recordExport(t, database, "/api/v1/devices/1/sensors/S1/values/export?format=csv&from=2023-05-01T12:00:00Z&to=2023-05-01T13:00:00Z")
+	assert.Equal(t, w.Code, 200)
+	assert.Equal(t, w.Header().Get("Content-Type"), "text/csv")
+
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	assert.Equal(t, len(lines), 2501)
+	assert.Equal(t, lines[0], "timestamp,value")
+	assert.Equal(t, lines[1], "2023-05-01T12:00:00Z,0")
+	assert.Equal(t, lines[2500], "2023-05-01T12:41:39Z,2499")
+}
+
+func TestExportSensorValues_ShouldJoinSensorsOnTimestamp(t *testing.T) {
+	database := CreateTestDatabase(t.Name())
+	values := []*value.SensorValue{
+		{SensorID: "S1", DeviceID: "1", Value: "21.5", Timestamp: exportStart},
+		{SensorID: "S3", DeviceID: "2", Value: "40", Timestamp: exportStart},
+		{SensorID: "S1", DeviceID: "1", Value: "22", Timestamp: exportStart.Add(time.Minute)},
+		{SensorID: "S3", DeviceID: "2", Value: "41", Timestamp: exportStart.Add(2 * time.Minute)},
+	}
+	if err := database.AddSensorValues(values); err != nil {
+		t.Fatal(err)
+	}
+
+	url := "/api/v1/values/export?sensors=1.S1,2.S3&from=2023-05-01T12:00:00Z&to=2023-05-01T13:00:00Z"
+	w := recordExport(t, database, url+"&format=csv")
+	assert.Equal(t, w.Code, 200)
+	assert.Equal(t, w.Body.String(), "timestamp,1.S1,2.S3\n"+
+		"2023-05-01T12:00:00Z,21.5,40\n"+
+		"2023-05-01T12:01:00Z,22,\n"+
+		"2023-05-01T12:02:00Z,,41\n")
+
+	w = recordExport(t, database, url+"&format=ndjson")
+	assert.Equal(t, w.Code, 200)
+	assert.Equal(t, w.Header().Get("Content-Type"), "application/x-ndjson")
+	assert.Equal(t, w.Body.String(), `{"1.S1":"21.5","2.S3":"40","timestamp":"2023-05-01T12:00:00Z"}`+"\n"+
+		`{"1.S1":"22","timestamp":"2023-05-01T12:01:00Z"}`+"\n"+
+		`{"2.S3":"41","timestamp":"2023-05-01T12:02:00Z"}`+"\n")
+}
+
+func TestExportSensorValues_ShouldReturnError_WhenRequestIsInvalid(t *testing.T) {
+	expected := map[string]int{
+		"/api/v1/devices/1/sensors/S1/values/export?format=xlsx": 400,
+		"/api/v1/devices/1/sensors/S1/values/export?from=today":  400,
+		"/api/v1/devices/1/sensors/S9/values/export":             404,
+		"/api/v1/values/export":                                  400,
+		"/api/v1/values/export?sensors=S1":                       400,
+		"/api/v1/values/export?sensors=1.S1,1.S9":                404,
+	}
+
+	for url, code := range expected {
+		w := RecordGetCall(t, url)
+		assert.Equal(t, w.Code, code, url)
+	}
+}
+
+func TestExportSensorValues_ShouldNotBeCutOff_ByWriteTimeout(t *testing.T) {
+	database := CreateTestDatabase(t.Name())
+	values := make([]*value.SensorValue, 0)
+	for i := 0; i < 20000; i++ {
+		values = append(values, &value.SensorValue{SensorID: "S1", DeviceID: "1", Value: fmt.Sprint(i), Timestamp: exportStart.Add(time.Duration(i) * time.Minute)})
+	}
+	if err := database.AddSensorValues(values); err != nil {
+		t.Fatal(err)
+	}
+
+	router := server.NewRouter(database, output.NewManager(), command.NewDispatcher(database, 1, 1), server.RouterOptions{})
+	s := httptest.NewUnstartedServer(router)
+	s.Config.WriteTimeout = 50 * time.Millisecond
+	s.Start()
+	defer s.Close()
+
+	res, err := http.Get(s.URL + "/api/v1/devices/1/sensors/S1/values/export?from=2023-01-01T00:00:00Z&to=2024-01-01T00:00:00Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+	assert.Equal(t, len(lines), 20001)
+}
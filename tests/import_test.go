@@ -0,0 +1,134 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/magiconair/properties/assert"
+	"github.com/soerenchrist/go_home/internal/background"
+	"github.com/soerenchrist/go_home/internal/command"
+	"github.com/soerenchrist/go_home/internal/sensor"
+	"github.com/soerenchrist/go_home/internal/server"
+	"github.com/soerenchrist/go_home/internal/value"
+	"github.com/soerenchrist/go_home/pkg/output"
+)
+
+func TestImportSensorValues_ShouldStoreValidRows_WithoutPushingThem(t *testing.T) {
+	database := CreateTestDatabase(t.Name())
+	bindings := output.NewManager()
+	recorder := &recordingBinding{}
+	bindings.Register(recorder)
+	router := server.NewRouter(database, bindings, command.NewDispatcher(database, 1, 1), server.RouterOptions{})
+
+	// The imported range is rolled up already
+	later := time.Date(2023, 5, 3, 0, 0, 0, 0, time.UTC)
+	if err := database.AddSensorValue(&value.SensorValue{SensorID: "S3", Value: "1", DeviceID: "2", Timestamp: later}); err != nil {
+		t.Fatal(err)
+	}
+	if err := background.NewRollups(database, time.Minute, nil).Rollup(context.Background(), later.Add(24*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	body := "time;level;note\n" +
+		"1682942400;40;first\n" +
+		"1682944200;full;invalid\n" +
+		"1682946000;50\n" +
+		"yesterday;60\n" +
+		"1682947800;70\n"
+
+	w := httptest.NewRecorder()
+	url := "/api/v1/devices/2/sensors/S3/values/import?timestamp_column=time&value_column=level&timestamp_format=unix&delimiter=%3B"
+	router.ServeHTTP(w, httptest.NewRequest("POST", url, strings.NewReader(body)))
+	assert.Equal(t, w.Code, 200)
+
+	var result value.ImportResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, result.Imported, 3)
+	assert.Equal(t, result.Invalid, 2)
+	assert.Equal(t, result.Errors, []value.ImportError{
+		{Line: 3, Error: "Sensor value is not an int"},
+		{Line: 5, Error: "yesterday is not a valid timestamp"},
+	})
+	assert.Equal(t, len(recorder.values), 0)
+
+	start := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	values, err := database.QuerySensorValues(&value.Query{DeviceID: "2", SensorID: "S3", From: start, To: start.Add(24 * time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(values), 3)
+	assert.Equal(t, values[0].Value, "40")
+
+	// Backfilled values are rolled up right away, as the daily buckets are read from the tier
+	buckets, err := database.AggregateSensorValues(&value.Query{DeviceID: "2", SensorID: "S3", From: start, To: start.Add(24 * time.Hour), Step: 24 * time.Hour, Aggregation: value.AggregationAvg, Numeric: true, Tier: &value.TierDaily})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, buckets, []value.Bucket{{Timestamp: time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC), Value: "53.333333333333336", Count: 3}})
+}
+
+func TestImportSensorValues_ShouldReturn400_WhenColumnIsMissing(t *testing.T) {
+	w := RecordPostCall(t, "/api/v1/devices/2/sensors/S3/values/import?value_column=level", "timestamp,value\n2023-05-01T12:00:00Z,1\n")
+	assert.Equal(t, w.Code, 400)
+	assertErrorMessageEquals(t, w.Body.Bytes(), "Column level not found")
+
+	w = RecordPostCall(t, "/api/v1/devices/2/sensors/S3/values/import?delimiter=%3B%3B", "timestamp,value\n")
+	assert.Equal(t, w.Code, 400)
+	assertErrorMessageEquals(t, w.Body.Bytes(), "Delimiter must be a single character")
+}
+
+func TestImportSensorValues_ShouldReturn400_WhenSensorIsComputed(t *testing.T) {
+	database := CreateTestDatabase(t.Name())
+	err := database.AddSensor(&sensor.Sensor{ID: "C1", DeviceID: "1", Name: "Computed", DataType: sensor.DataTypeFloat, Type: sensor.SensorTypeComputed, IsActive: true, Expression: "${1.S1} * 2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := server.NewRouter(database, output.NewManager(), command.NewDispatcher(database, 1, 1), server.RouterOptions{})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/devices/1/sensors/C1/values/import", strings.NewReader("timestamp,value\n2023-05-01T12:00:00Z,1\n")))
+	assert.Equal(t, w.Code, 400)
+	assertErrorMessageEquals(t, w.Body.Bytes(), "Values of computed sensors cannot be set")
+}
+
+func TestImportSensorValues_ShouldNotBeCutOff_ByReadTimeout(t *testing.T) {
+	database := CreateTestDatabase(t.Name())
+	router := server.NewRouter(database, output.NewManager(), command.NewDispatcher(database, 1, 1), server.RouterOptions{})
+	s := httptest.NewUnstartedServer(router)
+	s.Config.ReadTimeout = 100 * time.Millisecond
+	s.Start()
+	defer s.Close()
+
+	// The file is uploaded slower than the read timeout of the server
+	body, upload := io.Pipe()
+	go func() {
+		upload.Write([]byte("timestamp,value\n"))
+		for i := 0; i < 5; i++ {
+			time.Sleep(50 * time.Millisecond)
+			fmt.Fprintf(upload, "2023-05-01T12:0%d:00Z,%d\n", i, i)
+		}
+		upload.Close()
+	}()
+
+	res, err := http.Post(s.URL+"/api/v1/devices/2/sensors/S3/values/import", "text/csv", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	assert.Equal(t, res.StatusCode, 200)
+
+	var result value.ImportResult
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, result.Imported, 5)
+}
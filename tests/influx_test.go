@@ -0,0 +1,105 @@
+package tests
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/magiconair/properties/assert"
+	"github.com/soerenchrist/go_home/internal/command"
+	"github.com/soerenchrist/go_home/internal/db"
+	"github.com/soerenchrist/go_home/internal/influx"
+	"github.com/soerenchrist/go_home/internal/sensor"
+	"github.com/soerenchrist/go_home/internal/server"
+	"github.com/soerenchrist/go_home/pkg/output"
+)
+
+func newInfluxRouter(t *testing.T, config *influx.Config) (db.Database, *gin.Engine) {
+	database := CreateTestDatabase(t.Name())
+	router := server.NewRouter(database, output.NewManager(), command.NewDispatcher(database, 1, 1), server.RouterOptions{Influx: config})
+	return database, router
+}
+
+func writeLines(router *gin.Engine, url string, lines string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", url, strings.NewReader(lines)))
+	return w
+}
+
+func TestWrite_ShouldStoreValues_UsingMappings(t *testing.T) {
+	database, router := newInfluxRouter(t, &influx.Config{
+		Mappings: []influx.Mapping{{Measurement: "climate", Device: "{tag.room}", Sensor: "{field}"}},
+	})
+
+	w := writeLines(router, "/api/v1/write?precision=s", "climate,room=1 S1=21.5 1672574400\nlevel,device=2 S3=40i 1672574460\n")
+	assert.Equal(t, w.Code, 400)
+	assertErrorMessageEquals(t, w.Body.Bytes(), "Partial write: 1 of 2 fields could not be stored")
+
+	current, err := database.GetCurrentSensorValue("1", "S1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, current.Value, "21.5")
+	assert.Equal(t, current.Timestamp.Equal(time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)), true)
+
+	w = writeLines(router, "/api/v1/write", "climate,room=1 S1=22")
+	assert.Equal(t, w.Code, 204)
+}
+
+func TestWrite_ShouldCreateSensors_WhenAutoCreateIsEnabled(t *testing.T) {
+	database, router := newInfluxRouter(t, &influx.Config{AutoCreate: true})
+
+	w := writeLines(router, "/api/v1/write", "weather,device=garden temperature=12.5,raining=t,station=\"north\"")
+	assert.Equal(t, w.Code, 204)
+
+	expected := map[string]sensor.DataType{
+		"weather_temperature": sensor.DataTypeFloat,
+		"weather_raining":     sensor.DataTypeBool,
+		"weather_station":     sensor.DataTypeString,
+	}
+	for id, dataType := range expected {
+		s, err := database.GetSensor("garden", id)
+		if err != nil {
+			t.Fatalf("Expected sensor %s to be created: %s", id, err)
+		}
+		assert.Equal(t, s.DataType, dataType)
+	}
+
+	current, err := database.GetCurrentSensorValue("garden", "weather_raining")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, current.Value, "true")
+}
+
+func TestWrite_ShouldReturn400_WhenBodyIsInvalid(t *testing.T) {
+	_, router := newInfluxRouter(t, nil)
+
+	w := writeLines(router, "/api/v1/write", "weather,device=1 S1=warm")
+	assert.Equal(t, w.Code, 400)
+	assertErrorMessageEquals(t, w.Body.Bytes(), `Invalid line protocol: line 1: invalid value of field S1: strconv.ParseFloat: parsing "warm": invalid syntax`)
+
+	w = writeLines(router, "/api/v1/write?precision=h", "weather,device=1 S1=1")
+	assert.Equal(t, w.Code, 400)
+	assertErrorMessageEquals(t, w.Body.Bytes(), "Invalid precision h - Should be one of ns, us, ms or s")
+}
+
+func TestWrite_ShouldValidateAutoCreatedSensors(t *testing.T) {
+	database, router := newInfluxRouter(t, &influx.Config{AutoCreate: true})
+
+	w := writeLines(router, "/api/v1/write", "cpu,device=pi load=0.5,idle=90\ncpu,device=a/b load=0.7")
+	assert.Equal(t, w.Code, 400)
+	assertErrorMessageEquals(t, w.Body.Bytes(), "Partial write: 1 of 3 fields could not be stored")
+
+	d, err := database.GetDevice("pi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, d.Name, "Device pi")
+
+	if _, err := database.GetDevice("a/b"); err == nil {
+		t.Errorf("Expected device with invalid id not to be created")
+	}
+}
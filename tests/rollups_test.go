@@ -0,0 +1,215 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/magiconair/properties/assert"
+	"github.com/soerenchrist/go_home/internal/background"
+	"github.com/soerenchrist/go_home/internal/command"
+	"github.com/soerenchrist/go_home/internal/server"
+	"github.com/soerenchrist/go_home/internal/value"
+	"github.com/soerenchrist/go_home/pkg/output"
+)
+
+func TestRollups_ShouldServeHistoryFromTiers(t *testing.T) {
+	database := CreateTestDatabase(t.Name())
+	start := time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)
+	for i, v := range []string{"1", "3", "5", "10"} {
+		timestamp := start.Add(time.Duration(i) * 30 * time.Minute)
+		if err := database.AddSensorValue(&value.SensorValue{SensorID: "S1", Value: v, DeviceID: "1", Timestamp: timestamp}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rollups := background.NewRollups(database, time.Minute, nil)
+	if err := rollups.Rollup(context.Background(), start.Add(48*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Values of rolled up hours are only read from the tiers
+	if err := database.AddSensorValue(&value.SensorValue{SensorID: "S1", Value: "100", DeviceID: "1", Timestamp: start.Add(10 * time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+
+	router := server.NewRouter(database, output.NewManager(), command.NewDispatcher(database, 1, 1), server.RouterOptions{})
+	expected := map[string][]value.Bucket{
+		"step=1h&agg=avg": {
+			{Timestamp: start, Value: "2", Count: 2},
+			{Timestamp: start.Add(time.Hour), Value: "7.5", Count: 2},
+		},
+		"step=1h&agg=max": {
+			{Timestamp: start, Value: "3", Count: 2},
+			{Timestamp: start.Add(time.Hour), Value: "10", Count: 2},
+		},
+		"step=24h&agg=avg": {
+			{Timestamp: start, Value: "4.75", Count: 4},
+		},
+		"step=24h&agg=count": {
+			{Timestamp: start, Value: "4", Count: 4},
+		},
+		// last is always read from the raw values
+		"step=24h&agg=last": {
+			{Timestamp: start, Value: "10", Count: 5},
+		},
+	}
+
+	for query, buckets := range expected {
+		w := httptest.NewRecorder()
+		url := "/api/v1/devices/1/sensors/S1/values?from=2023-05-01T00:00:00Z&to=2023-05-02T00:00:00Z&" + query
+		router.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		assert.Equal(t, w.Code, 200)
+
+		var result []value.Bucket
+		if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, result, buckets, query)
+	}
+}
+
+func TestRollups_ShouldReadRecentBucketsFromRawValues(t *testing.T) {
+	database := CreateTestDatabase(t.Name())
+	start := time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)
+	for i, v := range []string{"1", "3", "5", "10"} {
+		timestamp := start.Add(time.Duration(i) * 30 * time.Minute)
+		if err := database.AddSensorValue(&value.SensorValue{SensorID: "S1", Value: v, DeviceID: "1", Timestamp: timestamp}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Only the first hour is completed
+	rollups := background.NewRollups(database, time.Minute, nil)
+	if err := rollups.Rollup(context.Background(), start.Add(90*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	router := server.NewRouter(database, output.NewManager(), command.NewDispatcher(database, 1, 1), server.RouterOptions{})
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/devices/1/sensors/S1/values?from=2023-05-01T00:00:00Z&to=2023-05-01T02:00:00Z&step=1h&agg=sum", nil))
+	assert.Equal(t, rec.Code, 200)
+
+	var result []value.Bucket
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, result, []value.Bucket{
+		{Timestamp: start, Value: "4", Count: 2},
+		{Timestamp: start.Add(time.Hour), Value: "15", Count: 2},
+	})
+}
+
+func TestRollups_ShouldReadCutBucketsFromRawValues(t *testing.T) {
+	database := CreateTestDatabase(t.Name())
+	start := time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)
+	for i, v := range []string{"1", "3", "5", "10", "20"} {
+		timestamp := start.Add(time.Duration(i) * 30 * time.Minute)
+		if err := database.AddSensorValue(&value.SensorValue{SensorID: "S1", Value: v, DeviceID: "1", Timestamp: timestamp}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rollups := background.NewRollups(database, time.Minute, nil)
+	if err := rollups.Rollup(context.Background(), start.Add(48*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	router := server.NewRouter(database, output.NewManager(), command.NewDispatcher(database, 1, 1), server.RouterOptions{})
+	expected := map[string][]value.Bucket{
+		"from=2023-05-01T00:15:00Z&to=2023-05-01T02:15:00Z&step=1h&agg=sum": {
+			{Timestamp: start, Value: "3", Count: 1},
+			{Timestamp: start.Add(time.Hour), Value: "15", Count: 2},
+			{Timestamp: start.Add(2 * time.Hour), Value: "20", Count: 1},
+		},
+		"from=2023-05-01T00:15:00Z&to=2023-05-02T00:00:00Z&step=24h&agg=sum": {
+			{Timestamp: start, Value: "38", Count: 4},
+		},
+	}
+
+	for query, buckets := range expected {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/devices/1/sensors/S1/values?"+query, nil))
+		assert.Equal(t, rec.Code, 200)
+
+		var result []value.Bucket
+		if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, result, buckets, query)
+	}
+}
+
+func TestRollups_ShouldDeleteBucketsAfterRetention(t *testing.T) {
+	database := CreateTestDatabase(t.Name())
+	start := time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)
+	if err := database.AddSensorValue(&value.SensorValue{SensorID: "S1", Value: "1", DeviceID: "1", Timestamp: start}); err != nil {
+		t.Fatal(err)
+	}
+
+	retention := map[string]time.Duration{value.TierHourly.Name: 24 * time.Hour}
+	rollups := background.NewRollups(database, time.Minute, retention)
+	if err := rollups.Rollup(context.Background(), start.Add(72*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	deleted, err := database.DeleteRollupsBefore(value.TierHourly, start.Add(72*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, deleted, int64(0))
+
+	deleted, err = database.DeleteRollupsBefore(value.TierDaily, start.Add(72*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, deleted, int64(1))
+}
+
+func TestRollups_ShouldRollUpLateValuesAgain(t *testing.T) {
+	database := CreateTestDatabase(t.Name())
+	start := time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)
+	for i, v := range []string{"1", "3", "5", "10"} {
+		timestamp := start.Add(time.Duration(i) * 30 * time.Minute)
+		if err := database.AddSensorValue(&value.SensorValue{SensorID: "S1", Value: v, DeviceID: "1", Timestamp: timestamp}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rollups := background.NewRollups(database, time.Minute, nil)
+	if err := rollups.Rollup(context.Background(), start.Add(48*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	router := server.NewRouter(database, output.NewManager(), command.NewDispatcher(database, 1, 1), server.RouterOptions{})
+	rec := httptest.NewRecorder()
+	body := `[{"device_id": "1", "sensor_id": "S1", "value": "100", "timestamp": "2023-05-01T00:45:00Z"}]`
+	router.ServeHTTP(rec, httptest.NewRequest("POST", "/api/v1/values/batch", strings.NewReader(body)))
+	assert.Equal(t, rec.Code, 200)
+
+	expected := map[string][]value.Bucket{
+		"step=1h&agg=sum": {
+			{Timestamp: start, Value: "104", Count: 3},
+			{Timestamp: start.Add(time.Hour), Value: "15", Count: 2},
+		},
+		"step=24h&agg=sum": {
+			{Timestamp: start, Value: "119", Count: 5},
+		},
+	}
+
+	for query, buckets := range expected {
+		rec = httptest.NewRecorder()
+		url := "/api/v1/devices/1/sensors/S1/values?from=2023-05-01T00:00:00Z&to=2023-05-02T00:00:00Z&" + query
+		router.ServeHTTP(rec, httptest.NewRequest("GET", url, nil))
+		assert.Equal(t, rec.Code, 200)
+
+		var result []value.Bucket
+		if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, result, buckets, query)
+	}
+}
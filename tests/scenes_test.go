@@ -0,0 +1,247 @@
+package tests
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/magiconair/properties/assert"
+	"github.com/soerenchrist/go_home/internal/command"
+	"github.com/soerenchrist/go_home/internal/db"
+	"github.com/soerenchrist/go_home/internal/scene"
+	"github.com/soerenchrist/go_home/internal/server"
+	"github.com/soerenchrist/go_home/pkg/output"
+)
+
+func TestListScenes_ShouldReturnScenes(t *testing.T) {
+	w := RecordGetCall(t, "/api/v1/scenes")
+
+	assert.Equal(t, w.Code, 200)
+
+	var scenes []scene.Scene
+	err := json.Unmarshal(w.Body.Bytes(), &scenes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, len(scenes), 1)
+	assert.Equal(t, scenes[0].ID, "movie_night")
+	assert.Equal(t, len(scenes[0].Steps), 1)
+	assert.Equal(t, scenes[0].Steps[0].CommandID, "C1")
+	assert.Equal(t, scenes[0].Steps[0].Params["payload"], "on")
+}
+
+func TestGetScene_ShouldReturn404_WhenSceneDoesNotExist(t *testing.T) {
+	w := RecordGetCall(t, "/api/v1/scenes/unknown")
+
+	assert.Equal(t, w.Code, 404)
+	assertErrorMessageEquals(t, w.Body.Bytes(), "Scene not found")
+}
+
+func TestCreateScene_ShouldReturn400_WhenSceneIsInvalid(t *testing.T) {
+	bodies := []string{
+		`{"name": "Test"}`,
+		`{"id": "test", "name": "T"}`,
+		`{"id": "test", "name": "Test"}`,
+		`{"id": "test", "name": "Test", "steps": [{"device_id": "1", "command_id": "C1"}, {"device_id": "2", "command_id": "C1"}]}`,
+	}
+	messages := []string{
+		"Id is required",
+		"Name must be at least 3 characters long",
+		"At least one step is required",
+		"Step 2: Command 2.C1 does not exist",
+	}
+
+	for i, body := range bodies {
+		w := RecordPostCall(t, "/api/v1/scenes", body)
+
+		assert.Equal(t, w.Code, 400)
+		assertErrorMessageEquals(t, w.Body.Bytes(), messages[i])
+	}
+}
+
+func TestCreateScene_ShouldReturn409_WhenSceneDoesAlreadyExist(t *testing.T) {
+	body := `{"id": "movie_night", "name": "Movie night", "steps": [{"device_id": "1", "command_id": "C1"}]}`
+	w := RecordPostCall(t, "/api/v1/scenes", body)
+
+	assert.Equal(t, w.Code, 409)
+	assertErrorMessageEquals(t, w.Body.Bytes(), "Scene with id movie_night does already exist")
+}
+
+func TestCreateScene_ShouldAddSceneToDatabase(t *testing.T) {
+	body := `{
+		"id": "good_night",
+		"name": "Good night",
+		"steps": [
+			{"device_id": "1", "command_id": "C1", "params": {"payload": "off"}},
+			{"device_id": "1", "command_id": "C1", "params": {"payload": "locked"}}
+		]
+	}`
+
+	validator := func(database db.Database) {
+		s, err := database.GetScene("good_night")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, s.Name, "Good night")
+		assert.Equal(t, len(s.Steps), 2)
+		assert.Equal(t, s.Steps[0].Position, 1)
+		assert.Equal(t, s.Steps[0].Params["payload"], "off")
+		assert.Equal(t, s.Steps[1].Position, 2)
+		assert.Equal(t, s.Steps[1].Params["payload"], "locked")
+	}
+
+	w := RecordPostCallWithDb(t, "/api/v1/scenes", body, validator)
+
+	assert.Equal(t, w.Code, 201)
+}
+
+func TestUpdateScene_ShouldReplaceSteps(t *testing.T) {
+	body := `{
+		"name": "Movie night",
+		"steps": [
+			{"device_id": "1", "command_id": "C1", "params": {"payload": "dim"}},
+			{"device_id": "1", "command_id": "C1", "params": {"payload": "off"}}
+		]
+	}`
+
+	validator := func(database db.Database) {
+		s, err := database.GetScene("movie_night")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, len(s.Steps), 2)
+		assert.Equal(t, s.Steps[0].Params["payload"], "dim")
+		assert.Equal(t, s.Steps[1].Params["payload"], "off")
+	}
+
+	w := RecordPutCallWithDb(t, "/api/v1/scenes/movie_night", body, validator)
+
+	assert.Equal(t, w.Code, 200)
+}
+
+func TestDeleteScene_ShouldDeleteScene(t *testing.T) {
+	validator := func(database db.Database) {
+		scenes, err := database.ListScenes()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, len(scenes), 0)
+	}
+
+	w := RecordDeleteCallWithDb(t, "/api/v1/scenes/movie_night", validator)
+
+	assert.Equal(t, w.Code, 204)
+}
+
+func TestDeleteScene_ShouldReturn404_WhenSceneDoesNotExist(t *testing.T) {
+	w := RecordDeleteCall(t, "/api/v1/scenes/unknown")
+
+	assert.Equal(t, w.Code, 404)
+	assertErrorMessageEquals(t, w.Body.Bytes(), "Scene not found")
+}
+
+func TestActivateScene_ShouldExecuteStepsInOrder(t *testing.T) {
+	received := make([]string, 0)
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = append(received, string(body))
+		w.Write(body)
+	}))
+	defer endpoint.Close()
+
+	database := CreateTestDatabase(t.Name())
+	err := database.AddCommand(&command.Command{ID: "C2", DeviceID: "2", Name: "Set", PayloadTemplate: "{{.p_payload}}", Endpoint: endpoint.URL, Method: "POST"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = database.AddScene(&scene.Scene{
+		ID:   "test",
+		Name: "Test",
+		Steps: []scene.SceneStep{
+			{Position: 1, DeviceID: "2", CommandID: "C2", Params: command.CommandParameters{"payload": "first"}},
+			{Position: 2, DeviceID: "2", CommandID: "C3", Params: command.CommandParameters{}},
+			{Position: 3, DeviceID: "2", CommandID: "C2", Params: command.CommandParameters{"payload": "second"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dispatcher := command.NewDispatcher(database, 2, 10)
+	dispatcher.Start()
+	defer dispatcher.Stop()
+	router := server.NewRouter(database, output.NewManager(), dispatcher, server.RouterOptions{})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/api/v1/scenes/test/activate", strings.NewReader(""))
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, w.Code, 200)
+
+	var result scene.ActivationResult
+	err = json.Unmarshal(w.Body.Bytes(), &result)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, result.Success, false)
+	assert.Equal(t, len(result.Steps), 3)
+	assert.Equal(t, result.Steps[0].Success, true)
+	assert.Equal(t, result.Steps[0].Response, "first")
+	assert.Equal(t, result.Steps[1].Success, false)
+	assert.Equal(t, result.Steps[1].Error, "Command not found")
+	assert.Equal(t, result.Steps[2].Success, true)
+	assert.Equal(t, received, []string{"first", "second"})
+}
+
+func TestActivateScene_ShouldFailStep_WhenCommandReturnsErrorStatus(t *testing.T) {
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(500)
+		w.Write([]byte("failed"))
+	}))
+	defer endpoint.Close()
+
+	database := CreateTestDatabase(t.Name())
+	err := database.AddCommand(&command.Command{ID: "C2", DeviceID: "2", Name: "Set", Endpoint: endpoint.URL, Method: "POST"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = database.AddScene(&scene.Scene{
+		ID:    "test",
+		Name:  "Test",
+		Steps: []scene.SceneStep{{Position: 1, DeviceID: "2", CommandID: "C2", Params: command.CommandParameters{}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dispatcher := command.NewDispatcher(database, 1, 10)
+	dispatcher.Start()
+	defer dispatcher.Stop()
+	router := server.NewRouter(database, output.NewManager(), dispatcher, server.RouterOptions{})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/api/v1/scenes/test/activate", strings.NewReader(""))
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, w.Code, 200)
+
+	var result scene.ActivationResult
+	err = json.Unmarshal(w.Body.Bytes(), &result)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, result.Success, false)
+	assert.Equal(t, result.Steps[0].Success, false)
+	assert.Equal(t, result.Steps[0].StatusCode, 500)
+	assert.Equal(t, result.Steps[0].Response, "failed")
+	assert.Equal(t, result.Steps[0].Error, "Command returned status code 500")
+}
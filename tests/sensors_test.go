@@ -1,12 +1,20 @@
 package tests
 
 import (
+	"context"
 	"encoding/json"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/magiconair/properties/assert"
+	"github.com/soerenchrist/go_home/internal/background"
+	"github.com/soerenchrist/go_home/internal/command"
 	"github.com/soerenchrist/go_home/internal/db"
 	"github.com/soerenchrist/go_home/internal/sensor"
+	"github.com/soerenchrist/go_home/internal/server"
+	"github.com/soerenchrist/go_home/pkg/output"
 )
 
 func TestGetSensors_ShouldReturn404_WhenDeviceDoesNotExist(t *testing.T) {
@@ -111,6 +119,310 @@ func TestCreateSensor_ShouldReturn400_WhenSensorIsInvalid(t *testing.T) {
 			"polling_strategy": "something",
 			"polling_endpoint": "some_endpoint"
 		}`,
+		`{
+			"id": "my_sensor",
+			"name": "Test Sensor",
+			"data_type": "float",
+			"type": "polling",
+			"polling_interval": 10,
+			"polling_strategy": "http",
+			"polling_endpoint": "localhost"
+		}`,
+		`{
+			"id": "my_sensor",
+			"name": "Test Sensor",
+			"data_type": "float",
+			"type": "polling",
+			"polling_interval": 10,
+			"polling_strategy": "http",
+			"polling_endpoint": "http://localhost/status",
+			"polling_options": {"method": "PATCH"}
+		}`,
+		`{
+			"id": "my_sensor",
+			"name": "Test Sensor",
+			"data_type": "float",
+			"type": "polling",
+			"polling_interval": 10,
+			"polling_strategy": "http",
+			"polling_endpoint": "http://localhost/status",
+			"polling_options": {"extractor": "xpath"}
+		}`,
+		`{
+			"id": "my_sensor",
+			"name": "Test Sensor",
+			"data_type": "float",
+			"type": "polling",
+			"polling_interval": 10,
+			"polling_strategy": "http",
+			"polling_endpoint": "http://localhost/status",
+			"polling_options": {"extractor": "json", "expression": "$.a[x]"}
+		}`,
+		`{
+			"id": "my_sensor",
+			"name": "Test Sensor",
+			"data_type": "float",
+			"type": "polling",
+			"polling_interval": 10,
+			"polling_strategy": "http",
+			"polling_endpoint": "http://localhost/status",
+			"polling_options": {"extractor": "regex", "expression": "temp=(\\d+"}
+		}`,
+		`{
+			"id": "my_sensor",
+			"name": "Test Sensor",
+			"data_type": "bool",
+			"type": "polling",
+			"polling_interval": 10,
+			"polling_strategy": "tcp",
+			"polling_endpoint": "localhost"
+		}`,
+		`{
+			"id": "my_sensor",
+			"name": "Test Sensor",
+			"data_type": "bool",
+			"type": "polling",
+			"polling_interval": 10,
+			"polling_strategy": "tcp",
+			"polling_endpoint": "localhost:99999"
+		}`,
+		`{
+			"id": "my_sensor",
+			"name": "Test Sensor",
+			"data_type": "float",
+			"type": "polling",
+			"polling_interval": 10,
+			"polling_strategy": "tcp",
+			"polling_endpoint": "localhost:22"
+		}`,
+		`{
+			"id": "my_sensor",
+			"name": "Test Sensor",
+			"data_type": "bool",
+			"type": "polling",
+			"polling_interval": 10,
+			"polling_strategy": "tls_cert",
+			"polling_endpoint": "example.com:443"
+		}`,
+		`{
+			"id": "my_sensor",
+			"name": "Test Sensor",
+			"data_type": "int",
+			"type": "polling",
+			"polling_interval": 10,
+			"polling_strategy": "tls_cert",
+			"polling_endpoint": "https://example.com"
+		}`,
+		`{
+			"id": "my_sensor",
+			"name": "Test Sensor",
+			"data_type": "float",
+			"type": "polling",
+			"polling_interval": 10,
+			"polling_strategy": "ping",
+			"polling_endpoint": "localhost",
+			"polling_options": {"metric": "jitter"}
+		}`,
+		`{
+			"id": "my_sensor",
+			"name": "Test Sensor",
+			"data_type": "bool",
+			"type": "polling",
+			"polling_interval": 10,
+			"polling_strategy": "ping",
+			"polling_endpoint": "localhost",
+			"polling_options": {"metric": "rtt"}
+		}`,
+		`{
+			"id": "my_sensor",
+			"name": "Test Sensor",
+			"data_type": "float",
+			"type": "polling",
+			"polling_interval": 10,
+			"polling_strategy": "ping",
+			"polling_endpoint": "localhost",
+			"polling_options": {"metric": "reachable"}
+		}`,
+		`{
+			"id": "my_sensor",
+			"name": "Test Sensor",
+			"data_type": "bool",
+			"type": "polling",
+			"polling_interval": 10,
+			"polling_strategy": "ping",
+			"polling_endpoint": "localhost",
+			"polling_options": {"count": 1000}
+		}`,
+		`{
+			"id": "my_sensor",
+			"name": "Test Sensor",
+			"data_type": "int",
+			"type": "polling",
+			"polling_interval": 10,
+			"polling_strategy": "ping",
+			"polling_endpoint": "localhost"
+		}`,
+		`{
+			"id": "my_sensor",
+			"name": "Test Sensor",
+			"data_type": "float",
+			"type": "polling",
+			"polling_interval": 10,
+			"polling_strategy": "exec",
+			"polling_endpoint": "rm",
+			"polling_options": {"args": ["-rf", "/"]}
+		}`,
+		`{
+			"id": "my_sensor",
+			"name": "Test Sensor",
+			"data_type": "float",
+			"type": "polling",
+			"polling_interval": 10,
+			"polling_strategy": "exec",
+			"polling_endpoint": "echo",
+			"polling_options": {"extractor": "xpath"}
+		}`,
+		`{
+			"id": "my_sensor",
+			"name": "Test Sensor",
+			"data_type": "bool",
+			"type": "polling",
+			"polling_interval": 10,
+			"polling_strategy": "file",
+			"polling_endpoint": "sys/bus/w1/devices/28-0000/w1_slave",
+			"polling_options": {}
+		}`,
+		`{
+			"id": "my_sensor",
+			"name": "Test Sensor",
+			"data_type": "string",
+			"type": "polling",
+			"polling_interval": 10,
+			"polling_strategy": "file",
+			"polling_endpoint": "/sys/../etc/passwd"
+		}`,
+		`{
+			"id": "my_sensor",
+			"name": "Test Sensor",
+			"data_type": "bool",
+			"type": "polling",
+			"polling_interval": 10,
+			"polling_strategy": "file",
+			"polling_endpoint": "/sys/class/gpio/gpio17/value",
+			"polling_options": {"scale": 0.5}
+		}`,
+		`{
+			"id": "my_sensor",
+			"name": "Test Sensor",
+			"data_type": "bool",
+			"type": "computed",
+			"expression": "${1.S1} * 2"
+		}`,
+		`{
+			"id": "my_sensor",
+			"name": "Test Sensor",
+			"data_type": "float",
+			"type": "computed"
+		}`,
+		`{
+			"id": "my_sensor",
+			"name": "Test Sensor",
+			"data_type": "float",
+			"type": "computed",
+			"expression": "${1.S1} *"
+		}`,
+		`{
+			"id": "my_sensor",
+			"name": "Test Sensor",
+			"data_type": "float",
+			"type": "computed",
+			"expression": "${1.S1} + ${2.S9}"
+		}`,
+		`{
+			"id": "my_sensor",
+			"name": "Test Sensor",
+			"data_type": "float",
+			"type": "computed",
+			"expression": "${1.my_sensor} + 1"
+		}`,
+		`{
+			"id": "my_sensor",
+			"name": "Test Sensor",
+			"data_type": "bool",
+			"type": "external",
+			"pipeline": {"scale": 2}
+		}`,
+		`{
+			"id": "my_sensor",
+			"name": "Test Sensor",
+			"data_type": "float",
+			"type": "external",
+			"unit": "Celsius",
+			"pipeline": {"convert_from": "m"}
+		}`,
+		`{
+			"id": "my_sensor",
+			"name": "Test Sensor",
+			"data_type": "float",
+			"type": "external",
+			"pipeline": {"min": 10, "max": 0}
+		}`,
+		`{
+			"id": "my_sensor",
+			"name": "Test Sensor",
+			"data_type": "float",
+			"type": "external",
+			"pipeline": {"precision": 12}
+		}`,
+		`{
+			"id": "my_sensor",
+			"name": "Test Sensor",
+			"data_type": "float",
+			"type": "external",
+			"pipeline": {"dead_band": -1}
+		}`,
+		`{
+			"id": "my_sensor",
+			"name": "Test Sensor",
+			"data_type": "enum",
+			"type": "external"
+		}`,
+		`{
+			"id": "my_sensor",
+			"name": "Test Sensor",
+			"data_type": "enum",
+			"type": "external",
+			"allowed_values": ["off", "heating", "off"]
+		}`,
+		`{
+			"id": "my_sensor",
+			"name": "Test Sensor",
+			"data_type": "enum",
+			"type": "external",
+			"unit": "°C",
+			"allowed_values": ["off", "heating"]
+		}`,
+		`{
+			"id": "my_sensor",
+			"name": "Test Sensor",
+			"data_type": "float",
+			"type": "external",
+			"allowed_values": ["off", "heating"]
+		}`,
+		`{
+			"id": "my_sensor",
+			"name": "Test Sensor",
+			"data_type": "float",
+			"type": "external",
+			"schema": {"temp": "float"}
+		}`,
+		`{
+			"id": "my_sensor",
+			"name": "Test Sensor",
+			"data_type": "json",
+			"type": "external",
+			"schema": {"temp": "double"}
+		}`,
 	}
 	expectedMessages := []string{
 		"Name must be at least 3 characters long",
@@ -119,6 +431,42 @@ func TestCreateSensor_ShouldReturn400_WhenSensorIsInvalid(t *testing.T) {
 		"Polling interval must be greater than 0",
 		"Polling endpoint is required",
 		"Invalid polling strategy",
+		"Polling endpoint must be a http or https URL",
+		"Method must be one of GET, POST or PUT",
+		"Extractor must be one of body, json or regex",
+		"Invalid JSON path: invalid path $.a[x]: x is not a valid index",
+		"Invalid regular expression: error parsing regexp: missing closing ): `temp=(\\d+`",
+		"Polling endpoint must be in the format host:port",
+		"Port must be a number between 1 and 65535",
+		"TCP polling requires data type bool or int",
+		"TLS certificate polling requires data type int",
+		"Polling endpoint must be in the format host:port",
+		"Metric must be one of reachable, rtt or packet_loss",
+		"Metric rtt requires data type float",
+		"Metric reachable requires data type bool",
+		"Count must be between 1 and 100, or 0 for the default",
+		"Ping polling requires data type bool or float",
+		"Command rm -rf / is not allowed",
+		"Extractor must be one of body, json or regex",
+		"Polling endpoint must be an absolute path",
+		"Path /sys/../etc/passwd is not allowed",
+		"Scale is only allowed for int and float data types",
+		"Computed sensors require data type int or float",
+		"Expression is required",
+		"Invalid expression: invalid expression ${1.S1} *: unexpected end",
+		"Sensor 2.S9 in expression does not exist",
+		"Expression must not reference the sensor itself",
+		"Pipeline is only allowed for int and float data types",
+		"Invalid unit conversion: cannot convert m to Celsius",
+		"Min must not be greater than max",
+		"Precision must be between 0 and 10",
+		"Dead band and max change must not be negative",
+		"Allowed values are required for enum sensors",
+		"Allowed values must be unique and not empty",
+		"Unit is not allowed for this data type",
+		"Allowed values are only allowed for enum sensors",
+		"Schema is only allowed for json sensors",
+		"Invalid data type double for field temp",
 	}
 
 	for i, body := range bodies {
@@ -180,6 +528,46 @@ func TestCreateSensor_ShouldAddSensorToDb_WhenBodyIsValid(t *testing.T) {
 	assert.Equal(t, 3600, s.RetainmentPeriodSeconds)
 }
 
+func TestCreateSensor_ShouldNormalizeUnit(t *testing.T) {
+	body := `{
+		"id": "my_sensor",
+		"name": "Test Sensor",
+		"data_type": "float",
+		"type": "external",
+		"unit": "fahrenheit"
+	}`
+
+	w := RecordPostCall(t, "/api/v1/devices/1/sensors", body)
+
+	assert.Equal(t, w.Code, 201)
+
+	var s sensor.Sensor
+	if err := json.Unmarshal(w.Body.Bytes(), &s); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, s.Unit, "°F")
+}
+
+func TestCreateSensor_ShouldKeepUnknownUnit(t *testing.T) {
+	body := `{
+		"id": "my_sensor",
+		"name": "Test Sensor",
+		"data_type": "float",
+		"type": "external",
+		"unit": "µg/m³"
+	}`
+
+	w := RecordPostCall(t, "/api/v1/devices/1/sensors", body)
+
+	assert.Equal(t, w.Code, 201)
+
+	var s sensor.Sensor
+	if err := json.Unmarshal(w.Body.Bytes(), &s); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, s.Unit, "µg/m³")
+}
+
 func TestCreateSensor_ShouldSetRetainmentPeriodToMinusOne_WhenItIsNotContainedInBody(t *testing.T) {
 	body := `{
 		"id": "my_sensor",
@@ -261,3 +649,339 @@ func TestGetSensor_ShouldReturnSensor_WhenSensorDoesExist(t *testing.T) {
 	assert.Equal(t, sensor.SensorTypeExternal, s.Type)
 	assert.Equal(t, 0, s.PollingInterval)
 }
+
+func TestUpdateSensor_ShouldReturn404_WhenSensorDoesNotExist(t *testing.T) {
+	w := RecordPutCallWithDb(t, "/api/v1/devices/1/sensors/S4", `{"name": "Test Sensor"}`, nil)
+
+	assert.Equal(t, w.Code, 404)
+	assertErrorMessageEquals(t, w.Body.Bytes(), "Sensor not found")
+}
+
+func TestUpdateSensor_ShouldReturn400_WhenSensorIsInvalid(t *testing.T) {
+	body := `{
+		"name": "Availability",
+		"data_type": "bool",
+		"type": "polling",
+		"polling_interval": 0,
+		"polling_strategy": "ping",
+		"polling_endpoint": "localhost"
+	}`
+	w := RecordPutCallWithDb(t, "/api/v1/devices/1/sensors/S2", body, nil)
+
+	assert.Equal(t, w.Code, 400)
+	assertErrorMessageEquals(t, w.Body.Bytes(), "Polling interval must be greater than 0")
+}
+
+func TestUpdateSensor_ShouldReplaceSensor(t *testing.T) {
+	body := `{
+		"name": "Availability",
+		"data_type": "bool",
+		"type": "polling",
+		"polling_interval": 60,
+		"polling_strategy": "ping",
+		"polling_endpoint": "192.168.0.10"
+	}`
+
+	validator := func(database db.Database) {
+		s, err := database.GetSensor("1", "S2")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, 60, s.PollingInterval)
+		assert.Equal(t, "192.168.0.10", s.PollingEndpoint)
+		assert.Equal(t, true, s.IsActive)
+	}
+
+	w := RecordPutCallWithDb(t, "/api/v1/devices/1/sensors/S2", body, validator)
+
+	assert.Equal(t, w.Code, 200)
+}
+
+func TestPatchSensor_ShouldOnlyUpdateGivenFields(t *testing.T) {
+	validator := func(database db.Database) {
+		s, err := database.GetSensor("1", "S1")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, "Temperature", s.Name)
+		assert.Equal(t, sensor.DataTypeFloat, s.DataType)
+		assert.Equal(t, 3600, s.RetainmentPeriodSeconds)
+		assert.Equal(t, false, s.IsActive)
+	}
+
+	w := RecordPatchCallWithDb(t, "/api/v1/devices/1/sensors/S1", `{"is_active": false}`, validator)
+
+	assert.Equal(t, w.Code, 200)
+}
+
+func TestCreateSensor_ShouldStorePollingOptions_WhenStrategyIsHttp(t *testing.T) {
+	body := `{
+		"id": "my_sensor",
+		"name": "Test Sensor",
+		"data_type": "float",
+		"type": "polling",
+		"polling_interval": 10,
+		"polling_strategy": "http",
+		"polling_endpoint": "http://192.168.0.20/status",
+		"polling_options": {
+			"method": "POST",
+			"headers": {"Authorization": "Bearer token"},
+			"timeout_seconds": 3,
+			"extractor": "json",
+			"expression": "$.temperature"
+		}
+	}`
+
+	validator := func(database db.Database) {
+		s, err := database.GetSensor("1", "my_sensor")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, sensor.PollingStrategyHttp, s.PollingStrategy)
+		assert.Equal(t, "POST", s.PollingOptions.Method)
+		assert.Equal(t, "Bearer token", s.PollingOptions.Headers["Authorization"])
+		assert.Equal(t, 3, s.PollingOptions.TimeoutSeconds)
+		assert.Equal(t, sensor.ExtractorJson, s.PollingOptions.Extractor)
+		assert.Equal(t, "$.temperature", s.PollingOptions.Expression)
+	}
+
+	w := RecordPostCallWithDb(t, "/api/v1/devices/1/sensors", body, validator)
+
+	assert.Equal(t, w.Code, 201)
+}
+
+func TestSensors_ShouldRedactSensitiveHeaders(t *testing.T) {
+	database := CreateTestDatabase(t.Name())
+	router := server.NewRouter(database, output.NewManager(), command.NewDispatcher(database, 1, 1), server.RouterOptions{})
+
+	body := `{
+		"id": "my_sensor",
+		"name": "Test Sensor",
+		"data_type": "float",
+		"type": "polling",
+		"polling_interval": 10,
+		"polling_strategy": "http",
+		"polling_endpoint": "http://192.168.0.20/status",
+		"polling_options": {
+			"headers": {"Authorization": "Bearer token", "Accept": "application/json"}
+		}
+	}`
+
+	requests := []struct {
+		method string
+		url    string
+		body   string
+	}{
+		{"POST", "/api/v1/devices/1/sensors", body},
+		{"GET", "/api/v1/devices/1/sensors/my_sensor", ""},
+		{"PATCH", "/api/v1/devices/1/sensors/my_sensor", `{"polling_options": {"headers": {"Authorization": "********", "Accept": "text/plain"}}}`},
+	}
+
+	for _, r := range requests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(r.method, r.url, strings.NewReader(r.body)))
+
+		var s sensor.Sensor
+		err := json.Unmarshal(w.Body.Bytes(), &s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, s.PollingOptions.Headers["Authorization"], sensor.RedactedHeader)
+	}
+
+	s, err := database.GetSensor("1", "my_sensor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, s.PollingOptions.Headers["Authorization"], "Bearer token")
+	assert.Equal(t, s.PollingOptions.Headers["Accept"], "text/plain")
+}
+
+func TestCreateSensor_ShouldStoreArgs_WhenStrategyIsExec(t *testing.T) {
+	body := `{
+		"id": "my_sensor",
+		"name": "Test Sensor",
+		"data_type": "float",
+		"type": "polling",
+		"polling_interval": 10,
+		"polling_strategy": "exec",
+		"polling_endpoint": "echo",
+		"polling_options": {
+			"args": ["temp=42.0"],
+			"extractor": "regex",
+			"expression": "temp=([0-9.]+)"
+		}
+	}`
+
+	validator := func(database db.Database) {
+		s, err := database.GetSensor("1", "my_sensor")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, sensor.PollingStrategyExec, s.PollingStrategy)
+		assert.Equal(t, "echo", s.PollingEndpoint)
+		assert.Equal(t, []string{"temp=42.0"}, s.PollingOptions.Args)
+	}
+
+	w := RecordPostCallWithDb(t, "/api/v1/devices/1/sensors", body, validator)
+
+	assert.Equal(t, w.Code, 201)
+}
+
+func TestSensorChanges_ShouldUpdatePollingSchedules(t *testing.T) {
+	database := CreateTestDatabase(t.Name())
+	poller := background.NewPoller(database, output.NewManager(), nil, 1, localAccess)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go poller.Run(ctx)
+
+	router := server.NewRouter(database, output.NewManager(), command.NewDispatcher(database, 1, 1), server.RouterOptions{Poller: poller, LocalAccess: localAccess})
+
+	body := `{
+		"id": "my_sensor",
+		"name": "Test Sensor",
+		"data_type": "bool",
+		"type": "polling",
+		"polling_interval": 10,
+		"polling_strategy": "file",
+		"polling_endpoint": "/sys/nonexistent/value"
+	}`
+
+	requests := []struct {
+		method   string
+		url      string
+		body     string
+		expected bool
+	}{
+		{"POST", "/api/v1/devices/1/sensors", body, true},
+		{"PATCH", "/api/v1/devices/1/sensors/my_sensor", `{"is_active": false}`, false},
+		{"PATCH", "/api/v1/devices/1/sensors/my_sensor", `{"is_active": true}`, true},
+		{"DELETE", "/api/v1/devices/1/sensors/my_sensor", "", false},
+	}
+
+	for _, r := range requests {
+		for !isScheduled(poller, "1.S2") {
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(r.method, r.url, strings.NewReader(r.body)))
+
+		if isScheduled(poller, "1.my_sensor") != r.expected {
+			t.Errorf("Expected sensor to be scheduled: %v after %s %s", r.expected, r.method, r.url)
+		}
+	}
+}
+
+func isScheduled(poller *background.Poller, key string) bool {
+	for _, scheduled := range poller.Scheduled() {
+		if scheduled == key {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSensorHealth_ShouldBeReturned_WhenItWasRecorded(t *testing.T) {
+	database := CreateTestDatabase(t.Name())
+	lastError := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+	err := database.SaveSensorHealth(&sensor.SensorHealth{
+		DeviceID:            "1",
+		SensorID:            "S2",
+		Status:              sensor.HealthStatusUnavailable,
+		LastError:           "timeout",
+		LastErrorAt:         &lastError,
+		ConsecutiveFailures: 3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := server.NewRouter(database, output.NewManager(), command.NewDispatcher(database, 1, 1), server.RouterOptions{})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/health/sensors", nil))
+	assert.Equal(t, w.Code, 200)
+
+	var healths []sensor.SensorHealth
+	if err := json.Unmarshal(w.Body.Bytes(), &healths); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(healths), 1)
+	assert.Equal(t, healths[0].SensorID, "S2")
+	assert.Equal(t, healths[0].Status, sensor.HealthStatusUnavailable)
+	assert.Equal(t, healths[0].ConsecutiveFailures, 3)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/devices/1/sensors", nil))
+
+	var sensors []sensor.Sensor
+	if err := json.Unmarshal(w.Body.Bytes(), &sensors); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, sensors[0].Health == nil, true)
+	assert.Equal(t, sensors[1].Health.Status, sensor.HealthStatusUnavailable)
+	assert.Equal(t, sensors[1].Health.LastError, "timeout")
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/devices/1/sensors/S2", nil))
+
+	var s sensor.Sensor
+	if err := json.Unmarshal(w.Body.Bytes(), &s); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, s.Health.ConsecutiveFailures, 3)
+	assert.Equal(t, s.Health.LastErrorAt.Equal(lastError), true)
+}
+
+func TestUpdateSensor_ShouldReturn400_WhenComputedExpressionCreatesCycle(t *testing.T) {
+	database := CreateTestDatabase(t.Name())
+	router := server.NewRouter(database, output.NewManager(), command.NewDispatcher(database, 1, 1), server.RouterOptions{})
+
+	requests := []struct {
+		method string
+		url    string
+		body   string
+		code   int
+	}{
+		{"POST", "/api/v1/devices/1/sensors", `{"id": "C1", "name": "Computed 1", "data_type": "float", "type": "computed", "expression": "${1.S1} + 1"}`, 201},
+		{"POST", "/api/v1/devices/1/sensors", `{"id": "C2", "name": "Computed 2", "data_type": "float", "type": "computed", "expression": "${1.C1} * 2"}`, 201},
+		{"PATCH", "/api/v1/devices/1/sensors/C1", `{"expression": "${1.C2} + 1"}`, 400},
+	}
+
+	var w *httptest.ResponseRecorder
+	for _, r := range requests {
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(r.method, r.url, strings.NewReader(r.body)))
+		assert.Equal(t, w.Code, r.code)
+	}
+
+	assertErrorMessageEquals(t, w.Body.Bytes(), "Expression creates a dependency cycle")
+}
+
+func TestDeleteSensor_ShouldReturn409_WhenSensorIsUsedByComputedSensor(t *testing.T) {
+	database := CreateTestDatabase(t.Name())
+	router := server.NewRouter(database, output.NewManager(), command.NewDispatcher(database, 1, 1), server.RouterOptions{})
+
+	requests := []struct {
+		method string
+		url    string
+		body   string
+		code   int
+	}{
+		{"POST", "/api/v1/devices/2/sensors", `{"id": "C1", "name": "Computed 1", "data_type": "float", "type": "computed", "expression": "${1.S1} + 1"}`, 201},
+		{"DELETE", "/api/v1/devices/1/sensors/S1", "", 409},
+	}
+
+	var w *httptest.ResponseRecorder
+	for _, r := range requests {
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(r.method, r.url, strings.NewReader(r.body)))
+		assert.Equal(t, w.Code, r.code)
+	}
+
+	assertErrorMessageEquals(t, w.Body.Bytes(), "Sensor is used by computed sensor 2.C1")
+}
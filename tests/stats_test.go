@@ -0,0 +1,168 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/magiconair/properties/assert"
+	"github.com/soerenchrist/go_home/internal/command"
+	"github.com/soerenchrist/go_home/internal/db"
+	"github.com/soerenchrist/go_home/internal/server"
+	"github.com/soerenchrist/go_home/internal/value"
+	"github.com/soerenchrist/go_home/pkg/output"
+)
+
+func recordStats(t *testing.T, database db.Database, url string) *httptest.ResponseRecorder {
+	router := server.NewRouter(database, output.NewManager(), command.NewDispatcher(database, 1, 1), server.RouterOptions{})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+	return w
+}
+
+func TestGetSensorValueStats_ShouldSummarizeNumericValues(t *testing.T) {
+	database := CreateTestDatabase(t.Name())
+	start := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	values := make([]*value.SensorValue, 0)
+	for i := 10; i >= 1; i-- {
+		values = append(values, &value.SensorValue{SensorID: "S1", DeviceID: "1", Value: fmt.Sprint(i), Timestamp: start.Add(time.Duration(10-i) * time.Minute)})
+	}
+	if err := database.AddSensorValues(values); err != nil {
+		t.Fatal(err)
+	}
+
+	w := recordStats(t, database, "/api/v1/devices/1/sensors/S1/stats?from=2023-05-01T12:00:00Z&to=2023-05-01T13:00:00Z&percentiles=50,90")
+	assert.Equal(t, w.Code, 200)
+
+	var stats value.Stats
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, stats.Count, 10)
+	assert.Equal(t, *stats.Min, 1.0)
+	assert.Equal(t, *stats.Max, 10.0)
+	assert.Equal(t, *stats.Mean, 5.5)
+	assert.Equal(t, math.Abs(*stats.Stddev-math.Sqrt(8.25)) < 1e-9, true)
+	assert.Equal(t, stats.Percentiles, map[string]float64{"p50": 5, "p90": 9})
+	assert.Equal(t, stats.First.Value, "10")
+	assert.Equal(t, stats.Last.Value, "1")
+	assert.Equal(t, stats.TrueFraction == nil, true)
+}
+
+func TestGetSensorValueStats_ShouldWeightBoolValuesByTime(t *testing.T) {
+	database := CreateTestDatabase(t.Name())
+	start := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	values := []*value.SensorValue{
+		{SensorID: "S2", DeviceID: "1", Value: "true", Timestamp: start},
+		{SensorID: "S2", DeviceID: "1", Value: "false", Timestamp: start.Add(15 * time.Minute)},
+		{SensorID: "S2", DeviceID: "1", Value: "true", Timestamp: start.Add(40 * time.Minute)},
+		{SensorID: "S2", DeviceID: "1", Value: "true", Timestamp: start.Add(45 * time.Minute)},
+	}
+	if err := database.AddSensorValues(values); err != nil {
+		t.Fatal(err)
+	}
+
+	w := recordStats(t, database, "/api/v1/devices/1/sensors/S2/stats?from=2023-05-01T12:00:00Z&to=2023-05-01T13:00:00Z")
+	assert.Equal(t, w.Code, 200)
+
+	var stats value.Stats
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, stats.Count, 4)
+	assert.Equal(t, *stats.Mean, 0.75)
+	assert.Equal(t, *stats.Transitions, 2)
+	// true for 15 + 20 of 60 minutes
+	assert.Equal(t, math.Abs(*stats.TrueFraction-35.0/60) < 1e-6, true)
+	assert.Equal(t, len(stats.Percentiles), 4)
+}
+
+func TestGetSensorValueStats_ShouldStartWithPreviousValue_AndEndAtNow(t *testing.T) {
+	database := CreateTestDatabase(t.Name())
+	start := time.Date(2023, 5, 1, 9, 0, 0, 0, time.UTC)
+	now := time.Now().UTC().Truncate(time.Second)
+	values := []*value.SensorValue{
+		{SensorID: "S2", DeviceID: "1", Value: "true", Timestamp: start},
+		{SensorID: "S2", DeviceID: "1", Value: "false", Timestamp: start.Add(90 * time.Minute)},
+		{SensorID: "S2", DeviceID: "1", Value: "true", Timestamp: now.Add(-10 * time.Minute)},
+	}
+	if err := database.AddSensorValues(values); err != nil {
+		t.Fatal(err)
+	}
+
+	// on at 09:00 and off at 10:30
+	w := recordStats(t, database, "/api/v1/devices/1/sensors/S2/stats?from=2023-05-01T10:00:00Z&to=2023-05-01T11:00:00Z")
+	assert.Equal(t, w.Code, 200)
+
+	var stats value.Stats
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, *stats.Transitions, 1)
+	assert.Equal(t, math.Abs(*stats.TrueFraction-0.5) < 1e-6, true)
+
+	// The future part of the range is not weighted
+	from := now.Add(-20 * time.Minute).Format(time.RFC3339)
+	to := now.Add(40 * time.Minute).Format(time.RFC3339)
+	w = recordStats(t, database, "/api/v1/devices/1/sensors/S2/stats?from="+url.QueryEscape(from)+"&to="+url.QueryEscape(to))
+	assert.Equal(t, w.Code, 200)
+
+	stats = value.Stats{}
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, *stats.Transitions, 1)
+	assert.Equal(t, math.Abs(*stats.TrueFraction-0.5) < 0.01, true)
+}
+
+func TestGetSensorValueStats_ShouldUsePreviousValue_WhenRangeHasNoValues(t *testing.T) {
+	database := CreateTestDatabase(t.Name())
+	start := time.Date(2023, 5, 1, 9, 0, 0, 0, time.UTC)
+	values := []*value.SensorValue{
+		{SensorID: "S2", DeviceID: "1", Value: "true", Timestamp: start},
+		{SensorID: "S2", DeviceID: "1", Value: "false", Timestamp: start.Add(90 * time.Minute)},
+	}
+	if err := database.AddSensorValues(values); err != nil {
+		t.Fatal(err)
+	}
+
+	ranges := []struct {
+		query    string
+		fraction float64
+	}{
+		{"from=2023-05-01T09:15:00Z&to=2023-05-01T09:45:00Z", 1},
+		{"from=2023-05-01T11:00:00Z&to=2023-05-01T12:00:00Z", 0},
+	}
+
+	for _, r := range ranges {
+		w := recordStats(t, database, "/api/v1/devices/1/sensors/S2/stats?"+r.query)
+		assert.Equal(t, w.Code, 200)
+
+		var stats value.Stats
+		if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, stats.Count, 0)
+		assert.Equal(t, *stats.Transitions, 0)
+		assert.Equal(t, *stats.TrueFraction, r.fraction)
+	}
+
+	// Without a previous value, the state of the sensor is unknown
+	w := recordStats(t, database, "/api/v1/devices/1/sensors/S2/stats?from=2023-05-01T08:00:00Z&to=2023-05-01T08:30:00Z")
+	assert.Equal(t, w.Code, 200)
+	assert.Equal(t, w.Body.String(), `{"count":0}`)
+}
+
+func TestGetSensorValueStats_ShouldReturnCountOnly_WhenNoValuesExist(t *testing.T) {
+	w := RecordGetCall(t, "/api/v1/devices/1/sensors/S1/stats")
+	assert.Equal(t, w.Code, 200)
+	assert.Equal(t, w.Body.String(), `{"count":0}`)
+
+	w = RecordGetCall(t, "/api/v1/devices/1/sensors/S1/stats?percentiles=0")
+	assert.Equal(t, w.Code, 400)
+	assertErrorMessageEquals(t, w.Body.Bytes(), "Invalid percentile 0 - Should be between 0 and 100")
+}
@@ -10,7 +10,9 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/soerenchrist/go_home/internal/command"
 	"github.com/soerenchrist/go_home/internal/db"
+	"github.com/soerenchrist/go_home/internal/sensor"
 	"github.com/soerenchrist/go_home/internal/server"
 	"github.com/soerenchrist/go_home/pkg/output"
 	"gorm.io/driver/sqlite"
@@ -37,6 +39,12 @@ func CreateTestDatabase(filename string) db.Database {
 	return database
 }
 
+// localAccess restricts exec and file polling sensors of the test router.
+var localAccess = sensor.LocalAccess{
+	Commands: []string{"echo", "echo temp=42.0"},
+	Paths:    sensor.DefaultAllowedPaths,
+}
+
 type DbValidator func(database db.Database)
 
 func recordCall(t *testing.T, url string, method string, body io.Reader, dbValidator DbValidator) *httptest.ResponseRecorder {
@@ -48,7 +56,10 @@ func recordCall(t *testing.T, url string, method string, body io.Reader, dbValid
 		defer dbValidator(database)
 	}
 	outputBindings := output.NewManager()
-	router := server.NewRouter(database, outputBindings)
+	dispatcher := command.NewDispatcher(database, 2, 10)
+	dispatcher.Start()
+	defer dispatcher.Stop()
+	router := server.NewRouter(database, outputBindings, dispatcher, server.RouterOptions{LocalAccess: localAccess})
 
 	req := httptest.NewRequest(method, url, body)
 
@@ -79,6 +90,18 @@ func RecordPostCallWithDb(t *testing.T, url string, body string, dbValidator DbV
 	return recordCall(t, url, "POST", reader, dbValidator)
 }
 
+func RecordPutCallWithDb(t *testing.T, url string, body string, dbValidator DbValidator) *httptest.ResponseRecorder {
+	reader := strings.NewReader(body)
+
+	return recordCall(t, url, "PUT", reader, dbValidator)
+}
+
+func RecordPatchCallWithDb(t *testing.T, url string, body string, dbValidator DbValidator) *httptest.ResponseRecorder {
+	reader := strings.NewReader(body)
+
+	return recordCall(t, url, "PATCH", reader, dbValidator)
+}
+
 func IsValidUuid(u string) bool {
 	_, err := uuid.Parse(u)
 	return err == nil
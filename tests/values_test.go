@@ -4,11 +4,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/magiconair/properties/assert"
+	"github.com/soerenchrist/go_home/internal/command"
 	"github.com/soerenchrist/go_home/internal/db"
+	"github.com/soerenchrist/go_home/internal/sensor"
 	"github.com/soerenchrist/go_home/internal/server"
 	"github.com/soerenchrist/go_home/internal/value"
 	"github.com/soerenchrist/go_home/pkg/output"
@@ -89,6 +92,131 @@ func TestAddSensorValue_ShouldReturn400_WhenSensorIsPolling(t *testing.T) {
 	assertErrorMessageEquals(t, w.Body.Bytes(), "Sending values to a polling sensor is not allowed")
 }
 
+func TestAddSensorValue_ShouldReturn400_WhenSensorIsComputed(t *testing.T) {
+	database := CreateTestDatabase(t.Name())
+	err := database.AddSensor(&sensor.Sensor{ID: "C1", DeviceID: "1", Name: "Computed", DataType: sensor.DataTypeFloat, Type: sensor.SensorTypeComputed, IsActive: true, Expression: "${1.S1} * 2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := server.NewRouter(database, output.NewManager(), command.NewDispatcher(database, 1, 1), server.RouterOptions{})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/devices/1/sensors/C1/values", strings.NewReader(`{"value": "1.23"}`)))
+
+	assert.Equal(t, w.Code, 400)
+	assertErrorMessageEquals(t, w.Body.Bytes(), "Values of computed sensors cannot be set")
+}
+
+func TestAddSensorValue_ShouldApplyPipeline(t *testing.T) {
+	database := CreateTestDatabase(t.Name())
+	max := 50.0
+	precision := 1
+	err := database.AddSensor(&sensor.Sensor{
+		ID:       "P1",
+		DeviceID: "1",
+		Name:     "Calibrated",
+		DataType: sensor.DataTypeFloat,
+		Unit:     "Celsius",
+		Type:     sensor.SensorTypeExternal,
+		IsActive: true,
+		Pipeline: sensor.PipelineOptions{ConvertFrom: "Fahrenheit", Max: &max, Precision: &precision, DeadBand: 0.5, MaxChange: 20},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := server.NewRouter(database, output.NewManager(), command.NewDispatcher(database, 1, 1), server.RouterOptions{})
+
+	requests := []struct {
+		value    string
+		code     int
+		expected string
+	}{
+		{"212", 201, "50"},
+		{"122", 202, "50"},
+		{"50", 202, "50"},
+		{"104", 201, "40"},
+	}
+
+	for _, r := range requests {
+		w := httptest.NewRecorder()
+		body := fmt.Sprintf(`{"value": "%s"}`, r.value)
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/devices/1/sensors/P1/values", strings.NewReader(body)))
+		assert.Equal(t, w.Code, r.code)
+
+		current, err := database.GetCurrentSensorValue("1", "P1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, current.Value, r.expected)
+	}
+
+	s, err := database.GetSensor("1", "P1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, s.RejectedValues, 2)
+}
+
+func TestAddSensorValue_ShouldAcceptStepChange_AfterConsecutiveOutliers(t *testing.T) {
+	database := CreateTestDatabase(t.Name())
+	err := database.AddSensor(&sensor.Sensor{
+		ID:       "P1",
+		DeviceID: "1",
+		Name:     "Filtered",
+		DataType: sensor.DataTypeFloat,
+		Type:     sensor.SensorTypeExternal,
+		IsActive: true,
+		Pipeline: sensor.PipelineOptions{MaxChange: 5},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := server.NewRouter(database, output.NewManager(), command.NewDispatcher(database, 1, 1), server.RouterOptions{})
+
+	post := func(v string) int {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/devices/1/sensors/P1/values", strings.NewReader(fmt.Sprintf(`{"value": "%s"}`, v))))
+		return w.Code
+	}
+
+	// A single spike is rejected and does not count towards a step change
+	assert.Equal(t, post("20"), 201)
+	assert.Equal(t, post("80"), 202)
+	assert.Equal(t, post("21"), 201)
+
+	// Two outliers are rejected in a batch, the third one on its own
+	body := `[
+		{"device_id": "1", "sensor_id": "P1", "value": "50"},
+		{"device_id": "1", "sensor_id": "P1", "value": "50.5"}
+	]`
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/values/batch", strings.NewReader(body)))
+	assert.Equal(t, w.Code, 200)
+	var response value.BatchResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, response.Rejected, 2)
+	assert.Equal(t, post("51"), 202)
+
+	// The sustained change is accepted and becomes the new reference
+	assert.Equal(t, post("50"), 201)
+	assert.Equal(t, post("52"), 201)
+
+	current, err := database.GetCurrentSensorValue("1", "P1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, current.Value, "52")
+
+	s, err := database.GetSensor("1", "P1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, s.RejectedValues, 4)
+	assert.Equal(t, s.Outliers, 0)
+}
+
 func TestAddSensorValue_ShouldReturn400_WhenTimestampIsInvalid(t *testing.T) {
 	body := `{
 		"value": "1.23",
@@ -158,7 +286,7 @@ func TestGetCurrentSensorValue_ShouldReturnSensorValue_WhenOneExists(t *testing.
 	if err != nil {
 		t.Error(err)
 	}
-	router := server.NewRouter(database, output.NewManager())
+	router := server.NewRouter(database, output.NewManager(), command.NewDispatcher(database, 1, 1), server.RouterOptions{})
 
 	req := httptest.NewRequest("GET", "/api/v1/devices/1/sensors/S1/current", nil)
 	router.ServeHTTP(w, req)
@@ -200,7 +328,7 @@ func TestGetSensorValues_ShouldReturnEmptyList_WhenLastValuesIsTooLongAgo(t *tes
 	if err != nil {
 		t.Error(err)
 	}
-	router := server.NewRouter(database, output.NewManager())
+	router := server.NewRouter(database, output.NewManager(), command.NewDispatcher(database, 1, 1), server.RouterOptions{})
 
 	req := httptest.NewRequest("GET", "/api/v1/devices/1/sensors/S1/values", nil)
 	router.ServeHTTP(w, req)
@@ -221,7 +349,7 @@ func TestGetSensorValues_ShouldReturnValues_WhenValuesAreInTimeFrame(t *testing.
 	if err != nil {
 		t.Error(err)
 	}
-	router := server.NewRouter(database, output.NewManager())
+	router := server.NewRouter(database, output.NewManager(), command.NewDispatcher(database, 1, 1), server.RouterOptions{})
 	req := httptest.NewRequest("GET", "/api/v1/devices/1/sensors/S1/values?timeframe=2h", nil)
 	router.ServeHTTP(w, req)
 
@@ -236,3 +364,362 @@ func TestGetSensorValues_ShouldReturnValues_WhenValuesAreInTimeFrame(t *testing.
 	assert.Equal(t, len(result), 1)
 	assert.Equal(t, result[0].Value, "1.23")
 }
+
+func TestGetSensorValues_ShouldConvertValues_WhenUnitIsGiven(t *testing.T) {
+	database := CreateTestDatabase(t.Name())
+	err := database.AddSensorValue(&value.SensorValue{SensorID: "S1", Value: "21.5", DeviceID: "1", Timestamp: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := server.NewRouter(database, output.NewManager(), command.NewDispatcher(database, 1, 1), server.RouterOptions{})
+
+	for _, url := range []string{"/api/v1/devices/1/sensors/S1/values?unit=fahrenheit", "/api/v1/devices/1/sensors/S1/current?unit=fahrenheit"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		assert.Equal(t, w.Code, 200)
+
+		body := w.Body.String()
+		if !strings.HasPrefix(body, "[") {
+			body = "[" + body + "]"
+		}
+
+		var result []value.SensorValue
+		if err := json.Unmarshal([]byte(body), &result); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, len(result), 1)
+		assert.Equal(t, result[0].Value, "70.7")
+		assert.Equal(t, result[0].Unit, "°F")
+	}
+}
+
+func TestGetCurrentSensorValue_ShouldReturn400_WhenUnitCannotBeConverted(t *testing.T) {
+	database := CreateTestDatabase(t.Name())
+	err := database.AddSensorValue(&value.SensorValue{SensorID: "S1", Value: "21.5", DeviceID: "1", Timestamp: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = database.AddSensor(&sensor.Sensor{ID: "S9", DeviceID: "1", Name: "Particles", DataType: sensor.DataTypeFloat, Type: sensor.SensorTypeExternal, Unit: "µg/m³"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = database.AddSensorValue(&value.SensorValue{SensorID: "S9", Value: "12", DeviceID: "1", Timestamp: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := server.NewRouter(database, output.NewManager(), command.NewDispatcher(database, 1, 1), server.RouterOptions{})
+
+	expected := map[string]string{
+		"/api/v1/devices/1/sensors/S1/current?unit=parsec": "Unknown unit parsec",
+		"/api/v1/devices/1/sensors/S1/current?unit=kWh":    "Cannot convert °C to kWh",
+		"/api/v1/devices/1/sensors/S9/current?unit=kWh":    "Cannot convert µg/m³ to kWh",
+	}
+
+	for url, message := range expected {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		assert.Equal(t, w.Code, 400)
+		assertErrorMessageEquals(t, w.Body.Bytes(), message)
+	}
+}
+
+func TestGetSensorValues_ShouldAggregateValues_WhenStepIsGiven(t *testing.T) {
+	database := CreateTestDatabase(t.Name())
+	start := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	for i, v := range []string{"1", "3", "5", "10", "20"} {
+		timestamp := start.Add(time.Duration(i) * 20 * time.Minute)
+		if err := database.AddSensorValue(&value.SensorValue{SensorID: "S1", Value: v, DeviceID: "1", Timestamp: timestamp}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	router := server.NewRouter(database, output.NewManager(), command.NewDispatcher(database, 1, 1), server.RouterOptions{})
+
+	expected := map[string][]string{
+		"avg":   {"3", "15"},
+		"min":   {"1", "10"},
+		"max":   {"5", "20"},
+		"sum":   {"9", "30"},
+		"count": {"3", "2"},
+		"last":  {"5", "20"},
+	}
+
+	for agg, values := range expected {
+		w := httptest.NewRecorder()
+		url := fmt.Sprintf("/api/v1/devices/1/sensors/S1/values?from=2023-05-01T12:00:00Z&to=2023-05-01T14:00:00Z&step=1h&agg=%s", agg)
+		router.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		assert.Equal(t, w.Code, 200)
+
+		var result []value.Bucket
+		if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, len(result), 2)
+		assert.Equal(t, result[0].Timestamp, start)
+		assert.Equal(t, result[0].Value, values[0], agg)
+		assert.Equal(t, result[0].Count, 3)
+		assert.Equal(t, result[1].Timestamp, start.Add(time.Hour))
+		assert.Equal(t, result[1].Value, values[1], agg)
+	}
+}
+
+func TestGetSensorValues_ShouldCompareTimestamps_InUTC(t *testing.T) {
+	database := CreateTestDatabase(t.Name())
+	berlin := time.FixedZone("CEST", 2*60*60)
+	timestamps := []time.Time{
+		time.Date(2023, 5, 1, 13, 30, 0, 0, berlin),
+		time.Date(2023, 5, 1, 12, 30, 0, 0, time.UTC),
+	}
+	for i, timestamp := range timestamps {
+		err := database.AddSensorValue(&value.SensorValue{SensorID: "S1", Value: fmt.Sprint(i), DeviceID: "1", Timestamp: timestamp})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	router := server.NewRouter(database, output.NewManager(), command.NewDispatcher(database, 1, 1), server.RouterOptions{})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/devices/1/sensors/S1/values?from=2023-05-01T14:00:00%2B02:00&to=2023-05-01T13:00:00Z", nil))
+	assert.Equal(t, w.Code, 200)
+
+	var result []value.SensorValue
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(result), 1)
+	assert.Equal(t, result[0].Value, "1")
+}
+
+func TestGetSensorValues_ShouldPageValues_WhenLimitIsGiven(t *testing.T) {
+	database := CreateTestDatabase(t.Name())
+	start := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		timestamp := start.Add(time.Duration(i/2) * time.Minute)
+		if err := database.AddSensorValue(&value.SensorValue{SensorID: "S1", Value: fmt.Sprint(i), DeviceID: "1", Timestamp: timestamp}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	router := server.NewRouter(database, output.NewManager(), command.NewDispatcher(database, 1, 1), server.RouterOptions{})
+
+	received := make([]string, 0)
+	url := "/api/v1/devices/1/sensors/S1/values?from=2023-05-01T12:00:00Z&to=2023-05-01T13:00:00Z&limit=2"
+	cursor := ""
+	for pages := 0; pages < 5; pages++ {
+		w := httptest.NewRecorder()
+		next := url
+		if cursor != "" {
+			next += "&cursor=" + cursor
+		}
+		router.ServeHTTP(w, httptest.NewRequest("GET", next, nil))
+		assert.Equal(t, w.Code, 200)
+
+		var result []value.SensorValue
+		if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range result {
+			received = append(received, v.Value)
+		}
+
+		cursor = w.Header().Get("X-Next-Cursor")
+		if cursor == "" {
+			break
+		}
+	}
+
+	assert.Equal(t, received, []string{"0", "1", "2", "3", "4"})
+}
+
+func TestGetSensorValues_ShouldReturn400_WhenQueryIsInvalid(t *testing.T) {
+	expected := map[string]string{
+		"from=yesterday": "yesterday is not a valid RFC3339 timestamp",
+		"from=2023-05-01T13:00:00Z&to=2023-05-01T12:00:00Z": "From must be before to",
+		"agg=avg":               "Aggregation requires a step",
+		"step=10ms":             "Step must be a duration of at least 1s",
+		"timeframe=48h&step=1s": "Step is too small, at most 10000 buckets are allowed",
+		"step=1m&agg=median":    "Aggregation must be one of avg, min, max, sum, count or last",
+		"step=1m&limit=10":      "Paging is only supported for raw values",
+		"limit=0":               "Limit must be between 1 and 10000",
+		"cursor=invalid":        "Invalid cursor",
+	}
+
+	for query, message := range expected {
+		w := RecordGetCall(t, "/api/v1/devices/1/sensors/S1/values?"+query)
+		assert.Equal(t, w.Code, 400, query)
+		assertErrorMessageEquals(t, w.Body.Bytes(), message)
+	}
+}
+
+func TestAddSensorValue_ShouldValidateEnumAndJsonValues(t *testing.T) {
+	database := CreateTestDatabase(t.Name())
+	sensors := []*sensor.Sensor{
+		{ID: "E1", DeviceID: "1", Name: "Mode", DataType: sensor.DataTypeEnum, Type: sensor.SensorTypeExternal, IsActive: true, AllowedValues: []string{"off", "heating", "cooling"}},
+		{ID: "J1", DeviceID: "1", Name: "Climate", DataType: sensor.DataTypeJson, Type: sensor.SensorTypeExternal, IsActive: true, Schema: sensor.Schema{"temp": sensor.DataTypeFloat, "hum": sensor.DataTypeInt}},
+	}
+	for _, s := range sensors {
+		if err := database.AddSensor(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	router := server.NewRouter(database, output.NewManager(), command.NewDispatcher(database, 1, 1), server.RouterOptions{})
+
+	requests := []struct {
+		sensor  string
+		body    string
+		code    int
+		message string
+	}{
+		{"E1", `{"value": "heating"}`, 201, ""},
+		{"E1", `{"value": "boiling"}`, 400, "Sensor value must be one of off, heating, cooling"},
+		{"J1", `{"value": {"temp": 21.3, "hum": 45}}`, 201, ""},
+		{"J1", `{"value": "{\"temp\": \"warm\"}"}`, 400, "Field temp is not a float"},
+		{"J1", `{"value": {"hum": 45.5}}`, 400, "Field hum is not an int"},
+		{"J1", `{"value": [1, 2]}`, 400, "Sensor value is not a json object"},
+	}
+
+	for _, r := range requests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/devices/1/sensors/"+r.sensor+"/values", strings.NewReader(r.body)))
+		assert.Equal(t, w.Code, r.code)
+		if r.message != "" {
+			assertErrorMessageEquals(t, w.Body.Bytes(), r.message)
+		}
+	}
+
+	current, err := database.GetCurrentSensorValue("1", "J1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, current.Value, `{"temp":21.3,"hum":45}`)
+}
+
+type recordingBinding struct {
+	values []output.BindingValue
+}
+
+func (b *recordingBinding) Handle(value output.BindingValue) {
+	b.values = append(b.values, value)
+}
+
+func TestPostSensorValues_ShouldStoreValidValues_AndReportInvalidOnes(t *testing.T) {
+	database := CreateTestDatabase(t.Name())
+	bindings := output.NewManager()
+	recorder := &recordingBinding{}
+	bindings.Register(recorder)
+	router := server.NewRouter(database, bindings, command.NewDispatcher(database, 1, 1), server.RouterOptions{})
+
+	body := `[
+		{"device_id": "1", "sensor_id": "S1", "value": "21.5", "timestamp": "2023-01-01T12:10:00Z"},
+		{"device_id": "1", "sensor_id": "S1", "value": "warm", "timestamp": "2023-01-01T12:05:00Z"},
+		{"device_id": "1", "sensor_id": "S9", "value": "1"},
+		{"device_id": "2", "sensor_id": "S3", "value": 42, "timestamp": "2023-01-01T12:00:00Z"},
+		{"device_id": "1", "sensor_id": "S1", "value": "20.5", "timestamp": "2023-01-01T12:00:00Z"}
+	]`
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/values/batch", strings.NewReader(body)))
+	assert.Equal(t, w.Code, 200)
+
+	var response value.BatchResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, response.Created, 3)
+	assert.Equal(t, response.Invalid, 2)
+	assert.Equal(t, response.Results[1].Status, value.BatchStatusInvalid)
+	assert.Equal(t, response.Results[1].Error, "Sensor value is not a float")
+	assert.Equal(t, response.Results[2].Error, "Sensor not found")
+	assert.Equal(t, response.Results[3].Value.Value, "42")
+
+	current, err := database.GetCurrentSensorValue("1", "S1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, current.Value, "21.5")
+
+	pushed := make([]string, 0)
+	for _, v := range recorder.values {
+		pushed = append(pushed, v.SensorID+"="+v.Value)
+	}
+	assert.Equal(t, strings.Join(pushed, ","), "S3=42,S1=20.5,S1=21.5")
+}
+
+func TestPostSensorValues_ShouldCountRejectedValues(t *testing.T) {
+	database := CreateTestDatabase(t.Name())
+	err := database.AddSensor(&sensor.Sensor{
+		ID:       "P1",
+		DeviceID: "1",
+		Name:     "Filtered",
+		DataType: sensor.DataTypeFloat,
+		Type:     sensor.SensorTypeExternal,
+		IsActive: true,
+		Pipeline: sensor.PipelineOptions{DeadBand: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := server.NewRouter(database, output.NewManager(), command.NewDispatcher(database, 1, 1), server.RouterOptions{})
+
+	body := `[
+		{"device_id": "1", "sensor_id": "P1", "value": "20", "timestamp": "2023-01-01T12:00:00Z"},
+		{"device_id": "1", "sensor_id": "P1", "value": "20.5", "timestamp": "2023-01-01T12:01:00Z"},
+		{"device_id": "1", "sensor_id": "P1", "value": "20.2", "timestamp": "2023-01-01T12:02:00Z"},
+		{"device_id": "1", "sensor_id": "P1", "value": "22", "timestamp": "2023-01-01T12:03:00Z"}
+	]`
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/values/batch", strings.NewReader(body)))
+	assert.Equal(t, w.Code, 200)
+
+	var response value.BatchResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, response.Created, 2)
+	assert.Equal(t, response.Rejected, 2)
+
+	s, err := database.GetSensor("1", "P1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, s.RejectedValues, 2)
+}
+
+func TestPostSensorValues_ShouldReturn400_WhenBatchIsTooLarge(t *testing.T) {
+	// The body is cut off after the limit, so it is only refused with the
+	// right message, if the array is not decoded as a whole.
+	body := "[" + strings.Repeat(`{"device_id": "1", "sensor_id": "S1", "value": "1"},`, 10001) + "not json"
+
+	w := RecordPostCall(t, "/api/v1/values/batch", body)
+
+	assert.Equal(t, w.Code, 400)
+	assertErrorMessageEquals(t, w.Body.Bytes(), "Too many values, at most 10000 are allowed per request")
+}
+
+func TestPostSensorValues_ShouldAcceptNdjson(t *testing.T) {
+	body := `{"device_id": "1", "sensor_id": "S1", "value": "1.5"}
+{"device_id": "2", "sensor_id": "S3", "value": "7"}
+`
+	database := CreateTestDatabase(t.Name())
+	router := server.NewRouter(database, output.NewManager(), command.NewDispatcher(database, 1, 1), server.RouterOptions{})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/api/v1/values/batch", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, w.Code, 200)
+
+	current, err := database.GetCurrentSensorValue("2", "S3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, current.Value, "7")
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "/api/v1/values/batch", strings.NewReader("{\"device_id\": \"1\"}\nnot json\n"))
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, w.Code, 400)
+	assertErrorMessageEquals(t, w.Body.Bytes(), "Invalid batch: line 2: invalid character 'o' in literal null (expecting 'u')")
+}